@@ -2,21 +2,58 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"eino-rag/memory"
+	"eino-rag/transcript"
 
 	"github.com/cloudwego/eino/schema"
 )
 
+// 历史消息的 token 预算，超出部分会被折叠为摘要
+const HistoryMaxTokens = 6000
+
 func main() {
+	importPath := flag.String("import", "", "seed the conversation from a JSON or OpenAI messages file")
+	exportPath := flag.String("export", "", "write the conversation to this file after every turn")
+	exportFormat := flag.String("format", "", "export format: markdown, json or openai (default: by file extension)")
+	flag.Parse()
+
+	format := transcript.FormatForPath(*exportPath)
+	if *exportFormat != "" {
+		f, err := transcript.ParseFormat(*exportFormat)
+		if err != nil {
+			log.Fatal(err)
+		}
+		format = f
+	}
+
 	ctx := context.Background()
 	cm := createOpenAIChatModel(ctx)
 
 	var chatHistory []*schema.Message
+	if *importPath != "" {
+		chatHistory = importHistory(*importPath)
+		fmt.Printf("imported %d messages from %s\n", len(chatHistory), *importPath)
+	}
+	var memState memory.State
+	mem := memory.NewManager(&memory.Config{MaxTokens: HistoryMaxTokens, Model: cm})
 
 	for {
 		fmt.Print("\nuser: ")
 		userInput := ReadUserInput()
-		messages := buildMessages(chatHistory, userInput)
+
+		// 只把预算内的最近几轮对话和更早对话的摘要发送给模型
+		window, state, err := mem.Window(ctx, chatHistory, memState)
+		if err != nil {
+			log.Printf("summarize history failed: %v", err)
+		}
+		memState = state
+		messages := buildMessages(window, userInput)
 
 		// 流式获取回复
 		streamResult := stream(ctx, cm, messages)
@@ -24,6 +61,50 @@ func main() {
 
 		// 追加到历史
 		chatHistory = AppendHistory(chatHistory, userInput, assistantMsg)
+
+		if *exportPath != "" {
+			if err := exportHistory(*exportPath, format, chatHistory); err != nil {
+				log.Printf("export conversation failed: %v", err)
+			}
+		}
+	}
+
+}
+
+// 读取导出的对话作为初始历史，系统消息由模板提供，因此跳过
+func importHistory(path string) []*schema.Message {
+	f, err := os.Open(path)
+	if err != nil {
+		log.Fatalf("open %s failed: %v", path, err)
+	}
+	defer f.Close()
+	t, err := transcript.Read(f)
+	if err != nil {
+		log.Fatalf("import %s failed: %v", path, err)
+	}
+	var history []*schema.Message
+	for _, msg := range t.Messages {
+		if msg.Role == schema.User || msg.Role == schema.Assistant {
+			history = append(history, &schema.Message{Role: msg.Role, Content: msg.Content})
+		}
 	}
+	return history
+}
 
+// 将当前对话写入文件，先写临时文件再重命名，避免中断时留下不完整的内容
+func exportHistory(path string, format transcript.Format, history []*schema.Message) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	err = transcript.Write(f, format, &transcript.Transcript{ExportedAt: time.Now(), Messages: history})
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
 }
@@ -8,30 +8,50 @@ import (
 	"os"
 	"strings"
 
+	"eino-rag/reasoning"
+
 	"github.com/cloudwego/eino/schema"
 )
 
+// 用于在终端中暗色显示思考过程的 ANSI 转义序列
+const (
+	ansiDim   = "\033[2m"
+	ansiReset = "\033[0m"
+)
+
 func reportStream(sr *schema.StreamReader[*schema.Message]) string {
 	defer sr.Close()
 	var assistantMsg string
 
+	var splitter reasoning.Splitter
+	var thought bool
+
 	fmt.Print("assistant: ")
+	// 思考过程以暗色显示，且不计入返回的回答
+	show := func(d reasoning.Delta) {
+		if d.Reasoning != "" {
+			thought = true
+			fmt.Print(ansiDim + d.Reasoning + ansiReset)
+		}
+		if d.Content != "" {
+			if assistantMsg == "" && thought {
+				fmt.Println()
+			}
+			fmt.Print(d.Content)
+			assistantMsg += d.Content
+		}
+	}
 	// 逐条接收消息并打印
 	for {
 		message, err := sr.Recv()
 		if err == io.EOF {
+			show(splitter.Flush())
 			return assistantMsg
 		}
 		if err != nil {
 			log.Fatalf("recv failed: %v", err)
 		}
-		content := message.Content
-		// 去除开头的2个换行
-		if assistantMsg == "" {
-			content = strings.TrimLeft(content, "\n")
-		}
-		fmt.Print(content)
-		assistantMsg += content
+		show(splitter.Push(message))
 	}
 }
 
@@ -0,0 +1,105 @@
+package fallback
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultFailureThreshold is used when BreakerConfig.FailureThreshold is zero
+	DefaultFailureThreshold = 3
+	// DefaultOpenTimeout is used when BreakerConfig.OpenTimeout is zero
+	DefaultOpenTimeout = 30 * time.Second
+)
+
+// ErrCircuitOpen is reported for providers skipped by their breaker
+var ErrCircuitOpen = errors.New("circuit open")
+
+// BreakerConfig configures a Breaker
+type BreakerConfig struct {
+	// FailureThreshold is the number of consecutive failures that opens the
+	// circuit. Optional. Default: DefaultFailureThreshold
+	FailureThreshold int
+	// OpenTimeout is how long an open circuit rejects calls before a single
+	// trial call is let through. Optional. Default: DefaultOpenTimeout
+	OpenTimeout time.Duration
+}
+
+// Breaker is a circuit breaker. It is closed while the provider works, opens
+// after FailureThreshold consecutive failures and, once OpenTimeout has
+// passed, lets one trial call through whose outcome closes or reopens it.
+type Breaker struct {
+	threshold   int
+	openTimeout time.Duration
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time // zero while closed
+	trial    bool      // a trial call of the half-open circuit is running
+}
+
+// NewBreaker creates a closed breaker
+func NewBreaker(cfg *BreakerConfig) *Breaker {
+	b := &Breaker{threshold: DefaultFailureThreshold, openTimeout: DefaultOpenTimeout}
+	if cfg != nil {
+		if cfg.FailureThreshold > 0 {
+			b.threshold = cfg.FailureThreshold
+		}
+		if cfg.OpenTimeout > 0 {
+			b.openTimeout = cfg.OpenTimeout
+		}
+	}
+	return b
+}
+
+// Allow reports whether a call may go ahead. Every allowed call must be
+// followed by Success, Failure or Release.
+func (b *Breaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.openedAt.IsZero() {
+		return true
+	}
+	if b.trial || time.Since(b.openedAt) < b.openTimeout {
+		return false
+	}
+	b.trial = true
+	return true
+}
+
+// Success closes the circuit
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.openedAt = time.Time{}
+	b.trial = false
+}
+
+// Failure counts a failed call, opening the circuit at the threshold or when
+// the trial call of a half-open circuit fails
+func (b *Breaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.trial || b.failures >= b.threshold {
+		b.openedAt = time.Now()
+	}
+	b.trial = false
+}
+
+// Release ends a call whose outcome says nothing about the provider, such
+// as one cancelled by the caller
+func (b *Breaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+}
+
+// Open reports whether the circuit currently rejects calls
+func (b *Breaker) Open() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return !b.openedAt.IsZero() && (b.trial || time.Since(b.openedAt) < b.openTimeout)
+}
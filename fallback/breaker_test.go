@@ -0,0 +1,45 @@
+package fallback
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	const timeout = 20 * time.Millisecond
+	b := NewBreaker(&BreakerConfig{FailureThreshold: 2, OpenTimeout: timeout})
+
+	check := func(step string, allow, open bool) {
+		t.Helper()
+		if got := b.Open(); got != open {
+			t.Errorf("%s: Open() = %v, want %v", step, got, open)
+		}
+		if got := b.Allow(); got != allow {
+			t.Errorf("%s: Allow() = %v, want %v", step, got, allow)
+		}
+	}
+
+	check("new", true, false)
+	b.Failure()
+	check("one failure", true, false)
+	b.Success()
+	b.Failure()
+	check("success resets the count", true, false)
+	b.Failure()
+	check("threshold reached", false, true)
+
+	time.Sleep(timeout + 10*time.Millisecond)
+	check("half-open lets a trial call through", true, false)
+	check("while the trial runs", false, true)
+	b.Failure()
+	check("failed trial reopens", false, true)
+
+	time.Sleep(timeout + 10*time.Millisecond)
+	check("half-open again", true, false)
+	b.Release()
+	check("released trial lets another through", true, false)
+	b.Success()
+	check("successful trial closes", true, false)
+	b.Failure()
+	check("count starts over", true, false)
+}
@@ -0,0 +1,302 @@
+// Package fallback chains several chat models behind a single
+// model.ToolCallingChatModel. Providers are tried in order: transient errors
+// are retried with exponential backoff until the first token arrives, after
+// which the answer is committed to that provider. A circuit breaker skips
+// providers that keep failing, and the provider that answered is recorded in
+// the message's Extra under ProviderKey.
+package fallback
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"time"
+
+	"github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/schema"
+	goopenai "github.com/meguminnnnnnnnn/go-openai"
+)
+
+// ProviderKey is the Extra key of the provider that answered
+const ProviderKey = "provider"
+
+const (
+	// DefaultMaxRetries is used when Config.MaxRetries is zero
+	DefaultMaxRetries = 2
+	// DefaultInitialBackoff is used when Config.InitialBackoff is zero
+	DefaultInitialBackoff = 500 * time.Millisecond
+	// DefaultMaxBackoff is used when Config.MaxBackoff is zero
+	DefaultMaxBackoff = 8 * time.Second
+)
+
+// Provider is one chat model of the chain
+type Provider struct {
+	// Name identifies the provider in Extra and in errors
+	Name  string
+	Model model.ToolCallingChatModel
+	// Breaker tracks the health of the provider. Optional: a breaker with the
+	// default settings is created when nil. Share a breaker between chains to
+	// share what they learn about the provider.
+	Breaker *Breaker
+}
+
+// Config configures a ChatModel
+type Config struct {
+	// MaxRetries is how many times a provider is retried after a transient
+	// error before moving on to the next one. Negative disables retries.
+	// Optional. Default: DefaultMaxRetries
+	MaxRetries int
+	// InitialBackoff is the wait before the first retry; it doubles with every
+	// further retry. Optional. Default: DefaultInitialBackoff
+	InitialBackoff time.Duration
+	// MaxBackoff caps the wait between retries. Optional. Default: DefaultMaxBackoff
+	MaxBackoff time.Duration
+	// OnError is called for every failed attempt, e.g. for logging. Optional.
+	OnError func(provider string, attempt int, err error)
+}
+
+// ChatModel tries its providers in order until one of them answers
+type ChatModel struct {
+	providers []Provider
+	cfg       Config
+}
+
+var _ model.ToolCallingChatModel = (*ChatModel)(nil)
+
+// NewChatModel creates a chain of the given providers
+func NewChatModel(cfg *Config, providers ...Provider) (*ChatModel, error) {
+	if len(providers) == 0 {
+		return nil, errors.New("fallback: no providers")
+	}
+	m := &ChatModel{cfg: Config{MaxRetries: DefaultMaxRetries, InitialBackoff: DefaultInitialBackoff, MaxBackoff: DefaultMaxBackoff}}
+	if cfg != nil {
+		if cfg.MaxRetries != 0 {
+			m.cfg.MaxRetries = max(cfg.MaxRetries, 0)
+		}
+		if cfg.InitialBackoff > 0 {
+			m.cfg.InitialBackoff = cfg.InitialBackoff
+		}
+		if cfg.MaxBackoff > 0 {
+			m.cfg.MaxBackoff = cfg.MaxBackoff
+		}
+		m.cfg.OnError = cfg.OnError
+	}
+	m.providers = make([]Provider, len(providers))
+	for i, p := range providers {
+		if p.Model == nil {
+			return nil, fmt.Errorf("fallback: provider %q has no model", p.Name)
+		}
+		if p.Breaker == nil {
+			p.Breaker = NewBreaker(nil)
+		}
+		m.providers[i] = p
+	}
+	return m, nil
+}
+
+// GetType implements components.Typer
+func (m *ChatModel) GetType() string { return "Fallback" }
+
+// IsCallbacksEnabled implements components.Checker: callbacks are run by
+// the models of the providers, once per attempt
+func (m *ChatModel) IsCallbacksEnabled() bool { return true }
+
+// WithTools returns a chain whose providers have the tools bound. The
+// breakers are shared with m.
+func (m *ChatModel) WithTools(tools []*schema.ToolInfo) (model.ToolCallingChatModel, error) {
+	providers := make([]Provider, len(m.providers))
+	for i, p := range m.providers {
+		bound, err := p.Model.WithTools(tools)
+		if err != nil {
+			return nil, fmt.Errorf("fallback: bind tools to %s: %w", p.Name, err)
+		}
+		p.Model = bound
+		providers[i] = p
+	}
+	return &ChatModel{providers: providers, cfg: m.cfg}, nil
+}
+
+// Generate returns the answer of the first provider that succeeds
+func (m *ChatModel) Generate(ctx context.Context, in []*schema.Message, opts ...model.Option) (*schema.Message, error) {
+	var out *schema.Message
+	err := m.try(ctx, func(p Provider) error {
+		msg, err := p.Model.Generate(ctx, in, opts...)
+		if err != nil {
+			return err
+		}
+		out = withProvider(msg, p.Name)
+		return nil
+	})
+	return out, err
+}
+
+// Stream returns the stream of the first provider that produces a token.
+// Errors after that are passed on to the reader as they are.
+func (m *ChatModel) Stream(ctx context.Context, in []*schema.Message, opts ...model.Option) (*schema.StreamReader[*schema.Message], error) {
+	var out *schema.StreamReader[*schema.Message]
+	err := m.try(ctx, func(p Provider) error {
+		sr, err := p.Model.Stream(ctx, in, opts...)
+		if err != nil {
+			return err
+		}
+		// Role-only chunks come before the first token, so they are held back
+		// until it is clear the provider answers
+		var head []*schema.Message
+		for {
+			chunk, err := sr.Recv()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				sr.Close()
+				return err
+			}
+			head = append(head, chunk)
+			if hasToken(chunk) {
+				break
+			}
+		}
+		if len(head) == 0 {
+			head = append(head, &schema.Message{Role: schema.Assistant})
+		}
+		head[0] = withProvider(head[0], p.Name)
+		out = replay(head, sr)
+		return nil
+	})
+	return out, err
+}
+
+// try runs call against each provider in turn, retrying transient errors
+func (m *ChatModel) try(ctx context.Context, call func(Provider) error) error {
+	var errs []error
+	for _, p := range m.providers {
+		for attempt := 0; ; attempt++ {
+			if !p.Breaker.Allow() {
+				errs = append(errs, fmt.Errorf("%s: %w", p.Name, ErrCircuitOpen))
+				break
+			}
+			err := call(p)
+			if err == nil {
+				p.Breaker.Success()
+				return nil
+			}
+			// The caller gave up; that says nothing about the provider
+			if ctx.Err() != nil {
+				p.Breaker.Release()
+				return ctx.Err()
+			}
+			if m.cfg.OnError != nil {
+				m.cfg.OnError(p.Name, attempt, err)
+			}
+			kind := classify(err)
+			if kind == requestError {
+				p.Breaker.Release()
+			} else {
+				p.Breaker.Failure()
+			}
+			if kind != transientError || attempt >= m.cfg.MaxRetries {
+				errs = append(errs, fmt.Errorf("%s: %w", p.Name, err))
+				break
+			}
+			if err := sleep(ctx, m.backoff(attempt)); err != nil {
+				return err
+			}
+		}
+	}
+	return fmt.Errorf("fallback: all providers failed: %w", errors.Join(errs...))
+}
+
+// backoff returns the wait before retry attempt+1, with jitter
+func (m *ChatModel) backoff(attempt int) time.Duration {
+	d := m.cfg.InitialBackoff << attempt
+	if d <= 0 || d > m.cfg.MaxBackoff {
+		d = m.cfg.MaxBackoff
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type errorKind int
+
+const (
+	// transientError may go away on a retry
+	transientError errorKind = iota
+	// providerError will not go away on a retry, e.g. a rejected API key
+	providerError
+	// requestError is caused by the request rather than the provider; another
+	// provider may still accept it
+	requestError
+)
+
+func classify(err error) errorKind {
+	status := 0
+	var apiErr *goopenai.APIError
+	var reqErr *goopenai.RequestError
+	switch {
+	case errors.As(err, &apiErr):
+		status = apiErr.HTTPStatusCode
+	case errors.As(err, &reqErr):
+		status = reqErr.HTTPStatusCode
+	}
+	switch {
+	case status == 0, status == http.StatusRequestTimeout, status == http.StatusTooManyRequests, status >= 500:
+		// Connection errors and timeouts carry no status
+		return transientError
+	case status == http.StatusUnauthorized, status == http.StatusForbidden, status == http.StatusNotFound:
+		return providerError
+	}
+	return requestError
+}
+
+// hasToken reports whether a chunk carries part of the answer
+func hasToken(msg *schema.Message) bool {
+	return msg.Content != "" || msg.ReasoningContent != "" || len(msg.ToolCalls) > 0
+}
+
+// withProvider returns a copy of msg with the provider recorded in Extra
+func withProvider(msg *schema.Message, name string) *schema.Message {
+	cp := *msg
+	cp.Extra = make(map[string]any, len(msg.Extra)+1)
+	for k, v := range msg.Extra {
+		cp.Extra[k] = v
+	}
+	cp.Extra[ProviderKey] = name
+	return &cp
+}
+
+// replay returns a stream of the held back chunks followed by the rest of sr
+func replay(head []*schema.Message, sr *schema.StreamReader[*schema.Message]) *schema.StreamReader[*schema.Message] {
+	out, w := schema.Pipe[*schema.Message](len(head))
+	go func() {
+		defer w.Close()
+		defer sr.Close()
+		for _, chunk := range head {
+			if w.Send(chunk, nil) {
+				return
+			}
+		}
+		for {
+			chunk, err := sr.Recv()
+			if err == io.EOF {
+				return
+			}
+			if w.Send(chunk, err) || err != nil {
+				return
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,228 @@
+package fallback
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/schema"
+	goopenai "github.com/meguminnnnnnnnn/go-openai"
+)
+
+// reply is one scripted answer of a fakeModel
+type reply struct {
+	// err is returned by Generate or Stream
+	err error
+	// chunks are the streamed content; Generate joins them
+	chunks []string
+	// streamErr is sent after the chunks
+	streamErr error
+}
+
+// fakeModel answers with its replies in turn, repeating the last one
+type fakeModel struct {
+	replies []reply
+
+	mu    sync.Mutex
+	calls int
+}
+
+func (f *fakeModel) next() reply {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	r := f.replies[min(f.calls, len(f.replies)-1)]
+	f.calls++
+	return r
+}
+
+func (f *fakeModel) Calls() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls
+}
+
+func (f *fakeModel) Generate(ctx context.Context, input []*schema.Message, opts ...model.Option) (*schema.Message, error) {
+	r := f.next()
+	if r.err != nil {
+		return nil, r.err
+	}
+	if r.streamErr != nil {
+		return nil, r.streamErr
+	}
+	return schema.AssistantMessage(strings.Join(r.chunks, ""), nil), nil
+}
+
+func (f *fakeModel) Stream(ctx context.Context, input []*schema.Message, opts ...model.Option) (*schema.StreamReader[*schema.Message], error) {
+	r := f.next()
+	if r.err != nil {
+		return nil, r.err
+	}
+	sr, w := schema.Pipe[*schema.Message](len(r.chunks) + 2)
+	w.Send(&schema.Message{Role: schema.Assistant}, nil)
+	for _, c := range r.chunks {
+		w.Send(&schema.Message{Role: schema.Assistant, Content: c}, nil)
+	}
+	if r.streamErr != nil {
+		w.Send(nil, r.streamErr)
+	}
+	w.Close()
+	return sr, nil
+}
+
+func (f *fakeModel) WithTools(tools []*schema.ToolInfo) (model.ToolCallingChatModel, error) {
+	return f, nil
+}
+
+func ok(chunks ...string) reply { return reply{chunks: chunks} }
+
+func status(code int) reply {
+	return reply{err: fmt.Errorf("call: %w", &goopenai.APIError{HTTPStatusCode: code, Message: http.StatusText(code)})}
+}
+
+var testConfig = &Config{MaxRetries: 2, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+
+func newTestChain(t *testing.T, primary, secondary *fakeModel) *ChatModel {
+	t.Helper()
+	m, err := NewChatModel(testConfig, Provider{Name: "primary", Model: primary}, Provider{Name: "secondary", Model: secondary})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestGenerateFallback(t *testing.T) {
+	tests := []struct {
+		name      string
+		primary   []reply
+		secondary []reply
+		provider  string
+		calls     [2]int
+		err       string
+	}{
+		{"answer", []reply{ok("a")}, []reply{ok("b")}, "primary", [2]int{1, 0}, ""},
+		{"503 is retried", []reply{status(503), ok("a")}, []reply{ok("b")}, "primary", [2]int{2, 0}, ""},
+		{"429 is retried", []reply{status(429), ok("a")}, []reply{ok("b")}, "primary", [2]int{2, 0}, ""},
+		{"408 is retried", []reply{status(408), ok("a")}, []reply{ok("b")}, "primary", [2]int{2, 0}, ""},
+		{"connection error is retried", []reply{{err: errors.New("connection reset")}, ok("a")}, []reply{ok("b")}, "primary", [2]int{2, 0}, ""},
+		{"retries run out", []reply{status(500)}, []reply{ok("b")}, "secondary", [2]int{3, 1}, ""},
+		{"401 is not retried", []reply{status(401), ok("a")}, []reply{ok("b")}, "secondary", [2]int{1, 1}, ""},
+		{"404 is not retried", []reply{status(404), ok("a")}, []reply{ok("b")}, "secondary", [2]int{1, 1}, ""},
+		{"400 is not retried", []reply{status(400), ok("a")}, []reply{ok("b")}, "secondary", [2]int{1, 1}, ""},
+		{"all fail", []reply{status(403)}, []reply{status(400)}, "", [2]int{1, 1}, "primary: call: error, status code: 403"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			primary, secondary := &fakeModel{replies: tc.primary}, &fakeModel{replies: tc.secondary}
+			msg, err := newTestChain(t, primary, secondary).Generate(context.Background(), nil)
+			if calls := [2]int{primary.Calls(), secondary.Calls()}; calls != tc.calls {
+				t.Errorf("calls = %v, want %v", calls, tc.calls)
+			}
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) || !strings.Contains(err.Error(), "secondary:") {
+					t.Errorf("error = %v, want it to name both providers and contain %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if msg.Extra[ProviderKey] != tc.provider {
+				t.Errorf("provider = %v, want %s", msg.Extra[ProviderKey], tc.provider)
+			}
+		})
+	}
+}
+
+// readStream collects the content of a stream and the error that ended it
+func readStream(sr *schema.StreamReader[*schema.Message]) (content string, provider any, err error) {
+	defer sr.Close()
+	var b strings.Builder
+	for i := 0; ; i++ {
+		chunk, err := sr.Recv()
+		if err == io.EOF {
+			return b.String(), provider, nil
+		}
+		if err != nil {
+			return b.String(), provider, err
+		}
+		if i == 0 {
+			provider = chunk.Extra[ProviderKey]
+		}
+		b.WriteString(chunk.Content)
+	}
+}
+
+func TestStreamFallback(t *testing.T) {
+	broken := errors.New("stream broken")
+	tests := []struct {
+		name      string
+		primary   []reply
+		secondary []reply
+		content   string
+		provider  string
+		calls     [2]int
+		streamErr error
+	}{
+		{"answer", []reply{ok("Hel", "lo")}, []reply{ok("b")}, "Hello", "primary", [2]int{1, 0}, nil},
+		{"error before the stream is retried", []reply{status(502), ok("a")}, []reply{ok("b")}, "a", "primary", [2]int{2, 0}, nil},
+		{"error before the first token switches", []reply{{streamErr: &goopenai.APIError{HTTPStatusCode: 401}}}, []reply{ok("b")}, "b", "secondary", [2]int{1, 1}, nil},
+		{"error after the first token is passed on", []reply{{chunks: []string{"Hel"}, streamErr: broken}}, []reply{ok("b")}, "Hel", "primary", [2]int{1, 0}, broken},
+		{"empty answer", []reply{ok()}, []reply{ok("b")}, "", "primary", [2]int{1, 0}, nil},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			primary, secondary := &fakeModel{replies: tc.primary}, &fakeModel{replies: tc.secondary}
+			sr, err := newTestChain(t, primary, secondary).Stream(context.Background(), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			content, provider, err := readStream(sr)
+			if !errors.Is(err, tc.streamErr) {
+				t.Errorf("stream error = %v, want %v", err, tc.streamErr)
+			}
+			if content != tc.content || provider != tc.provider {
+				t.Errorf("stream = %q from %v, want %q from %s", content, provider, tc.content, tc.provider)
+			}
+			if calls := [2]int{primary.Calls(), secondary.Calls()}; calls != tc.calls {
+				t.Errorf("calls = %v, want %v", calls, tc.calls)
+			}
+		})
+	}
+}
+
+func TestOpenCircuitSkipsProvider(t *testing.T) {
+	primary, secondary := &fakeModel{replies: []reply{status(401), ok("a")}}, &fakeModel{replies: []reply{ok("b")}}
+	breaker := NewBreaker(&BreakerConfig{FailureThreshold: 1, OpenTimeout: 20 * time.Millisecond})
+	m, err := NewChatModel(testConfig, Provider{Name: "primary", Model: primary, Breaker: breaker}, Provider{Name: "secondary", Model: secondary})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{"secondary", "secondary"} {
+		msg, err := m.Generate(context.Background(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.Extra[ProviderKey] != want {
+			t.Errorf("call %d answered by %v, want %s", i, msg.Extra[ProviderKey], want)
+		}
+	}
+	if primary.Calls() != 1 {
+		t.Errorf("primary called %d times while its circuit was open", primary.Calls())
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	msg, err := m.Generate(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Extra[ProviderKey] != "primary" || breaker.Open() {
+		t.Errorf("after the open timeout the call went to %v, circuit open %v", msg.Extra[ProviderKey], breaker.Open())
+	}
+}
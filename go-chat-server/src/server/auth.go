@@ -0,0 +1,398 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Roles a user or token can have. Only admins may change the configuration.
+const (
+	roleUser  = "user"
+	roleAdmin = "admin"
+)
+
+const (
+	authCookieName  = "auth_token"
+	defaultTokenTTL = 24 * time.Hour
+
+	// Password hashes are stored as pbkdf2-sha256$<iterations>$<salt>$<key>
+	passwordHashScheme     = "pbkdf2-sha256"
+	passwordHashIterations = 600000
+	passwordSaltLen        = 16
+	passwordKeyLen         = 32
+)
+
+var errInvalidToken = errors.New("invalid or expired token")
+
+// AuthConfig configures authentication. It is disabled unless at least one
+// user or token is configured.
+type AuthConfig struct {
+	Users  []AuthUser  `json:"users,omitempty"`
+	Tokens []AuthToken `json:"tokens,omitempty"`
+	// SessionSecret signs the tokens issued by /login. If empty a random
+	// secret is used and users have to sign in again after a restart.
+	SessionSecret string `json:"session_secret,omitempty"`
+	// TokenTTL is how long a login token is valid, e.g. "12h". Default: 24h
+	TokenTTL string `json:"token_ttl,omitempty"`
+}
+
+// AuthUser is an account that signs in with a password through /login
+type AuthUser struct {
+	Name string `json:"name"`
+	// PasswordHash is created with the -hash-password flag
+	PasswordHash string `json:"password_hash"`
+	Role         string `json:"role,omitempty"`
+}
+
+// AuthToken is a static bearer token, e.g. for scripts or OpenAI SDK clients
+type AuthToken struct {
+	// Name owns the sessions created with the token; it may not be a user name
+	Name  string `json:"name"`
+	Token string `json:"token"`
+	Role  string `json:"role,omitempty"`
+}
+
+// Enabled reports whether requests must be authenticated
+func (c AuthConfig) Enabled() bool {
+	return len(c.Users) > 0 || len(c.Tokens) > 0
+}
+
+// GetAuthConfig returns the current authentication settings
+func GetAuthConfig() AuthConfig {
+	configLock.RLock()
+	defer configLock.RUnlock()
+	return config.Auth
+}
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	Name string `json:"name"`
+	Role string `json:"role"`
+}
+
+// IsAdmin reports whether the caller may change the configuration
+func (p *Principal) IsAdmin() bool {
+	return p.Role == roleAdmin
+}
+
+type principalKey struct{}
+
+// principalFrom returns the caller of a request, or nil if auth is disabled
+func principalFrom(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
+
+// requestOwner returns the name sessions created by the caller are owned by;
+// empty when auth is disabled
+func requestOwner(r *http.Request) string {
+	if p := principalFrom(r.Context()); p != nil {
+		return p.Name
+	}
+	return ""
+}
+
+func normalizeRole(role string) string {
+	if role == roleAdmin {
+		return roleAdmin
+	}
+	return roleUser
+}
+
+// hashPassword derives a salted PBKDF2 hash to put in AuthUser.PasswordHash
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, passwordSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, passwordHashIterations, passwordKeyLen)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s$%d$%s$%s", passwordHashScheme, passwordHashIterations,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// verifyPassword checks a password against a hash made by hashPassword
+func verifyPassword(hash, password string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != passwordHashScheme {
+		return false
+	}
+	iter, err := strconv.Atoi(parts[1])
+	if err != nil || iter <= 0 {
+		return false
+	}
+	salt, err1 := base64.RawStdEncoding.DecodeString(parts[2])
+	want, err2 := base64.RawStdEncoding.DecodeString(parts[3])
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	got, err := pbkdf2.Key(sha256.New, password, salt, iter, len(want))
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(got, want) == 1
+}
+
+// dummyPasswordHash is checked for unknown users so that a failed login
+// takes as long whether or not the user exists
+var dummyPasswordHash = sync.OnceValue(func() string {
+	hash, _ := hashPassword("")
+	return hash
+})
+
+// authenticatePassword returns the user with the given credentials
+func authenticatePassword(cfg *AuthConfig, name, password string) (*Principal, bool) {
+	for _, u := range cfg.Users {
+		if u.Name == name {
+			if !verifyPassword(u.PasswordHash, password) {
+				return nil, false
+			}
+			return &Principal{Name: u.Name, Role: normalizeRole(u.Role)}, true
+		}
+	}
+	verifyPassword(dummyPasswordHash(), password)
+	return nil, false
+}
+
+// tokenClaims is the payload of a token issued by /login
+type tokenClaims struct {
+	Subject   string `json:"sub"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+var (
+	generatedSecretOnce sync.Once
+	generatedSecret     []byte
+)
+
+// signingKey returns the key login tokens are signed with
+func signingKey(cfg *AuthConfig) []byte {
+	if cfg.SessionSecret != "" {
+		return []byte(cfg.SessionSecret)
+	}
+	generatedSecretOnce.Do(func() {
+		generatedSecret = make([]byte, 32)
+		if _, err := rand.Read(generatedSecret); err != nil {
+			panic(err)
+		}
+		log.Println("auth.session_secret is not set, login tokens will not survive a restart.")
+	})
+	return generatedSecret
+}
+
+func tokenTTL(cfg *AuthConfig) time.Duration {
+	if cfg.TokenTTL != "" {
+		if ttl, err := time.ParseDuration(cfg.TokenTTL); err == nil && ttl > 0 {
+			return ttl
+		}
+		log.Printf("Invalid auth.token_ttl %q, using %s", cfg.TokenTTL, defaultTokenTTL)
+	}
+	return defaultTokenTTL
+}
+
+// issueToken signs a login token for a user
+func issueToken(cfg *AuthConfig, name string) (string, time.Time, error) {
+	exp := time.Now().Add(tokenTTL(cfg))
+	payload, err := json.Marshal(tokenClaims{Subject: name, ExpiresAt: exp.Unix()})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	body := base64.RawURLEncoding.EncodeToString(payload)
+	return body + "." + signToken(cfg, body), exp, nil
+}
+
+func signToken(cfg *AuthConfig, body string) string {
+	mac := hmac.New(sha256.New, signingKey(cfg))
+	mac.Write([]byte(body))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// authenticateToken resolves a bearer token: a static token from the config
+// or a login token. The role of a login token is looked up again so that
+// removing a user or changing their role takes effect immediately.
+func authenticateToken(cfg *AuthConfig, token string) (*Principal, error) {
+	for _, t := range cfg.Tokens {
+		if t.Token != "" && subtle.ConstantTimeCompare([]byte(t.Token), []byte(token)) == 1 {
+			return &Principal{Name: t.Name, Role: normalizeRole(t.Role)}, nil
+		}
+	}
+
+	body, sig, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(signToken(cfg, body))) {
+		return nil, errInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(body)
+	if err != nil {
+		return nil, errInvalidToken
+	}
+	var claims tokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil || time.Now().Unix() >= claims.ExpiresAt {
+		return nil, errInvalidToken
+	}
+	for _, u := range cfg.Users {
+		if u.Name == claims.Subject {
+			return &Principal{Name: u.Name, Role: normalizeRole(u.Role)}, nil
+		}
+	}
+	return nil, errInvalidToken
+}
+
+// requestToken extracts the bearer token from the header or the cookie
+func requestToken(r *http.Request) string {
+	if h := r.Header.Get("Authorization"); h != "" {
+		if token, ok := strings.CutPrefix(h, "Bearer "); ok {
+			return strings.TrimSpace(token)
+		}
+	}
+	if c, err := r.Cookie(authCookieName); err == nil {
+		return c.Value
+	}
+	return ""
+}
+
+// rejectRequest answers a request refused by a middleware, in the OpenAI
+// error format on /v1
+func rejectRequest(w http.ResponseWriter, r *http.Request, status int, message string) {
+	if strings.HasPrefix(r.URL.Path, "/v1/") {
+		errType := "invalid_request_error"
+		if status == http.StatusTooManyRequests {
+			errType = "rate_limit_error"
+		}
+		writeOpenAIError(w, status, errType, message)
+		return
+	}
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="go-chat-server"`)
+	}
+	http.Error(w, message, status)
+}
+
+// authMiddleware requires a valid token when auth is enabled and makes the
+// caller available through principalFrom
+func authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := GetAuthConfig()
+		if !cfg.Enabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		token := requestToken(r)
+		if token == "" {
+			rejectRequest(w, r, http.StatusUnauthorized, "Authentication required")
+			return
+		}
+		p, err := authenticateToken(&cfg, token)
+		if err != nil {
+			rejectRequest(w, r, http.StatusUnauthorized, "Invalid or expired token")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+	})
+}
+
+// adminMiddleware restricts a route to admins; it must run after authMiddleware
+func adminMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if p := principalFrom(r.Context()); p != nil && !p.IsAdmin() {
+			rejectRequest(w, r, http.StatusForbidden, "Admin role required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func loginHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		var req struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid input", http.StatusBadRequest)
+			return
+		}
+		cfg := GetAuthConfig()
+		if !cfg.Enabled() {
+			http.Error(w, "Authentication is disabled", http.StatusNotFound)
+			return
+		}
+		p, ok := authenticatePassword(&cfg, req.Username, req.Password)
+		if !ok {
+			log.Printf("Failed login for user %q from %s", req.Username, r.RemoteAddr)
+			http.Error(w, "Invalid username or password", http.StatusUnauthorized)
+			return
+		}
+		token, exp, err := issueToken(&cfg, p.Name)
+		if err != nil {
+			http.Error(w, "Failed to issue token", http.StatusInternalServerError)
+			return
+		}
+
+		// The cookie lets the web client download exports; API clients use the token
+		http.SetCookie(w, &http.Cookie{
+			Name:     authCookieName,
+			Value:    token,
+			Path:     "/",
+			Expires:  exp,
+			HttpOnly: true,
+			SameSite: http.SameSiteStrictMode,
+		})
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"token":      token,
+			"expires_at": exp,
+			"user":       p,
+		})
+		return
+	}
+
+	http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+}
+
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		http.SetCookie(w, &http.Cookie{
+			Name:     authCookieName,
+			Value:    "",
+			Path:     "/",
+			MaxAge:   -1,
+			HttpOnly: true,
+			SameSite: http.SameSiteStrictMode,
+		})
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"status": "ok"})
+		return
+	}
+
+	http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+}
+
+// meHandler tells the client whether it must sign in and who it is
+func meHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"auth_enabled": GetAuthConfig().Enabled(),
+			"user":         principalFrom(r.Context()),
+		})
+		return
+	}
+
+	http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+}
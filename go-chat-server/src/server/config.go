@@ -1,53 +1,219 @@
 package main
 
 import (
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
+	"log"
 	"os"
+	"path/filepath"
 	"sync"
 )
 
 type Config struct {
-	SystemPrompt string `json:"system_prompt"`
-	APIKey       string `json:"api_key"`
-	BaseURL      string `json:"base_url"`
-	ModelName    string `json:"model_name"`
+	// Server holds the listener settings, read once at startup
+	Server       ServerConfig `json:"server,omitzero"`
+	SystemPrompt string       `json:"system_prompt"`
+	// APIKey, BaseURL and ModelName configure the single provider of older
+	// config files; they are moved into a "default" profile on load
+	APIKey    string `json:"api_key,omitempty"`
+	BaseURL   string `json:"base_url,omitempty"`
+	ModelName string `json:"model_name,omitempty"`
+	// Profiles are the named model providers sessions can choose from
+	Profiles []Profile `json:"profiles,omitempty"`
+	// DefaultProfile is used when neither the session nor the request picks one
+	DefaultProfile string `json:"default_profile,omitempty"`
+	// HistoryStore selects where conversations are kept: "file" (default) or "memory"
+	HistoryStore string `json:"history_store,omitempty"`
+	// HistoryDir is the directory used by the file history store
+	HistoryDir string `json:"history_dir,omitempty"`
+	// HistoryMaxTokens is the token budget of the history sent to the model
+	HistoryMaxTokens int `json:"history_max_tokens,omitempty"`
+	// DisableHistorySummary drops old turns instead of summarizing them
+	DisableHistorySummary bool `json:"disable_history_summary,omitempty"`
+	// KeepReasoning stores the model's thinking in the history along with the answer
+	KeepReasoning bool `json:"keep_reasoning,omitempty"`
+	// Auth enables sign-in; without users or tokens the server is open to anyone
+	Auth AuthConfig `json:"auth,omitzero"`
+	// RateLimit bounds how often and how many generations each client may run
+	RateLimit RateLimitConfig `json:"rate_limit,omitzero"`
+	// Generation holds the default generation parameters of every profile
+	Generation GenerationParams `json:"generation,omitzero"`
+	// Fallback tunes the retries and circuit breakers of profiles with fallbacks
+	Fallback FallbackConfig `json:"fallback,omitzero"`
+	// Tools selects the tools the model may call while answering
+	Tools ToolsConfig `json:"tools,omitzero"`
+}
+
+// ServerConfig configures the HTTP listener
+type ServerConfig struct {
+	// Addr is the listen address. Default: ":8080"
+	Addr string `json:"addr,omitempty"`
+	// ClientDir is the directory of the web client. Default: "../client/"
+	ClientDir string `json:"client_dir,omitempty"`
+	// TLSCertFile and TLSKeyFile enable HTTPS when both are set
+	TLSCertFile string `json:"tls_cert_file,omitempty"`
+	TLSKeyFile  string `json:"tls_key_file,omitempty"`
+	// ReloadInterval is how often the config file is checked for changes,
+	// e.g. "5s"; "0" disables hot reload. Default: 2s
+	ReloadInterval string `json:"reload_interval,omitempty"`
 }
 
 var (
 	config     Config
 	configLock sync.RWMutex
 	configFile = "./config.json"
+	// configHash is the hash of the config file as last read or written;
+	// guarded by configLock
+	configHash [sha256.Size]byte
 )
 
-// LoadConfig loads the configuration from a JSON file
+// defaultConfig is written when there is no config file yet
+func defaultConfig() Config {
+	return Config{
+		SystemPrompt: "你是一个中英互译专家。",
+		Profiles: []Profile{{
+			Name:      defaultProfileName,
+			APIKey:    "your_api_key_here",
+			BaseURL:   "https://api.siliconflow.cn/v1",
+			ModelName: "Qwen/Qwen3-8B",
+		}},
+		DefaultProfile: defaultProfileName,
+	}
+}
+
+// LoadConfig loads the configuration from a JSON file, creating the file
+// with defaults if it does not exist, and applies the overrides from the
+// environment and the command line on top
 func LoadConfig() error {
 	configLock.Lock()
 	defer configLock.Unlock()
 
+	created, plaintext, err := loadConfigLocked(true)
+	if err != nil && !errors.Is(err, errInvalidSecret) {
+		return err
+	}
+	if err := validateConfigLocked(); err != nil {
+		log.Printf("Config file %s has problems: %v", configFile, err)
+	}
+	if created {
+		return SaveConfig()
+	}
+	return encryptPlaintextSecretsLocked(plaintext)
+}
+
+// loadConfigLocked reads the config file into config and applies the
+// overrides on top. A missing file is replaced by the defaults if create is
+// set. Secrets that cannot be resolved are reported as errInvalidSecret
+// after the rest of the config has been loaded.
+func loadConfigLocked(create bool) (created bool, plaintext int, err error) {
 	data, err := os.ReadFile(configFile)
-	if err != nil {
-		if os.IsNotExist(err) {
-			// Create default config if file doesn't exist
-			config = Config{
-				SystemPrompt: "你是一个中英互译专家。",
-				APIKey:       "your_api_key_here",
-				BaseURL:      "https://api.siliconflow.cn/v1",
-				ModelName:    "Qwen/Qwen3-8B",
-			}
-			return SaveConfig()
+	switch {
+	case os.IsNotExist(err) && create:
+		config = defaultConfig()
+		created = true
+	case err != nil:
+		return false, 0, err
+	default:
+		var cfg Config
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return false, 0, err
 		}
-		return err
+		config = cfg
+		configHash = sha256.Sum256(data)
+		checkConfigPermissions()
 	}
-	return json.Unmarshal(data, &config)
+	plaintext, secretErr := resolveSecretsLocked()
+	migrateLegacyProfileLocked()
+
+	if fileSnapshot, err = toJSONMap(config); err != nil {
+		return created, plaintext, err
+	}
+	if err := applyOverridesLocked(); err != nil {
+		return created, plaintext, err
+	}
+	resolveOverrideSecretsLocked()
+	// Legacy provider settings given as overrides go to the default profile
+	migrateLegacyProfileLocked()
+	refreshLogSecretsLocked()
+	return created, plaintext, secretErr
 }
 
-// SaveConfig saves the configuration to a JSON file
+// encryptPlaintextSecretsLocked saves the config again once a master key is
+// available, so that secrets read in plaintext get encrypted
+func encryptPlaintextSecretsLocked(plaintext int) error {
+	if plaintext == 0 || secretKey == nil {
+		return nil
+	}
+	log.Printf("Encrypting %d plaintext secrets in config file %s.", plaintext, configFile)
+	return SaveConfig()
+}
+
+// checkConfigPermissions restricts a config file readable by others, since
+// it may hold secrets
+func checkConfigPermissions() {
+	info, err := os.Stat(configFile)
+	if err != nil || info.Mode().Perm()&0077 == 0 {
+		return
+	}
+	if err := os.Chmod(configFile, 0600); err != nil {
+		log.Printf("Config file %s is readable by other users (%v) and could not be restricted: %v", configFile, info.Mode().Perm(), err)
+		return
+	}
+	log.Printf("Restricted permissions of config file %s to 0600.", configFile)
+}
+
+// SaveConfig saves the configuration to a JSON file readable only by its
+// owner. Fields set through overrides keep the value they have in the file,
+// and secrets are written in their stored form.
 func SaveConfig() error {
-	data, err := json.MarshalIndent(config, "", "  ")
+	out, err := toJSONMap(config)
+	if err != nil {
+		return err
+	}
+	maskOverridden(out, fileSnapshot)
+	encodeSecretsLocked(out)
+	refreshLogSecretsLocked()
+	data, err := json.MarshalIndent(out, "", "  ")
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(configFile, data, 0644)
+	// Write a temporary file and rename it so the config is never half written
+	tmp, err := os.CreateTemp(filepath.Dir(configFile), ".config-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), configFile); err != nil {
+		return err
+	}
+	configHash = sha256.Sum256(data)
+	return nil
+}
+
+// GetServerConfig returns the listener settings with defaults filled in
+func GetServerConfig() ServerConfig {
+	configLock.RLock()
+	defer configLock.RUnlock()
+	s := config.Server
+	if s.Addr == "" {
+		s.Addr = ":8080"
+	}
+	if s.ClientDir == "" {
+		s.ClientDir = "../client/"
+	}
+	return s
 }
 
 // GetSystemPrompt returns the current system prompt
@@ -65,47 +231,55 @@ func UpdateSystemPrompt(newPrompt string) error {
 	return SaveConfig()
 }
 
-// GetAPIKey returns the current API key
-func GetAPIKey() string {
+// KeepReasoning reports whether the model's thinking is stored in the history
+func KeepReasoning() bool {
 	configLock.RLock()
 	defer configLock.RUnlock()
-	return config.APIKey
+	return config.KeepReasoning
+}
+
+// GetAPIKey returns the API key of the default profile
+func GetAPIKey() string {
+	p, _ := GetProfile("")
+	return p.APIKey
 }
 
-// UpdateAPIKey updates the API key and saves it to the config file
+// UpdateAPIKey updates the API key of the default profile and saves it to
+// the config file. References are rejected with errInvalidSecret.
 func UpdateAPIKey(newAPIKey string) error {
 	configLock.Lock()
 	defer configLock.Unlock()
-	config.APIKey = newAPIKey
+	p := defaultProfileForUpdateLocked()
+	if err := setSecretLocked(profileSecretLoc(p.Name), &p.APIKey, newAPIKey); err != nil {
+		return err
+	}
 	return SaveConfig()
 }
 
-// GetBaseURL returns the current base URL
+// GetBaseURL returns the base URL of the default profile
 func GetBaseURL() string {
-	configLock.RLock()
-	defer configLock.RUnlock()
-	return config.BaseURL
+	p, _ := GetProfile("")
+	return p.BaseURL
 }
 
-// UpdateBaseURL updates the base URL and saves it to the config file
+// UpdateBaseURL updates the base URL of the default profile and saves it to the config file
 func UpdateBaseURL(newBaseURL string) error {
 	configLock.Lock()
 	defer configLock.Unlock()
-	config.BaseURL = newBaseURL
+	defaultProfileForUpdateLocked().BaseURL = newBaseURL
 	return SaveConfig()
 }
 
-// GetModelName returns the current model name
+// GetModelName returns the model name of the default profile
 func GetModelName() string {
-	configLock.RLock()
-	defer configLock.RUnlock()
-	return config.ModelName
+	p, _ := GetProfile("")
+	return p.ModelName
 }
 
-// UpdateModelName updates the model name and saves it to the config file
+// UpdateModelName updates the model name of the default profile and saves it to the config file
 func UpdateModelName(newModelName string) error {
 	configLock.Lock()
 	defer configLock.Unlock()
-	config.ModelName = newModelName
+	defaultProfileForUpdateLocked().ModelName = newModelName
 	return SaveConfig()
 }
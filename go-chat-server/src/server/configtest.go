@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/schema"
+	goopenai "github.com/meguminnnnnnnnn/go-openai"
+)
+
+// configTestTimeout bounds each request to the provider during a test
+const configTestTimeout = 20 * time.Second
+
+// maxErrorBody is how much of an unexpected response body is reported
+const maxErrorBody = 512
+
+// configTestCheck is the outcome of one step of a connectivity test
+type configTestCheck struct {
+	OK        bool   `json:"ok"`
+	Skipped   bool   `json:"skipped,omitempty"`
+	LatencyMS int64  `json:"latency_ms"`
+	Status    int    `json:"status,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// configTestResult is returned by /config/test. OK means the URL is valid
+// and the completion succeeded; listing models is optional for providers.
+type configTestResult struct {
+	OK        bool   `json:"ok"`
+	Profile   string `json:"profile,omitempty"`
+	BaseURL   string `json:"base_url"`
+	ModelName string `json:"model_name"`
+
+	URL    configTestCheck `json:"url"`
+	Models struct {
+		configTestCheck
+		Models []string `json:"models,omitempty"`
+		// ModelFound reports whether model_name is among the listed models
+		ModelFound *bool `json:"model_found,omitempty"`
+	} `json:"models"`
+	Completion struct {
+		configTestCheck
+		Reply string `json:"reply,omitempty"`
+	} `json:"completion"`
+}
+
+// configTestHandler serves POST /config/test. It tries candidate provider
+// settings without saving them: fields left empty are taken from the named
+// profile, or the default one. The API key must be given literally, since
+// resolving env: or file: references here would let the caller send server
+// secrets to a host of their choosing, and the stored key is only sent to
+// the profile's own base URL.
+func configTestHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		var req struct {
+			Profile   string `json:"profile"`
+			APIKey    string `json:"api_key"`
+			BaseURL   string `json:"base_url"`
+			ModelName string `json:"model_name"`
+			Timeout   string `json:"timeout"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid input", http.StatusBadRequest)
+			return
+		}
+		p, err := GetProfile(req.Profile)
+		if err != nil && req.Profile != "" {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if req.APIKey != "" {
+			if isSecretRef(req.APIKey) {
+				http.Error(w, fmt.Sprintf("%v: api_key must be a literal key, references are not resolved here", errInvalidSecret), http.StatusBadRequest)
+				return
+			}
+			warnInsecureSecret(r, "API key")
+			p.APIKey = req.APIKey
+		}
+		if req.BaseURL != "" {
+			if req.APIKey == "" && p.APIKey != "" && !sameBaseURL(req.BaseURL, p.BaseURL) {
+				http.Error(w, "api_key is required when base_url differs from the profile's", http.StatusBadRequest)
+				return
+			}
+			p.BaseURL = req.BaseURL
+		}
+		if req.ModelName != "" {
+			p.ModelName = req.ModelName
+		}
+		if req.Timeout != "" {
+			if d, err := time.ParseDuration(req.Timeout); err != nil || d < 0 {
+				http.Error(w, fmt.Sprintf("invalid timeout %q", req.Timeout), http.StatusBadRequest)
+				return
+			}
+			p.Timeout = req.Timeout
+		}
+
+		result := testProviderConfig(r.Context(), p)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+		return
+	}
+
+	http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+}
+
+// testProviderConfig validates the URL of a profile, lists the provider's
+// models and runs a minimal completion
+func testProviderConfig(ctx context.Context, p Profile) *configTestResult {
+	res := &configTestResult{Profile: p.Name, BaseURL: p.BaseURL, ModelName: p.ModelName}
+	if err := checkBaseURL(p.BaseURL); err != nil {
+		res.URL.Error = err.Error()
+		res.Models.Skipped = true
+		res.Completion.Skipped = true
+		return res
+	}
+	res.URL.OK = true
+
+	start := time.Now()
+	models, status, err := listProviderModels(ctx, p)
+	res.Models.LatencyMS = time.Since(start).Milliseconds()
+	res.Models.Status = status
+	if err != nil {
+		res.Models.Error = err.Error()
+	} else {
+		res.Models.OK = true
+		res.Models.Models = models
+		if p.ModelName != "" {
+			found := false
+			for _, id := range models {
+				found = found || id == p.ModelName
+			}
+			res.Models.ModelFound = &found
+		}
+	}
+
+	if p.ModelName == "" {
+		res.Completion.Error = "model_name is required"
+		return res
+	}
+	start = time.Now()
+	reply, err := testCompletion(ctx, p)
+	res.Completion.LatencyMS = time.Since(start).Milliseconds()
+	if err != nil {
+		res.Completion.Error = err.Error()
+		var apiErr *goopenai.APIError
+		var reqErr *goopenai.RequestError
+		switch {
+		case errors.As(err, &apiErr):
+			res.Completion.Status = apiErr.HTTPStatusCode
+		case errors.As(err, &reqErr):
+			res.Completion.Status = reqErr.HTTPStatusCode
+		}
+		return res
+	}
+	res.Completion.OK = true
+	res.Completion.Reply = reply
+	res.OK = true
+	return res
+}
+
+// sameBaseURL reports whether two base URLs name the same endpoint,
+// ignoring trailing slashes and the case of the scheme and host
+func sameBaseURL(a, b string) bool {
+	ua, errA := url.Parse(strings.TrimRight(a, "/"))
+	ub, errB := url.Parse(strings.TrimRight(b, "/"))
+	if errA != nil || errB != nil {
+		return false
+	}
+	return strings.EqualFold(ua.Scheme, ub.Scheme) && strings.EqualFold(ua.Host, ub.Host) &&
+		ua.Path == ub.Path && ua.RawQuery == ub.RawQuery
+}
+
+// checkBaseURL accepts absolute http and https URLs
+func checkBaseURL(raw string) error {
+	if raw == "" {
+		return fmt.Errorf("base_url is required")
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("invalid base_url: %v", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("base_url must start with http:// or https://")
+	}
+	if u.Host == "" {
+		return fmt.Errorf("base_url has no host")
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("base_url must not have a query or fragment")
+	}
+	return nil
+}
+
+// listProviderModels fetches the model IDs from the provider's /models
+func listProviderModels(ctx context.Context, p Profile) ([]string, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, configTestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(p.BaseURL, "/")+"/models", nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if p.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+p.APIKey)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return nil, resp.StatusCode, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, resp.StatusCode, fmt.Errorf("%s: %s", resp.Status, providerErrorMessage(body))
+	}
+	var list struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &list); err != nil {
+		return nil, resp.StatusCode, fmt.Errorf("unexpected response: %s", providerErrorMessage(body))
+	}
+	models := make([]string, 0, len(list.Data))
+	for _, m := range list.Data {
+		models = append(models, m.ID)
+	}
+	return models, resp.StatusCode, nil
+}
+
+// providerErrorMessage extracts the message of an OpenAI-style error body,
+// falling back to the start of the body
+func providerErrorMessage(body []byte) string {
+	var e struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(body, &e) == nil && e.Error.Message != "" {
+		return e.Error.Message
+	}
+	s := strings.TrimSpace(string(body))
+	if len(s) > maxErrorBody {
+		s = s[:maxErrorBody] + "..."
+	}
+	return s
+}
+
+// testCompletion asks the provider for a one-token answer
+func testCompletion(ctx context.Context, p Profile) (string, error) {
+	if p.Timeout == "" {
+		p.Timeout = configTestTimeout.String()
+	}
+	llm, err := createOpenAIChatModel(ctx, p)
+	if err != nil {
+		return "", err
+	}
+	msg, err := llm.Generate(ctx, []*schema.Message{schema.UserMessage("ping")}, model.WithMaxTokens(1))
+	if err != nil {
+		return "", err
+	}
+	return msg.Content, nil
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeProvider is a minimal OpenAI-compatible server that records the
+// bearer tokens it receives
+type fakeProvider struct {
+	*httptest.Server
+	delay time.Duration
+
+	mu        sync.Mutex
+	tokens    []string
+	maxTokens []int
+}
+
+func newFakeProvider(t *testing.T, delay time.Duration) *fakeProvider {
+	f := &fakeProvider{delay: delay}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/models", func(w http.ResponseWriter, r *http.Request) {
+		f.record(r, 0)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"object":"list","data":[{"id":"model-a","object":"model"},{"id":"model-b","object":"model"}]}`))
+	})
+	mux.HandleFunc("POST /v1/chat/completions", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			MaxTokens           int `json:"max_tokens"`
+			MaxCompletionTokens int `json:"max_completion_tokens"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		f.record(r, max(req.MaxTokens, req.MaxCompletionTokens))
+		time.Sleep(f.delay)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"c1","object":"chat.completion","created":1,"model":"model-a",` +
+			`"choices":[{"index":0,"message":{"role":"assistant","content":"pong"},"finish_reason":"length"}],` +
+			`"usage":{"prompt_tokens":1,"completion_tokens":1,"total_tokens":2}}`))
+	})
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeProvider) record(r *http.Request, maxTokens int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.tokens = append(f.tokens, strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+	if maxTokens > 0 {
+		f.maxTokens = append(f.maxTokens, maxTokens)
+	}
+}
+
+func TestTestProviderConfig(t *testing.T) {
+	f := newFakeProvider(t, 30*time.Millisecond)
+	res := testProviderConfig(context.Background(), Profile{
+		Name: "p", BaseURL: f.URL + "/v1", APIKey: "sk-test", ModelName: "model-b",
+	})
+
+	if !res.OK || !res.URL.OK || !res.Models.OK || !res.Completion.OK {
+		t.Fatalf("test failed: %+v", res)
+	}
+	if got := strings.Join(res.Models.Models, ","); got != "model-a,model-b" {
+		t.Errorf("models = %s", got)
+	}
+	if res.Models.ModelFound == nil || !*res.Models.ModelFound {
+		t.Errorf("model_found = %v, want true", res.Models.ModelFound)
+	}
+	if res.Models.Status != http.StatusOK {
+		t.Errorf("models status = %d", res.Models.Status)
+	}
+	if res.Completion.Reply != "pong" {
+		t.Errorf("reply = %q", res.Completion.Reply)
+	}
+	if res.Completion.LatencyMS < f.delay.Milliseconds() {
+		t.Errorf("completion latency = %dms, want at least %dms", res.Completion.LatencyMS, f.delay.Milliseconds())
+	}
+	if len(f.maxTokens) != 1 || f.maxTokens[0] != 1 {
+		t.Errorf("completion max tokens = %v, want [1]", f.maxTokens)
+	}
+	for _, tok := range f.tokens {
+		if tok != "sk-test" {
+			t.Errorf("provider got token %q", tok)
+		}
+	}
+}
+
+func TestTestProviderConfigUpstreamError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"error":{"message":"invalid api key","type":"invalid_request_error"}}`))
+	}))
+	defer srv.Close()
+
+	res := testProviderConfig(context.Background(), Profile{BaseURL: srv.URL + "/v1", APIKey: "sk-bad", ModelName: "m"})
+	if res.OK {
+		t.Fatal("test succeeded against a failing provider")
+	}
+	if !res.URL.OK {
+		t.Errorf("url check failed: %s", res.URL.Error)
+	}
+	if res.Models.OK || res.Models.Status != http.StatusUnauthorized || !strings.Contains(res.Models.Error, "invalid api key") {
+		t.Errorf("models = %+v", res.Models.configTestCheck)
+	}
+	if res.Completion.OK || res.Completion.Status != http.StatusUnauthorized || res.Completion.Error == "" {
+		t.Errorf("completion = %+v", res.Completion.configTestCheck)
+	}
+}
+
+func TestTestProviderConfigBadURL(t *testing.T) {
+	res := testProviderConfig(context.Background(), Profile{BaseURL: "ftp://example.com", APIKey: "k", ModelName: "m"})
+	if res.OK || res.URL.OK || !res.Models.Skipped || !res.Completion.Skipped {
+		t.Errorf("res = %+v", res)
+	}
+}
+
+func TestConfigTestHandlerSecrets(t *testing.T) {
+	f := newFakeProvider(t, 0)
+	configLock.Lock()
+	saved := config
+	config.DefaultProfile = "stored"
+	config.Profiles = []Profile{{Name: "stored", BaseURL: f.URL + "/v1", APIKey: "sk-stored", ModelName: "model-a"}}
+	configLock.Unlock()
+	t.Cleanup(func() {
+		configLock.Lock()
+		config = saved
+		configLock.Unlock()
+	})
+	t.Setenv("CONFIG_TEST_SECRET", "leaked")
+
+	post := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		configTestHandler(rec, httptest.NewRequest(http.MethodPost, "/config/test", bytes.NewBufferString(body)))
+		return rec
+	}
+	rejected := []struct {
+		name string
+		body string
+	}{
+		{"env reference", `{"api_key":"env:CONFIG_TEST_SECRET"}`},
+		{"file reference", `{"api_key":"file:/etc/hostname"}`},
+		{"stored key to another host", `{"base_url":"http://attacker.invalid/v1"}`},
+	}
+	for _, tc := range rejected {
+		t.Run(tc.name, func(t *testing.T) {
+			if rec := post(tc.body); rec.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want 400: %s", rec.Code, rec.Body)
+			}
+		})
+	}
+
+	// the stored key may still be used with the profile's own base URL
+	rec := post(`{"base_url":"` + f.URL + `/v1/","model_name":"model-b"}`)
+	var res configTestResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil || !res.OK {
+		t.Fatalf("status %d, result %s", rec.Code, rec.Body)
+	}
+	for _, tok := range f.tokens {
+		if tok != "sk-stored" {
+			t.Errorf("provider got token %q", tok)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"eino-rag/fallback"
+
+	"github.com/cloudwego/eino/components/model"
+)
+
+// FallbackConfig tunes the retries and circuit breakers of profiles with
+// fallbacks. Zero values use the defaults of the fallback package.
+type FallbackConfig struct {
+	// MaxRetries is how often a provider is retried before the next one is tried
+	MaxRetries int `json:"max_retries,omitempty"`
+	// InitialBackoff is the wait before the first retry, e.g. "500ms"
+	InitialBackoff string `json:"initial_backoff,omitempty"`
+	// MaxBackoff caps the wait between retries, e.g. "8s"
+	MaxBackoff string `json:"max_backoff,omitempty"`
+	// FailureThreshold is the number of consecutive failures that takes a provider out of rotation
+	FailureThreshold int `json:"failure_threshold,omitempty"`
+	// OpenTimeout is how long a failing provider is skipped, e.g. "30s"
+	OpenTimeout string `json:"open_timeout,omitempty"`
+}
+
+// GetFallbackConfig returns the current retry and circuit breaker settings
+func GetFallbackConfig() FallbackConfig {
+	configLock.RLock()
+	defer configLock.RUnlock()
+	return config.Fallback
+}
+
+// breakers keeps the circuit breaker of every profile across model rebuilds,
+// so that every chain a profile is part of shares what is known about it;
+// guarded by mu
+var breakers = make(map[string]*fallback.Breaker)
+
+func parseDurationSetting(name, value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		log.Printf("Invalid fallback.%s %q, using the default", name, value)
+		return 0
+	}
+	return d
+}
+
+// breakerLocked returns the breaker of a profile, creating it on first use
+func breakerLocked(name string, cfg *FallbackConfig) *fallback.Breaker {
+	b, ok := breakers[name]
+	if !ok {
+		b = fallback.NewBreaker(&fallback.BreakerConfig{
+			FailureThreshold: cfg.FailureThreshold,
+			OpenTimeout:      parseDurationSetting("open_timeout", cfg.OpenTimeout),
+		})
+		breakers[name] = b
+	}
+	return b
+}
+
+// createChainLocked creates the chat model of p: the model of the profile
+// itself, or a fallback chain when the profile lists fallbacks
+func createChainLocked(ctx context.Context, p Profile) (model.ToolCallingChatModel, error) {
+	llm, err := createOpenAIChatModel(ctx, p)
+	if err != nil || len(p.Fallbacks) == 0 {
+		return llm, err
+	}
+
+	cfg := GetFallbackConfig()
+	providers := []fallback.Provider{{Name: p.Name, Model: llm, Breaker: breakerLocked(p.Name, &cfg)}}
+	seen := map[string]bool{p.Name: true}
+	for _, name := range p.Fallbacks {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		fp, err := GetProfile(name)
+		if err != nil {
+			log.Printf("Skipping fallback %s of profile %s: %v", name, p.Name, err)
+			continue
+		}
+		fm, err := createOpenAIChatModel(ctx, fp)
+		if err != nil {
+			log.Printf("Skipping fallback %s of profile %s: %v", name, p.Name, err)
+			continue
+		}
+		providers = append(providers, fallback.Provider{Name: fp.Name, Model: fm, Breaker: breakerLocked(fp.Name, &cfg)})
+	}
+	return fallback.NewChatModel(&fallback.Config{
+		MaxRetries:     cfg.MaxRetries,
+		InitialBackoff: parseDurationSetting("initial_backoff", cfg.InitialBackoff),
+		MaxBackoff:     parseDurationSetting("max_backoff", cfg.MaxBackoff),
+		OnError: func(provider string, attempt int, err error) {
+			log.Printf("Provider %s failed (attempt %d): %v", provider, attempt+1, err)
+		},
+	}, providers...)
+}
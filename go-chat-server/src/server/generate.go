@@ -9,8 +9,8 @@ import (
 )
 
 // Stream function to handle streaming responses from the chat model
-func stream(ctx context.Context, llm model.ToolCallingChatModel, in []*schema.Message) (*schema.StreamReader[*schema.Message], error) {
-	result, err := llm.Stream(ctx, in)
+func stream(ctx context.Context, llm model.ToolCallingChatModel, in []*schema.Message, opts ...model.Option) (*schema.StreamReader[*schema.Message], error) {
+	result, err := llm.Stream(ctx, in, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("llm generate failed: %v", err)
 	}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/cloudwego/eino/schema"
+	"github.com/gorilla/mux"
+)
+
+// truncatedKey marks an assistant message in Message.Extra whose generation
+// was stopped before the model finished
+const truncatedKey = "truncated"
+
+type generation struct {
+	sessionID string
+	cancel    context.CancelFunc
+}
+
+// generationRegistry tracks the in-flight generations so they can be cancelled
+type generationRegistry struct {
+	mu   sync.Mutex
+	gens map[string]*generation
+}
+
+var generations = &generationRegistry{gens: make(map[string]*generation)}
+
+// Start registers a new generation for a session and returns its ID, the
+// context the upstream stream must use, and a func to call once it is over
+func (g *generationRegistry) Start(parent context.Context, sessionID string) (string, context.Context, func()) {
+	ctx, cancel := context.WithCancel(parent)
+	id := newSessionID()
+
+	g.mu.Lock()
+	g.gens[id] = &generation{sessionID: sessionID, cancel: cancel}
+	g.mu.Unlock()
+
+	return id, ctx, func() {
+		g.mu.Lock()
+		delete(g.gens, id)
+		g.mu.Unlock()
+		cancel()
+	}
+}
+
+// Cancel stops a generation owned by the given session, reporting whether
+// such a generation was running
+func (g *generationRegistry) Cancel(id, sessionID string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	gen, ok := g.gens[id]
+	if !ok || gen.sessionID != sessionID {
+		return false
+	}
+	gen.cancel()
+	return true
+}
+
+// markTruncated flags a message as cut short by a cancellation
+func markTruncated(msg *schema.Message) {
+	if msg.Extra == nil {
+		msg.Extra = make(map[string]any)
+	}
+	msg.Extra[truncatedKey] = true
+}
+
+func cancelGenerationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		id := mux.Vars(r)["id"]
+		s, ok := lookupSession(r)
+		if !ok || !generations.Cancel(id, s.ID) {
+			http.Error(w, "Generation not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Generation %s cancelled by client.", id)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"status": "ok"})
+		return
+	}
+
+	http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+}
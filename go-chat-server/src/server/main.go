@@ -1,11 +1,15 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -13,34 +17,77 @@ import (
 )
 
 func main() {
+	hashPasswordFlag := flag.Bool("hash-password", false, "read a password from stdin, print its hash for auth.users in config.json and exit")
+	configPath := flag.String("config", "", "config file (env "+envConfigPath+", default ./config.json)")
+	addr := flag.String("addr", "", "listen address, e.g. :8080 (overrides server.addr)")
+	clientDir := flag.String("client-dir", "", "directory of the web client (overrides server.client_dir)")
+	tlsCert := flag.String("tls-cert", "", "TLS certificate file (overrides server.tls_cert_file)")
+	tlsKey := flag.String("tls-key", "", "TLS key file (overrides server.tls_key_file)")
+	encryptSecretFlag := flag.Bool("encrypt-secret", false, "read a secret from stdin, print it encrypted with the master key for config.json and exit")
+	masterKeyFile := flag.String("master-key-file", "", "file holding the master key that encrypts secrets (env "+envMasterKey+" or "+envMasterKeyFile+")")
+	listKeys := flag.Bool("list-config-keys", false, "print the config fields that -set and "+envPrefix+"* variables can override and exit")
+	var sets setFlag
+	flag.Var(&sets, "set", "override a config field, e.g. -set rate_limit.burst=5 (repeatable)")
+	flag.Parse()
+	log.SetOutput(redactingWriter{os.Stderr})
+	if *hashPasswordFlag {
+		printPasswordHash()
+		return
+	}
+	if err := loadMasterKey(*masterKeyFile); err != nil {
+		log.Fatalf("Invalid master key: %v", err)
+	}
+	if *encryptSecretFlag {
+		printEncryptedSecret()
+		return
+	}
+	if *listKeys {
+		printConfigKeys()
+		return
+	}
+
+	// Config layers: defaults, the config file, EINO_* variables, then flags
+	if path := os.Getenv(envConfigPath); path != "" {
+		configFile = path
+	}
+	if *configPath != "" {
+		configFile = *configPath
+	}
+	overrides = envOverrides()
+	for _, f := range []struct{ name, key, value string }{
+		{"addr", "server.addr", *addr},
+		{"client-dir", "server.client_dir", *clientDir},
+		{"tls-cert", "server.tls_cert_file", *tlsCert},
+		{"tls-key", "server.tls_key_file", *tlsKey},
+	} {
+		if f.value != "" {
+			sets = append(sets, configOverride{Key: f.key, Value: f.value, Source: "flag -" + f.name})
+		}
+	}
+	overrides = append(overrides, sets...)
+	if err := checkOverrides(overrides); err != nil {
+		log.Fatalf("Invalid config override: %v", err)
+	}
+	if err := LoadConfig(); err != nil {
+		log.Printf("Failed to load config: %v, using defaults", err)
+	}
+	initSessions()
+	registerBuiltinTools()
+	syncMCPServers()
+	server := GetServerConfig()
+	if (server.TLSCertFile == "") != (server.TLSKeyFile == "") {
+		log.Fatalf("Both server.tls_cert_file and server.tls_key_file are needed for TLS")
+	}
+
+	if !GetAuthConfig().Enabled() {
+		log.Println("Authentication is disabled: configure auth.users or auth.tokens before exposing the server.")
+	}
+
 	// Set up the HTTP server
-	router := mux.NewRouter()
-	// Basic CORS for local client usage
-	router.Use(func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			w.Header().Set("Access-Control-Allow-Origin", "*")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-			if r.Method == http.MethodOptions {
-				w.WriteHeader(http.StatusNoContent)
-				return
-			}
-			next.ServeHTTP(w, r)
-		})
-	})
-	router.HandleFunc("/send", chatHandler).Methods("POST")
-	router.HandleFunc("/update-prompt", updatePromptHandler).Methods("POST")
-	router.HandleFunc("/update-config", updateConfigHandler).Methods("POST")
-	router.HandleFunc("/get-config", getConfigHandler).Methods("GET")
-	router.HandleFunc("/clear-history", clearHistoryHandler).Methods("POST")
-	router.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("ok"))
-	}).Methods("GET")
-	router.PathPrefix("/").Handler(http.FileServer(http.Dir("../client/")))
+	router := newRouter(server.ClientDir)
 
 	srv := &http.Server{
-		Addr:    ":8080",
+		Addr:    server.Addr,
 		Handler: router,
 	}
 
@@ -48,9 +95,30 @@ func main() {
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 
+	// Pick up edits of the config file, on change or on SIGHUP
+	if interval := server.reloadInterval(); interval > 0 {
+		go watchConfig(interval)
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := ReloadConfig(); err != nil {
+				log.Printf("Rejected config file %s, keeping the running config: %v", configFile, err)
+			}
+		}
+	}()
+
 	go func() {
-		log.Println("Starting server on :8080")
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if server.TLSCertFile != "" {
+			log.Printf("Starting server on %s (TLS)", server.Addr)
+			err = srv.ListenAndServeTLS(server.TLSCertFile, server.TLSKeyFile)
+		} else {
+			log.Printf("Starting server on %s", server.Addr)
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			log.Fatalf("Could not start server: %s\n", err)
 		}
 	}()
@@ -66,6 +134,88 @@ func main() {
 	if err := srv.Shutdown(ctx); err != nil {
 		log.Fatalf("Server forced to shutdown: %s\n", err)
 	}
+	stopMCPServers()
 
 	log.Println("Server exited")
 }
+
+// printPasswordHash reads a password from stdin and prints its hash
+func printPasswordHash() {
+	fmt.Fprint(os.Stderr, "Password: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	password := strings.TrimRight(line, "\r\n")
+	if password == "" {
+		log.Fatalf("No password given: %v", err)
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		log.Fatalf("Failed to hash password: %v", err)
+	}
+	fmt.Println(hash)
+}
+
+// newRouter sets up the routes of the API and serves the client from clientDir
+func newRouter(clientDir string) *mux.Router {
+	router := mux.NewRouter()
+	// Basic CORS for local client usage
+	router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Session-ID")
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Expose-Headers", "X-Session-ID, Retry-After")
+			if r.Method == http.MethodOptions {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	router.HandleFunc("/login", loginHandler).Methods("POST")
+	router.HandleFunc("/logout", logoutHandler).Methods("POST")
+	router.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok"))
+	}).Methods("GET")
+
+	// Everything below requires a signed-in user once auth is configured
+	api := router.NewRoute().Subrouter()
+	api.Use(authMiddleware)
+	api.HandleFunc("/me", meHandler).Methods("GET")
+	api.HandleFunc("/get-config", getConfigHandler).Methods("GET")
+	api.HandleFunc("/clear-history", clearHistoryHandler).Methods("POST")
+	api.HandleFunc("/cancel/{id}", cancelGenerationHandler).Methods("POST")
+	api.HandleFunc("/sessions", createSessionHandler).Methods("POST")
+	api.HandleFunc("/sessions", listSessionsHandler).Methods("GET")
+	api.HandleFunc("/sessions/import", importSessionHandler).Methods("POST")
+	api.HandleFunc("/sessions/{id}", deleteSessionHandler).Methods("DELETE")
+	api.HandleFunc("/sessions/{id}", updateSessionHandler).Methods("PATCH")
+	api.HandleFunc("/sessions/{id}/messages", sessionMessagesHandler).Methods("GET")
+	api.HandleFunc("/sessions/{id}/branch", switchBranchHandler).Methods("POST")
+	api.HandleFunc("/sessions/{id}/export", exportSessionHandler).Methods("GET")
+	api.HandleFunc("/profiles", listProfilesHandler).Methods("GET")
+	api.HandleFunc("/tools", listToolsHandler).Methods("GET")
+	api.HandleFunc("/v1/models", openAIModelsHandler).Methods("GET")
+
+	// Routes that open an upstream stream are rate limited per user or IP
+	generate := router.NewRoute().Subrouter()
+	generate.Use(authMiddleware, rateLimitMiddleware)
+	generate.HandleFunc("/send", chatHandler).Methods("POST")
+	generate.HandleFunc("/sessions/{id}/regenerate", regenerateHandler).Methods("POST")
+	generate.HandleFunc("/sessions/{id}/messages/{msgID}/edit", editMessageHandler).Methods("POST")
+	// OpenAI-compatible gateway for SDK clients and IDE plugins
+	generate.HandleFunc("/v1/chat/completions", openAIChatCompletionsHandler).Methods("POST")
+
+	// Changing the model or its credentials is reserved to admins
+	admin := router.NewRoute().Subrouter()
+	admin.Use(authMiddleware, adminMiddleware)
+	admin.HandleFunc("/update-prompt", updatePromptHandler).Methods("POST")
+	admin.HandleFunc("/update-config", updateConfigHandler).Methods("POST")
+	admin.HandleFunc("/config/test", configTestHandler).Methods("POST")
+	admin.HandleFunc("/profiles", saveProfileHandler).Methods("POST")
+	admin.HandleFunc("/profiles/{name}", saveProfileHandler).Methods("PUT")
+	admin.HandleFunc("/profiles/{name}", deleteProfileHandler).Methods("DELETE")
+
+	router.PathPrefix("/").Handler(http.FileServer(http.Dir(clientDir)))
+	return router
+}
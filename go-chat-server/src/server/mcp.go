@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudwego/eino/components/tool"
+	"github.com/cloudwego/eino/schema"
+	"github.com/eino-contrib/jsonschema"
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/client/transport"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+const (
+	// mcpStartTimeout bounds the launch and tool discovery of an MCP server
+	mcpStartTimeout = 30 * time.Second
+	// mcpStopTimeout is how long an MCP server may take to exit once its
+	// stdin is closed before it is killed
+	mcpStopTimeout = 5 * time.Second
+	// mcpToolSeparator joins server and tool names: tool "search" of server
+	// "docs" is offered to the model as "docs__search"
+	mcpToolSeparator = "__"
+	// maxToolNameLen is the longest function name OpenAI accepts
+	maxToolNameLen = 64
+)
+
+var (
+	mcpServerNamePattern = regexp.MustCompile(`^[A-Za-z0-9-]{1,32}$`)
+	invalidToolNameChars = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+)
+
+// MCPServerConfig launches a stdio MCP (Model Context Protocol) server whose
+// tools are offered to the model
+type MCPServerConfig struct {
+	// Name identifies the server and prefixes its tools
+	Name    string   `json:"name"`
+	Command string   `json:"command"`
+	Args    []string `json:"args,omitempty"`
+	// Env adds variables to the server's environment. Values are secrets
+	// like the API keys: they may be env: or file: references, are encrypted
+	// on save and are redacted from the log.
+	Env map[string]string `json:"env,omitempty"`
+	// Dir is the working directory of the server
+	Dir string `json:"dir,omitempty"`
+	// Allow lists the tools the model may use, by name or path.Match
+	// pattern; all tools when empty
+	Allow []string `json:"allow,omitempty"`
+	// Deny lists tools the model may not use; it takes precedence over Allow
+	Deny []string `json:"deny,omitempty"`
+	// Disabled stops the server without removing its settings
+	Disabled bool `json:"disabled,omitempty"`
+}
+
+func (c *MCPServerConfig) validate() error {
+	if !mcpServerNamePattern.MatchString(c.Name) {
+		return fmt.Errorf("name must be 1-32 letters, digits or '-'")
+	}
+	if c.Command == "" {
+		return fmt.Errorf("command is required")
+	}
+	for _, pattern := range slices.Concat(c.Allow, c.Deny) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid tool pattern %q", pattern)
+		}
+	}
+	return nil
+}
+
+// allows applies the allow and deny lists to a tool of the server
+func (c *MCPServerConfig) allows(tool string) bool {
+	match := func(patterns []string) bool {
+		for _, p := range patterns {
+			if ok, _ := path.Match(p, tool); ok {
+				return true
+			}
+		}
+		return false
+	}
+	if match(c.Deny) {
+		return false
+	}
+	return len(c.Allow) == 0 || match(c.Allow)
+}
+
+// sameProcess reports whether two configs launch the same process, so that
+// changing only the tool lists does not restart the server
+func (c *MCPServerConfig) sameProcess(o *MCPServerConfig) bool {
+	return c.Command == o.Command && slices.Equal(c.Args, o.Args) && maps.Equal(c.Env, o.Env) && c.Dir == o.Dir
+}
+
+func (c *ToolsConfig) mcpServer(name string) *MCPServerConfig {
+	for i := range c.MCPServers {
+		if c.MCPServers[i].Name == name {
+			return &c.MCPServers[i]
+		}
+	}
+	return nil
+}
+
+// mcpServer is a running MCP server
+type mcpServer struct {
+	cfg    MCPServerConfig
+	client *client.Client
+	// cancel kills the server process
+	cancel context.CancelFunc
+}
+
+var (
+	mcpMu      sync.Mutex
+	mcpServers = make(map[string]*mcpServer)
+)
+
+// syncMCPServers starts, restarts and stops MCP servers to match the config.
+// A server that fails to start is logged and left out.
+func syncMCPServers() {
+	cfg := GetToolsConfig()
+	want := make(map[string]*MCPServerConfig)
+	for i := range cfg.MCPServers {
+		if s := &cfg.MCPServers[i]; !s.Disabled {
+			want[s.Name] = s
+		}
+	}
+
+	mcpMu.Lock()
+	defer mcpMu.Unlock()
+	for name, s := range mcpServers {
+		if w, ok := want[name]; !ok || !s.cfg.sameProcess(w) {
+			s.stop()
+			delete(mcpServers, name)
+		}
+	}
+	for _, w := range cfg.MCPServers {
+		if _, ok := want[w.Name]; !ok {
+			continue
+		}
+		if _, running := mcpServers[w.Name]; running {
+			continue
+		}
+		s, err := startMCPServer(w)
+		if err != nil {
+			log.Printf("Failed to start MCP server %s: %v", w.Name, err)
+			continue
+		}
+		mcpServers[w.Name] = s
+	}
+}
+
+// stopMCPServers stops all MCP servers, e.g. on shutdown
+func stopMCPServers() {
+	mcpMu.Lock()
+	defer mcpMu.Unlock()
+	for name, s := range mcpServers {
+		s.stop()
+		delete(mcpServers, name)
+	}
+}
+
+// startMCPServer launches a server, performs the MCP handshake and
+// registers the server's tools
+func startMCPServer(cfg MCPServerConfig) (*mcpServer, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	env := make([]string, 0, len(cfg.Env))
+	for k, v := range cfg.Env {
+		env = append(env, k+"="+v)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stdio := transport.NewStdioWithOptions(cfg.Command, env, cfg.Args,
+		transport.WithCommandFunc(func(ctx context.Context, command string, env []string, args []string) (*exec.Cmd, error) {
+			cmd := exec.CommandContext(ctx, command, args...)
+			cmd.Env = append(os.Environ(), env...)
+			cmd.Dir = cfg.Dir
+			cmd.WaitDelay = mcpStopTimeout
+			return cmd, nil
+		}))
+	if err := stdio.Start(ctx); err != nil {
+		cancel()
+		return nil, err
+	}
+	// The server's log goes to ours; an unread pipe would block the server
+	go func() {
+		scanner := bufio.NewScanner(stdio.Stderr())
+		for scanner.Scan() {
+			log.Printf("MCP server %s: %s", cfg.Name, scanner.Text())
+		}
+	}()
+	s := &mcpServer{cfg: cfg, client: client.NewClient(stdio), cancel: cancel}
+
+	initCtx, cancelInit := context.WithTimeout(ctx, mcpStartTimeout)
+	defer cancelInit()
+	var init mcp.InitializeRequest
+	init.Params.ProtocolVersion = mcp.LATEST_PROTOCOL_VERSION
+	init.Params.ClientInfo = mcp.Implementation{Name: "go-chat-server", Version: "1.0.0"}
+	info, err := s.client.Initialize(initCtx, init)
+	if err != nil {
+		s.stop()
+		return nil, fmt.Errorf("initialize: %w", err)
+	}
+	list, err := s.client.ListTools(initCtx, mcp.ListToolsRequest{})
+	if err != nil {
+		s.stop()
+		return nil, fmt.Errorf("list tools: %w", err)
+	}
+	var names []string
+	for _, t := range list.Tools {
+		mt, err := newMCPTool(s, t)
+		if err == nil {
+			err = tools.register(mt, cfg.Name, t.Name)
+		}
+		if err != nil {
+			log.Printf("Skipping tool %s of MCP server %s: %v", t.Name, cfg.Name, err)
+			continue
+		}
+		names = append(names, t.Name)
+	}
+	log.Printf("MCP server %s (%s %s) started with %d tools: %s", cfg.Name, info.ServerInfo.Name, info.ServerInfo.Version, len(names), strings.Join(names, ", "))
+	return s, nil
+}
+
+// stop unregisters the server's tools and ends its process, killing it if
+// it does not exit by itself
+func (s *mcpServer) stop() {
+	tools.UnregisterServer(s.cfg.Name)
+	done := make(chan struct{})
+	go func() {
+		s.client.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(mcpStopTimeout):
+		s.cancel()
+		<-done
+	}
+	s.cancel()
+	log.Printf("MCP server %s stopped.", s.cfg.Name)
+}
+
+// mcpTool exposes a tool of an MCP server as an eino tool
+type mcpTool struct {
+	server *mcpServer
+	name   string
+	info   *schema.ToolInfo
+}
+
+var _ tool.InvokableTool = (*mcpTool)(nil)
+
+func newMCPTool(s *mcpServer, t mcp.Tool) (*mcpTool, error) {
+	raw := t.RawInputSchema
+	if len(raw) == 0 {
+		var err error
+		if raw, err = json.Marshal(t.InputSchema); err != nil {
+			return nil, err
+		}
+	}
+	params := &jsonschema.Schema{}
+	if err := json.Unmarshal(raw, params); err != nil {
+		return nil, fmt.Errorf("invalid input schema: %v", err)
+	}
+	return &mcpTool{
+		server: s,
+		name:   t.Name,
+		info: &schema.ToolInfo{
+			Name:        mcpToolName(s.cfg.Name, t.Name),
+			Desc:        t.Description,
+			ParamsOneOf: schema.NewParamsOneOfByJSONSchema(params),
+		},
+	}, nil
+}
+
+// mcpToolName returns the name a tool of an MCP server is offered under.
+// A tool name that has to be changed to fit the characters and length
+// OpenAI accepts gets a hash of the original appended, so that e.g. "a.b"
+// and "a_b" are still offered as different tools.
+func mcpToolName(server, tool string) string {
+	prefix := server + mcpToolSeparator
+	safe := invalidToolNameChars.ReplaceAllString(tool, "_")
+	if safe == tool && len(prefix)+len(safe) <= maxToolNameLen {
+		return prefix + safe
+	}
+	sum := sha256.Sum256([]byte(tool))
+	suffix := "_" + hex.EncodeToString(sum[:4])
+	// Server names and safe are ASCII, so cutting by bytes splits no character
+	if n := maxToolNameLen - len(prefix) - len(suffix); len(safe) > n {
+		safe = safe[:n]
+	}
+	return prefix + safe + suffix
+}
+
+func (t *mcpTool) Info(_ context.Context) (*schema.ToolInfo, error) {
+	return t.info, nil
+}
+
+func (t *mcpTool) InvokableRun(ctx context.Context, argumentsInJSON string, _ ...tool.Option) (string, error) {
+	var args map[string]any
+	if strings.TrimSpace(argumentsInJSON) != "" {
+		if err := json.Unmarshal([]byte(argumentsInJSON), &args); err != nil {
+			return "", fmt.Errorf("invalid arguments: %v", err)
+		}
+	}
+	var req mcp.CallToolRequest
+	req.Params.Name = t.name
+	req.Params.Arguments = args
+	res, err := t.server.client.CallTool(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	out := mcpResultText(res)
+	if res.IsError {
+		return "", errors.New(out)
+	}
+	return out, nil
+}
+
+// mcpResultText turns the content of a tool result into text for the model.
+// Binary content is described rather than passed on.
+func mcpResultText(res *mcp.CallToolResult) string {
+	var parts []string
+	for _, c := range res.Content {
+		if tc, ok := mcp.AsTextContent(c); ok {
+			parts = append(parts, tc.Text)
+		} else if ic, ok := mcp.AsImageContent(c); ok {
+			parts = append(parts, fmt.Sprintf("[%s image]", ic.MIMEType))
+		} else if ac, ok := mcp.AsAudioContent(c); ok {
+			parts = append(parts, fmt.Sprintf("[%s audio]", ac.MIMEType))
+		} else if er, ok := mcp.AsEmbeddedResource(c); ok {
+			if tr, ok := mcp.AsTextResourceContents(er.Resource); ok {
+				parts = append(parts, tr.Text)
+			} else {
+				parts = append(parts, "[binary resource]")
+			}
+		} else if data, err := json.Marshal(c); err == nil {
+			parts = append(parts, string(data))
+		}
+	}
+	if len(parts) == 0 && res.StructuredContent != nil {
+		if data, err := json.Marshal(res.StructuredContent); err == nil {
+			parts = append(parts, string(data))
+		}
+	}
+	return strings.Join(parts, "\n")
+}
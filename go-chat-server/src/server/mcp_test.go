@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/cloudwego/eino/components/tool"
+	"github.com/cloudwego/eino/schema"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// mcpFixtureEnv makes the test binary run as a stdio MCP server instead of
+// running the tests, so that the tests can launch it like any other server
+const mcpFixtureEnv = "GO_CHAT_SERVER_MCP_FIXTURE"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(mcpFixtureEnv) == "1" {
+		runMCPFixture()
+		return
+	}
+	os.Exit(m.Run())
+}
+
+func runMCPFixture() {
+	s := server.NewMCPServer("fixture", "1.0.0", server.WithToolCapabilities(false))
+	text := func(out string) server.ToolHandlerFunc {
+		return func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			return mcp.NewToolResultText(out), nil
+		}
+	}
+	s.AddTool(mcp.NewTool("echo", mcp.WithDescription("Echoes text"), mcp.WithString("text", mcp.Required())),
+		func(_ context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			return mcp.NewToolResultText(req.GetString("text", "")), nil
+		})
+	s.AddTool(mcp.NewTool("fail"), func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return mcp.NewToolResultError("boom"), nil
+	})
+	s.AddTool(mcp.NewTool("env"), text(os.Getenv("FIXTURE_TOKEN")))
+	s.AddTool(mcp.NewTool("secret"), text("should not be reachable"))
+	s.AddTool(mcp.NewTool("unlisted"), text("not allowed"))
+	// these two map to the same sanitized name
+	s.AddTool(mcp.NewTool("a.b"), text("dot"))
+	s.AddTool(mcp.NewTool("a_b"), text("underscore"))
+	if err := server.ServeStdio(s); err != nil {
+		os.Exit(1)
+	}
+}
+
+// startMCPFixture runs the fixture as MCP server "fx" with the given tool
+// lists and returns once its tools are registered. Its env tool returns a
+// variable set through an env: reference.
+func startMCPFixture(t *testing.T, allow, deny []string) {
+	t.Helper()
+	t.Setenv("MCP_TEST_TOKEN", "tok-123")
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	configLock.RLock()
+	cfg := config
+	configLock.RUnlock()
+	cfg.Tools = ToolsConfig{MCPServers: []MCPServerConfig{{
+		Name:    "fx",
+		Command: exe,
+		Env:     map[string]string{mcpFixtureEnv: "1", "FIXTURE_TOKEN": "env:MCP_TEST_TOKEN"},
+		Allow:   allow,
+		Deny:    deny,
+	}}}
+	useConfig(t, cfg)
+	configLock.Lock()
+	_, err = resolveSecretsLocked()
+	configLock.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(stopMCPServers)
+	syncMCPServers()
+	if _, ok := tools.Get("fx__echo"); !ok {
+		t.Fatal("fixture tools were not registered")
+	}
+}
+
+func enabledToolNames() []string {
+	var names []string
+	for _, info := range enabledTools() {
+		names = append(names, info.Name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func TestMCPServerTools(t *testing.T) {
+	startMCPFixture(t, []string{"echo", "fail", "env", "secret", "a*"}, []string{"secret"})
+
+	want := []string{"fx__a_b", mcpToolName("fx", "a.b"), "fx__echo", "fx__env", "fx__fail"}
+	slices.Sort(want)
+	if got := enabledToolNames(); !slices.Equal(got, want) {
+		t.Errorf("enabled tools = %v, want %v", got, want)
+	}
+	if _, ok := tools.Get("fx__unlisted"); !ok {
+		t.Error("tools outside the allow list should still be discovered")
+	}
+
+	tests := []struct {
+		name    string
+		args    string
+		result  string
+		errText string
+	}{
+		{"fx__echo", `{"text":"hello"}`, "hello", ""},
+		{"fx__env", ``, "tok-123", ""},
+		{"fx__a_b", ``, "underscore", ""},
+		{mcpToolName("fx", "a.b"), ``, "dot", ""},
+		{"fx__fail", ``, "", "boom"},
+		{"fx__secret", ``, "", "unknown tool"},
+		{"fx__unlisted", ``, "", "unknown tool"},
+		{"fx__echo", `not json`, "", "invalid arguments"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := runTool(context.Background(), schema.ToolCall{ID: "c", Function: schema.FunctionCall{Name: tc.name, Arguments: tc.args}})
+			if rec.Result != tc.result {
+				t.Errorf("result = %q, want %q", rec.Result, tc.result)
+			}
+			if (tc.errText == "") != (rec.Error == "") || !strings.Contains(rec.Error, tc.errText) {
+				t.Errorf("error = %q, want it to contain %q", rec.Error, tc.errText)
+			}
+		})
+	}
+}
+
+func TestMCPServerStop(t *testing.T) {
+	startMCPFixture(t, nil, nil)
+	stopMCPServers()
+	for _, rt := range tools.List() {
+		if rt.server == "fx" {
+			t.Errorf("tool %s still registered after stop", rt.info.Name)
+		}
+	}
+}
+
+func TestMCPToolName(t *testing.T) {
+	valid := regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+	long := strings.Repeat("x", 80)
+	names := []string{"search", "a.b", "a_b", "天气", "天气预报", long, long + "y"}
+	seen := make(map[string]string)
+	for _, n := range names {
+		got := mcpToolName("a-server-name-of-32-characters-x", n)
+		if !valid.MatchString(got) {
+			t.Errorf("mcpToolName(%q) = %q is not a valid tool name", n, got)
+		}
+		if prev, ok := seen[got]; ok {
+			t.Errorf("%q and %q both map to %q", prev, n, got)
+		}
+		seen[got] = n
+	}
+	if got := mcpToolName("docs", "search"); got != "docs__search" {
+		t.Errorf("unchanged names should not get a suffix: %q", got)
+	}
+}
+
+// namedTool is a tool that does nothing
+type namedTool string
+
+func (n namedTool) Info(context.Context) (*schema.ToolInfo, error) {
+	return &schema.ToolInfo{Name: string(n)}, nil
+}
+
+func (n namedTool) InvokableRun(context.Context, string, ...tool.Option) (string, error) {
+	return "", nil
+}
+
+func TestToolRegistryCollision(t *testing.T) {
+	r := &toolRegistry{tools: make(map[string]registeredTool)}
+	if err := r.register(namedTool("x__t"), "x", "t"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.register(namedTool("x__t"), "x", "t"); err != nil {
+		t.Errorf("registering the same tool again: %v", err)
+	}
+	if err := r.register(namedTool("x__t"), "x", "t2"); err == nil {
+		t.Error("a different tool of the same name was accepted")
+	}
+	if err := r.Register(namedTool("x__t")); err == nil {
+		t.Error("a built-in tool replaced an MCP tool")
+	}
+	if got, _ := r.Get("x__t"); got.remoteName != "t" {
+		t.Errorf("registered tool = %+v", got)
+	}
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"eino-rag/memory"
+
+	"github.com/cloudwego/eino/components/model"
+)
+
+// newMemoryManager creates the history window manager from the current config
+func newMemoryManager(llm model.BaseChatModel) *memory.Manager {
+	configLock.RLock()
+	defer configLock.RUnlock()
+
+	cfg := &memory.Config{MaxTokens: config.HistoryMaxTokens}
+	if !config.DisableHistorySummary {
+		cfg.Model = llm
+	}
+	return memory.NewManager(cfg)
+}
@@ -3,22 +3,27 @@ package main
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/cloudwego/eino-ext/components/model/openai"
 	"github.com/cloudwego/eino/components/model"
 )
 
-// CreateOpenAIChatModel initializes and returns a configured OpenAI chat model
-func createOpenAIChatModel(ctx context.Context) (model.ToolCallingChatModel, error) {
-	apiKey := GetAPIKey()
-	if apiKey == "" || apiKey == "your_api_key_here" {
-		return nil, fmt.Errorf("API key not configured")
+// CreateOpenAIChatModel initializes and returns an OpenAI chat model for a profile
+func createOpenAIChatModel(ctx context.Context, p Profile) (model.ToolCallingChatModel, error) {
+	if p.APIKey == "" || p.APIKey == "your_api_key_here" {
+		return nil, fmt.Errorf("API key not configured for profile %s", p.Name)
 	}
 
+	var timeout time.Duration
+	if p.Timeout != "" {
+		timeout, _ = time.ParseDuration(p.Timeout)
+	}
 	chatModel, err := openai.NewChatModel(ctx, &openai.ChatModelConfig{
-		BaseURL: GetBaseURL(),
-		Model:   GetModelName(),
-		APIKey:  apiKey,
+		BaseURL: p.BaseURL,
+		Model:   p.ModelName,
+		APIKey:  p.APIKey,
+		Timeout: timeout,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create OpenAI chat model: %v", err)
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cloudwego/eino/schema"
+)
+
+// The types below mirror the subset of the OpenAI chat completions API that
+// go-chat-server accepts on /v1/chat/completions.
+
+type openAIChatRequest struct {
+	Model         string              `json:"model"`
+	Messages      []openAIChatMessage `json:"messages"`
+	Stream        bool                `json:"stream"`
+	StreamOptions *struct {
+		IncludeUsage bool `json:"include_usage"`
+	} `json:"stream_options,omitempty"`
+	Temperature *float32    `json:"temperature,omitempty"`
+	TopP        *float32    `json:"top_p,omitempty"`
+	MaxTokens   *int        `json:"max_tokens,omitempty"`
+	Stop        openAIStops `json:"stop,omitempty"`
+	Seed        *int        `json:"seed,omitempty"`
+	// ResponseFormat supports the "text" and "json_object" types
+	ResponseFormat *struct {
+		Type string `json:"type"`
+	} `json:"response_format,omitempty"`
+}
+
+type openAIChatMessage struct {
+	Role             string        `json:"role,omitempty"`
+	Content          openAIContent `json:"content"`
+	ReasoningContent string        `json:"reasoning_content,omitempty"`
+	Name             string        `json:"name,omitempty"`
+	ToolCallID       string        `json:"tool_call_id,omitempty"`
+	// ToolCalls are the calls an assistant message made; the following tool
+	// messages answer them by ID
+	ToolCalls []openAIToolCall `json:"tool_calls,omitempty"`
+}
+
+type openAIToolCall struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+// openAIContent accepts both a plain string and an array of content parts;
+// only the text parts are kept
+type openAIContent string
+
+func (c *openAIContent) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*c = openAIContent(text)
+		return nil
+	}
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(data, &parts); err != nil {
+		return fmt.Errorf("content must be a string or an array of parts")
+	}
+	var b strings.Builder
+	for _, p := range parts {
+		if p.Type == "text" {
+			b.WriteString(p.Text)
+		}
+	}
+	*c = openAIContent(b.String())
+	return nil
+}
+
+// openAIStops accepts both a single stop sequence and a list of them
+type openAIStops []string
+
+func (s *openAIStops) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*s = openAIStops{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return fmt.Errorf("stop must be a string or an array of strings")
+	}
+	*s = many
+	return nil
+}
+
+type openAIUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+type openAIChoice struct {
+	Index        int                `json:"index"`
+	Message      *openAIChatMessage `json:"message,omitempty"`
+	Delta        *openAIChatMessage `json:"delta,omitempty"`
+	FinishReason *string            `json:"finish_reason"`
+}
+
+type openAIChatResponse struct {
+	ID      string         `json:"id"`
+	Object  string         `json:"object"`
+	Created int64          `json:"created"`
+	Model   string         `json:"model"`
+	Choices []openAIChoice `json:"choices"`
+	Usage   *openAIUsage   `json:"usage,omitempty"`
+}
+
+// writeOpenAIError writes an error in the OpenAI error format
+func writeOpenAIError(w http.ResponseWriter, status int, errType, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"error": map[string]any{
+			"message": message,
+			"type":    errType,
+		},
+	})
+}
+
+// toSchemaMessages converts the request messages and prepends the configured
+// system prompt, the same way /send does. Tool messages must answer a call
+// made by an earlier assistant message, as upstream providers reject them
+// otherwise.
+func toSchemaMessages(msgs []openAIChatMessage, systemPrompt string) ([]*schema.Message, error) {
+	out := make([]*schema.Message, 0, len(msgs)+1)
+	if systemPrompt != "" {
+		out = append(out, schema.SystemMessage(systemPrompt))
+	}
+	calls := make(map[string]bool)
+	for i, m := range msgs {
+		role := schema.RoleType(m.Role)
+		switch role {
+		case schema.System, schema.User, schema.Assistant, schema.Tool:
+		case "developer":
+			role = schema.System
+		default:
+			return nil, fmt.Errorf("messages[%d]: unsupported role %q", i, m.Role)
+		}
+		if len(m.ToolCalls) > 0 && role != schema.Assistant {
+			return nil, fmt.Errorf("messages[%d]: only assistant messages may have tool_calls", i)
+		}
+		var toolCalls []schema.ToolCall
+		for j, tc := range m.ToolCalls {
+			if tc.ID == "" || tc.Function.Name == "" {
+				return nil, fmt.Errorf("messages[%d].tool_calls[%d]: id and function.name are required", i, j)
+			}
+			if tc.Type != "" && tc.Type != "function" {
+				return nil, fmt.Errorf("messages[%d].tool_calls[%d]: unsupported type %q", i, j, tc.Type)
+			}
+			calls[tc.ID] = true
+			toolCalls = append(toolCalls, schema.ToolCall{
+				ID:       tc.ID,
+				Type:     "function",
+				Function: schema.FunctionCall{Name: tc.Function.Name, Arguments: tc.Function.Arguments},
+			})
+		}
+		if role == schema.Tool && !calls[m.ToolCallID] {
+			return nil, fmt.Errorf("messages[%d]: tool_call_id %q does not match a tool call of an earlier assistant message", i, m.ToolCallID)
+		}
+		out = append(out, &schema.Message{
+			Role:       role,
+			Content:    string(m.Content),
+			Name:       m.Name,
+			ToolCallID: m.ToolCallID,
+			ToolCalls:  toolCalls,
+		})
+	}
+	return out, nil
+}
+
+// params returns the generation parameters set by the request
+func (req *openAIChatRequest) params() GenerationParams {
+	g := GenerationParams{
+		Temperature: req.Temperature,
+		TopP:        req.TopP,
+		MaxTokens:   req.MaxTokens,
+		Stop:        req.Stop,
+		Seed:        req.Seed,
+	}
+	if req.ResponseFormat != nil {
+		g.ResponseFormat = req.ResponseFormat.Type
+	}
+	return g
+}
+
+func toOpenAIUsage(meta *schema.ResponseMeta) *openAIUsage {
+	if meta == nil || meta.Usage == nil {
+		return nil
+	}
+	return &openAIUsage{
+		PromptTokens:     meta.Usage.PromptTokens,
+		CompletionTokens: meta.Usage.CompletionTokens,
+		TotalTokens:      meta.Usage.TotalTokens,
+	}
+}
+
+// mergeResponseMeta folds the metadata of a stream chunk into dst; finish
+// reason and usage usually arrive in different chunks
+func mergeResponseMeta(dst, src *schema.ResponseMeta) {
+	if src == nil {
+		return
+	}
+	if src.FinishReason != "" {
+		dst.FinishReason = src.FinishReason
+	}
+	if src.Usage != nil {
+		dst.Usage = src.Usage
+	}
+}
+
+// addUsage adds the token usage of src to dst
+func addUsage(dst *schema.ResponseMeta, src *schema.TokenUsage) {
+	if src == nil {
+		return
+	}
+	if dst.Usage == nil {
+		dst.Usage = &schema.TokenUsage{}
+	}
+	dst.Usage.PromptTokens += src.PromptTokens
+	dst.Usage.CompletionTokens += src.CompletionTokens
+	dst.Usage.TotalTokens += src.TotalTokens
+}
+
+func openAIFinishReason(meta *schema.ResponseMeta) string {
+	if meta == nil || meta.FinishReason == "" {
+		return "stop"
+	}
+	return meta.FinishReason
+}
+
+func openAIChatCompletionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeOpenAIError(w, http.StatusMethodNotAllowed, "invalid_request_error", "Invalid request method")
+		return
+	}
+
+	var req openAIChatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", fmt.Sprintf("Invalid input: %v", err))
+		return
+	}
+	if len(req.Messages) == 0 {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "messages must not be empty")
+		return
+	}
+	messages, err := toSchemaMessages(req.Messages, GetSystemPrompt())
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+	params := req.params()
+	if err := params.validate(); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+
+	// The model field picks a profile by name; anything else uses the default profile
+	profile := ""
+	if HasProfile(req.Model) {
+		profile = req.Model
+	}
+	llm, err := getChatModel(profile)
+	if err != nil {
+		writeOpenAIError(w, http.StatusServiceUnavailable, "server_error", fmt.Sprintf("Chat model not configured: %v", err))
+		return
+	}
+
+	resp := openAIChatResponse{
+		ID:      "chatcmpl-" + newSessionID(),
+		Created: time.Now().Unix(),
+		Model:   llm.Profile.ModelName,
+	}
+
+	if !req.Stream {
+		out, err := llm.Generate(r.Context(), messages, generationOptions(llm.Profile, params)...)
+		if err != nil {
+			writeOpenAIError(w, http.StatusBadGateway, "upstream_error", fmt.Sprintf("llm generate failed: %v", err))
+			return
+		}
+		resp.Model = llm.answeredBy(out)
+		finish := openAIFinishReason(out.ResponseMeta)
+		resp.Object = "chat.completion"
+		resp.Choices = []openAIChoice{{
+			Message: &openAIChatMessage{
+				Role:             string(schema.Assistant),
+				Content:          openAIContent(out.Content),
+				ReasoningContent: out.ReasoningContent,
+			},
+			FinishReason: &finish,
+		}}
+		resp.Usage = toOpenAIUsage(out.ResponseMeta)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	streamResult, err := stream(r.Context(), llm, messages, generationOptions(llm.Profile, params)...)
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadGateway, "upstream_error", err.Error())
+		return
+	}
+	defer streamResult.Close()
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeOpenAIError(w, http.StatusInternalServerError, "server_error", "Streaming unsupported")
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	bw := bufio.NewWriter(w)
+	writeChunk := func(choices []openAIChoice, usage *openAIUsage) {
+		chunk := resp
+		chunk.Object = "chat.completion.chunk"
+		chunk.Choices = choices
+		chunk.Usage = usage
+		data, _ := json.Marshal(chunk)
+		fmt.Fprintf(bw, "data: %s\n\n", data)
+		bw.Flush()
+		flusher.Flush()
+	}
+
+	// The role chunk waits for the first message, which names the provider
+	// that answered
+	started := false
+	start := func(message *schema.Message) {
+		if !started {
+			started = true
+			if message != nil {
+				resp.Model = llm.answeredBy(message)
+			}
+			writeChunk([]openAIChoice{{Delta: &openAIChatMessage{Role: string(schema.Assistant)}}}, nil)
+		}
+	}
+	meta := &schema.ResponseMeta{}
+	for {
+		message, err := streamResult.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err == nil {
+			start(message)
+		}
+		if err != nil {
+			if r.Context().Err() != nil {
+				log.Println("OpenAI-compatible stream stopped due to context cancellation.")
+				return
+			}
+			log.Printf("Stream recv failed: %v", err)
+			data, _ := json.Marshal(map[string]any{
+				"error": map[string]any{"message": fmt.Sprintf("recv failed: %v", err), "type": "upstream_error"},
+			})
+			fmt.Fprintf(bw, "data: %s\n\n", data)
+			bw.Flush()
+			flusher.Flush()
+			return
+		}
+		mergeResponseMeta(meta, message.ResponseMeta)
+		if message.Content == "" && message.ReasoningContent == "" {
+			continue
+		}
+		writeChunk([]openAIChoice{{Delta: &openAIChatMessage{
+			Content:          openAIContent(message.Content),
+			ReasoningContent: message.ReasoningContent,
+		}}}, nil)
+	}
+
+	start(nil)
+	finish := openAIFinishReason(meta)
+	writeChunk([]openAIChoice{{Delta: &openAIChatMessage{}, FinishReason: &finish}}, nil)
+	if req.StreamOptions != nil && req.StreamOptions.IncludeUsage {
+		if usage := toOpenAIUsage(meta); usage != nil {
+			writeChunk([]openAIChoice{}, usage)
+		}
+	}
+	fmt.Fprint(bw, "data: [DONE]\n\n")
+	bw.Flush()
+	flusher.Flush()
+}
+
+func openAIModelsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		// Every profile is offered as a model
+		profiles, _ := ListProfiles()
+		data := make([]map[string]any, 0, len(profiles))
+		for _, p := range profiles {
+			data = append(data, map[string]any{
+				"id":       p.Name,
+				"object":   "model",
+				"created":  0,
+				"owned_by": "go-chat-server",
+			})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"object": "list",
+			"data":   data,
+		})
+		return
+	}
+
+	writeOpenAIError(w, http.StatusMethodNotAllowed, "invalid_request_error", "Invalid request method")
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/cloudwego/eino/schema"
+)
+
+func TestToSchemaMessagesToolCalls(t *testing.T) {
+	var req openAIChatRequest
+	body := `{"model":"m","messages":[
+		{"role":"user","content":"weather in Paris?"},
+		{"role":"assistant","content":null,"tool_calls":[{"id":"call_1","type":"function","function":{"name":"get_weather","arguments":"{\"city\":\"Paris\"}"}}]},
+		{"role":"tool","tool_call_id":"call_1","content":"18C"}
+	]}`
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		t.Fatal(err)
+	}
+	msgs, err := toSchemaMessages(req.Messages, "sys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 4 || msgs[0].Role != schema.System {
+		t.Fatalf("messages = %v", msgs)
+	}
+	call := msgs[2]
+	if len(call.ToolCalls) != 1 {
+		t.Fatalf("assistant tool calls = %v", call.ToolCalls)
+	}
+	tc := call.ToolCalls[0]
+	if tc.ID != "call_1" || tc.Type != "function" || tc.Function.Name != "get_weather" || tc.Function.Arguments != `{"city":"Paris"}` {
+		t.Errorf("tool call = %+v", tc)
+	}
+	if msgs[3].Role != schema.Tool || msgs[3].ToolCallID != "call_1" || msgs[3].Content != "18C" {
+		t.Errorf("tool message = %+v", msgs[3])
+	}
+}
+
+func TestToSchemaMessagesRejectsUnmatchedTool(t *testing.T) {
+	tests := []struct {
+		name string
+		msgs []openAIChatMessage
+		want string
+	}{
+		{
+			"tool without call",
+			[]openAIChatMessage{{Role: "user", Content: "hi"}, {Role: "tool", ToolCallID: "call_9", Content: "x"}},
+			"does not match",
+		},
+		{
+			"tool calls on a user message",
+			[]openAIChatMessage{{Role: "user", ToolCalls: []openAIToolCall{{ID: "c"}}}},
+			"only assistant",
+		},
+		{
+			"tool call without name",
+			[]openAIChatMessage{{Role: "assistant", ToolCalls: []openAIToolCall{{ID: "c"}}}},
+			"function.name",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := toSchemaMessages(tc.msgs, "")
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("err = %v, want it to mention %q", err, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// envPrefix starts the environment variables that override config fields:
+// rate_limit.burst is set by EINO_RATE_LIMIT_BURST
+const envPrefix = "EINO_"
+
+// envConfigPath names the config file; the -config flag takes precedence
+const envConfigPath = envPrefix + "CONFIG"
+
+// configOverride sets a config field from the environment or a flag. The
+// config file is loaded first and overrides are applied on top in order.
+type configOverride struct {
+	// Key is the dotted JSON path of the field, e.g. "rate_limit.burst"
+	Key    string
+	Value  string
+	Source string
+}
+
+var (
+	// overrides are applied every time the config file is loaded
+	overrides []configOverride
+	// fileSnapshot is the config as read from the file, before overrides;
+	// SaveConfig writes it back for overridden fields so that values given
+	// through the environment never end up in the file. Guarded by configLock.
+	fileSnapshot map[string]any
+	// overriddenPaths are the JSON paths that SaveConfig takes from
+	// fileSnapshot. Guarded by configLock.
+	overriddenPaths [][]string
+)
+
+// legacyProviderKeys are moved into the default profile once set, so their
+// overrides mask that profile's fields when saving
+var legacyProviderKeys = map[string]bool{"api_key": true, "base_url": true, "model_name": true}
+
+// configKeys lists the dotted JSON paths of every field of Config that can
+// be overridden. Lists and maps are set as a whole, as JSON.
+func configKeys() []string {
+	var keys []string
+	var walk func(t reflect.Type, prefix string)
+	walk = func(t reflect.Type, prefix string) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name := jsonName(f)
+			if name == "" {
+				continue
+			}
+			if f.Type.Kind() == reflect.Struct {
+				walk(f.Type, prefix+name+".")
+				continue
+			}
+			keys = append(keys, prefix+name)
+		}
+	}
+	walk(reflect.TypeOf(Config{}), "")
+	return keys
+}
+
+// jsonName returns the JSON name of an exported field, or "" if it is not
+// encoded
+func jsonName(f reflect.StructField) string {
+	if !f.IsExported() {
+		return ""
+	}
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if name == "-" {
+		return ""
+	}
+	if name == "" {
+		name = f.Name
+	}
+	return name
+}
+
+// envName returns the environment variable of a config key
+func envName(key string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// envOverrides collects the config fields set through EINO_* variables
+func envOverrides() []configOverride {
+	var out []configOverride
+	for _, key := range configKeys() {
+		name := envName(key)
+		if value, ok := os.LookupEnv(name); ok {
+			out = append(out, configOverride{Key: key, Value: value, Source: "environment variable " + name})
+		}
+	}
+	return out
+}
+
+// setFlag collects repeated -set key=value flags
+type setFlag []configOverride
+
+func (s *setFlag) String() string { return "" }
+
+func (s *setFlag) Set(arg string) error {
+	key, value, ok := strings.Cut(arg, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("want key=value, e.g. rate_limit.burst=5")
+	}
+	*s = append(*s, configOverride{Key: strings.TrimSpace(key), Value: value, Source: "flag -set " + key})
+	return nil
+}
+
+// checkOverrides reports overrides that do not name a field or whose value
+// does not parse, so that mistakes fail at startup
+func checkOverrides(list []configOverride) error {
+	var scratch Config
+	for _, o := range list {
+		if err := setConfigField(reflect.ValueOf(&scratch).Elem(), strings.Split(o.Key, "."), o.Value); err != nil {
+			return fmt.Errorf("%s: %w", o.Source, err)
+		}
+	}
+	return nil
+}
+
+// applyOverridesLocked applies the overrides to the config just loaded from
+// the file and remembers which fields they cover
+func applyOverridesLocked() error {
+	overriddenPaths = nil
+	for _, o := range overrides {
+		if err := setConfigField(reflect.ValueOf(&config).Elem(), strings.Split(o.Key, "."), o.Value); err != nil {
+			return fmt.Errorf("%s: %w", o.Source, err)
+		}
+		log.Printf("Config field %s set from %s.", o.Key, o.Source)
+		path := strings.Split(o.Key, ".")
+		if legacyProviderKeys[o.Key] {
+			name := config.DefaultProfile
+			if name == "" {
+				name = defaultProfileName
+			}
+			path = []string{"profiles", "name=" + name, o.Key}
+		}
+		overriddenPaths = append(overriddenPaths, path)
+	}
+	return nil
+}
+
+// setConfigField parses value into the field at path. Strings, numbers and
+// booleans are given as is, string lists as JSON or comma separated, and
+// anything else as JSON.
+func setConfigField(v reflect.Value, path []string, value string) error {
+	if len(path) == 0 {
+		return setValue(v, value)
+	}
+	if v.Kind() != reflect.Struct {
+		return fmt.Errorf("%s is not an object", path[0])
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if jsonName(t.Field(i)) == path[0] {
+			return setConfigField(v.Field(i), path[1:], value)
+		}
+	}
+	return fmt.Errorf("unknown config field %q", path[0])
+}
+
+func setValue(v reflect.Value, value string) error {
+	if v.Kind() == reflect.Pointer {
+		p := reflect.New(v.Type().Elem())
+		if err := setValue(p.Elem(), value); err != nil {
+			return err
+		}
+		v.Set(p)
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+		return nil
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", value)
+		}
+		v.SetBool(b)
+		return nil
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", value)
+		}
+		v.SetInt(n)
+		return nil
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", value)
+		}
+		v.SetFloat(f)
+		return nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.String && !strings.HasPrefix(strings.TrimSpace(value), "[") {
+			var items []string
+			for _, item := range strings.Split(value, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+			v.Set(reflect.ValueOf(items))
+			return nil
+		}
+	}
+	p := reflect.New(v.Type())
+	if err := json.Unmarshal([]byte(value), p.Interface()); err != nil {
+		return fmt.Errorf("invalid JSON for %s: %v", v.Type(), err)
+	}
+	v.Set(p.Elem())
+	return nil
+}
+
+// toJSONMap encodes a value and decodes it back as generic JSON
+func toJSONMap(v any) (map[string]any, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]any
+	return m, json.Unmarshal(data, &m)
+}
+
+// maskOverridden replaces the overridden fields of cfg with their values in
+// the file, dropping those the file does not set
+func maskOverridden(cfg, file map[string]any) {
+	for _, path := range overriddenPaths {
+		maskPath(cfg, file, path)
+	}
+}
+
+func maskPath(dst, src map[string]any, path []string) {
+	key := path[0]
+	if len(path) == 1 {
+		if v, ok := src[key]; ok {
+			dst[key] = v
+		} else {
+			delete(dst, key)
+		}
+		return
+	}
+	next := path[1]
+	// "name=x" selects the element of a list whose name is x
+	if name, ok := strings.CutPrefix(next, "name="); ok {
+		d, s := findNamed(dst[key], name), findNamed(src[key], name)
+		if d != nil {
+			if s == nil {
+				s = map[string]any{}
+			}
+			maskPath(d, s, path[2:])
+		}
+		return
+	}
+	d, ok := dst[key].(map[string]any)
+	if !ok {
+		return
+	}
+	s, _ := src[key].(map[string]any)
+	if s == nil {
+		s = map[string]any{}
+	}
+	maskPath(d, s, path[1:])
+	// Keep the file free of objects that only held overridden fields
+	if len(d) == 0 {
+		if _, inFile := src[key]; !inFile {
+			delete(dst, key)
+		}
+	}
+}
+
+func findNamed(list any, name string) map[string]any {
+	items, _ := list.([]any)
+	for _, item := range items {
+		if m, ok := item.(map[string]any); ok && m["name"] == name {
+			return m
+		}
+	}
+	return nil
+}
+
+// printConfigKeys lists the overridable fields and their variables
+func printConfigKeys() {
+	keys := configKeys()
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Printf("%-40s %s\n", key, envName(key))
+	}
+}
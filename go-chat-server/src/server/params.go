@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/cloudwego/eino-ext/components/model/openai"
+	"github.com/cloudwego/eino/components/model"
+)
+
+// maxStopSequences is the most stop sequences OpenAI accepts
+const maxStopSequences = 4
+
+// Response formats accepted by GenerationParams.ResponseFormat
+const (
+	responseFormatText = "text"
+	responseFormatJSON = "json_object"
+)
+
+// GenerationParams control how the model samples an answer. Unset fields
+// are left to the next layer: request, then profile, then the config-wide
+// defaults, then the provider.
+type GenerationParams struct {
+	Temperature *float32 `json:"temperature,omitempty"`
+	TopP        *float32 `json:"top_p,omitempty"`
+	MaxTokens   *int     `json:"max_tokens,omitempty"`
+	Stop        []string `json:"stop,omitempty"`
+	Seed        *int     `json:"seed,omitempty"`
+	// ResponseFormat is "text" or "json_object"
+	ResponseFormat string `json:"response_format,omitempty"`
+}
+
+func (g *GenerationParams) validate() error {
+	if g.Temperature != nil && (*g.Temperature < 0 || *g.Temperature > 2) {
+		return fmt.Errorf("temperature must be between 0 and 2")
+	}
+	if g.TopP != nil && (*g.TopP <= 0 || *g.TopP > 1) {
+		return fmt.Errorf("top_p must be greater than 0 and at most 1")
+	}
+	if g.MaxTokens != nil && *g.MaxTokens < 1 {
+		return fmt.Errorf("max_tokens must be at least 1")
+	}
+	if len(g.Stop) > maxStopSequences {
+		return fmt.Errorf("at most %d stop sequences are allowed", maxStopSequences)
+	}
+	for _, s := range g.Stop {
+		if s == "" {
+			return fmt.Errorf("stop sequences must not be empty")
+		}
+	}
+	switch g.ResponseFormat {
+	case "", responseFormatText, responseFormatJSON:
+	default:
+		return fmt.Errorf("response_format must be %q or %q", responseFormatText, responseFormatJSON)
+	}
+	return nil
+}
+
+// merge returns g with the fields set in over replacing its own
+func (g GenerationParams) merge(over GenerationParams) GenerationParams {
+	if over.Temperature != nil {
+		g.Temperature = over.Temperature
+	}
+	if over.TopP != nil {
+		g.TopP = over.TopP
+	}
+	if over.MaxTokens != nil {
+		g.MaxTokens = over.MaxTokens
+	}
+	if over.Stop != nil {
+		g.Stop = over.Stop
+	}
+	if over.Seed != nil {
+		g.Seed = over.Seed
+	}
+	if over.ResponseFormat != "" {
+		g.ResponseFormat = over.ResponseFormat
+	}
+	return g
+}
+
+// options converts the parameters to eino model options. Seed and response
+// format have no common option and are sent as extra request fields.
+func (g *GenerationParams) options() []model.Option {
+	var opts []model.Option
+	if g.Temperature != nil {
+		opts = append(opts, model.WithTemperature(*g.Temperature))
+	}
+	if g.TopP != nil {
+		opts = append(opts, model.WithTopP(*g.TopP))
+	}
+	if g.MaxTokens != nil {
+		opts = append(opts, model.WithMaxTokens(*g.MaxTokens))
+	}
+	if len(g.Stop) > 0 {
+		opts = append(opts, model.WithStop(g.Stop))
+	}
+	extra := map[string]any{}
+	if g.Seed != nil {
+		extra["seed"] = *g.Seed
+	}
+	if g.ResponseFormat != "" {
+		extra["response_format"] = map[string]any{"type": g.ResponseFormat}
+	}
+	if len(extra) > 0 {
+		opts = append(opts, openai.WithExtraFields(extra))
+	}
+	return opts
+}
+
+// GetGenerationDefaults returns the config-wide generation parameters
+func GetGenerationDefaults() GenerationParams {
+	configLock.RLock()
+	defer configLock.RUnlock()
+	return config.Generation
+}
+
+// UpdateGenerationDefaults replaces the config-wide generation parameters
+func UpdateGenerationDefaults(g GenerationParams) error {
+	configLock.Lock()
+	defer configLock.Unlock()
+	config.Generation = g
+	return SaveConfig()
+}
+
+// generationOptions layers the request's parameters over those of the
+// profile and the config-wide defaults
+func generationOptions(p Profile, req GenerationParams) []model.Option {
+	g := GetGenerationDefaults().merge(p.GenerationParams).merge(req)
+	return g.options()
+}
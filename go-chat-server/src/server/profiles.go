@@ -0,0 +1,381 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+
+	"eino-rag/fallback"
+
+	"github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/schema"
+	"github.com/gorilla/mux"
+)
+
+// defaultProfileName is the profile the legacy api_key, base_url and
+// model_name settings are migrated to
+const defaultProfileName = "default"
+
+// profileKey records in Message.Extra which profile wrote an answer
+const profileKey = "profile"
+
+var profileNamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+var errUnknownProfile = errors.New("unknown profile")
+
+// errAPIKeyRequired keeps a stored key from being sent to a new host
+var errAPIKeyRequired = errors.New("api_key is required when base_url changes")
+
+// Profile is a named model provider sessions and requests can choose from
+type Profile struct {
+	Name      string `json:"name"`
+	BaseURL   string `json:"base_url"`
+	APIKey    string `json:"api_key"`
+	ModelName string `json:"model_name"`
+	// GenerationParams apply to every request made with the profile
+	GenerationParams
+	// Timeout bounds a whole request to the provider, e.g. "90s"
+	Timeout string `json:"timeout,omitempty"`
+	// Fallbacks are the profiles tried in order when this one fails
+	Fallbacks []string `json:"fallbacks,omitempty"`
+}
+
+func (p *Profile) validate() error {
+	if !profileNamePattern.MatchString(p.Name) {
+		return fmt.Errorf("profile name must be 1-64 letters, digits, '.', '_' or '-'")
+	}
+	if p.BaseURL == "" || p.ModelName == "" {
+		return fmt.Errorf("base_url and model_name are required")
+	}
+	if p.Timeout != "" {
+		if d, err := time.ParseDuration(p.Timeout); err != nil || d < 0 {
+			return fmt.Errorf("invalid timeout %q", p.Timeout)
+		}
+	}
+	if err := p.GenerationParams.validate(); err != nil {
+		return err
+	}
+	for _, name := range p.Fallbacks {
+		if !profileNamePattern.MatchString(name) || name == p.Name {
+			return fmt.Errorf("invalid fallback %q", name)
+		}
+	}
+	return nil
+}
+
+// migrateLegacyProfileLocked moves the single provider of older config
+// files into a profile named "default". With profiles configured, the
+// legacy settings that are set update the default profile.
+func migrateLegacyProfileLocked() {
+	if findProfileLocked(config.DefaultProfile) == nil && len(config.Profiles) > 0 {
+		config.DefaultProfile = config.Profiles[0].Name
+	}
+	if config.APIKey != "" || config.BaseURL != "" || config.ModelName != "" {
+		p := defaultProfileForUpdateLocked()
+		if config.APIKey != "" {
+			p.APIKey = config.APIKey
+		}
+		if config.BaseURL != "" {
+			p.BaseURL = config.BaseURL
+		}
+		if config.ModelName != "" {
+			p.ModelName = config.ModelName
+		}
+	}
+	config.APIKey, config.BaseURL, config.ModelName = "", "", ""
+}
+
+func findProfileLocked(name string) *Profile {
+	for i := range config.Profiles {
+		if config.Profiles[i].Name == name {
+			return &config.Profiles[i]
+		}
+	}
+	return nil
+}
+
+// defaultProfileForUpdateLocked returns the default profile, creating it if
+// there is none so that the legacy settings have somewhere to go
+func defaultProfileForUpdateLocked() *Profile {
+	if p := findProfileLocked(config.DefaultProfile); p != nil {
+		return p
+	}
+	config.Profiles = append(config.Profiles, Profile{Name: defaultProfileName})
+	config.DefaultProfile = defaultProfileName
+	return &config.Profiles[len(config.Profiles)-1]
+}
+
+// GetProfile returns a profile by name; an empty name is the default profile
+func GetProfile(name string) (Profile, error) {
+	configLock.RLock()
+	defer configLock.RUnlock()
+	if name == "" {
+		name = config.DefaultProfile
+	}
+	p := findProfileLocked(name)
+	if p == nil {
+		return Profile{}, fmt.Errorf("%w %q", errUnknownProfile, name)
+	}
+	return *p, nil
+}
+
+// HasProfile reports whether a profile exists
+func HasProfile(name string) bool {
+	configLock.RLock()
+	defer configLock.RUnlock()
+	return findProfileLocked(name) != nil
+}
+
+// ListProfiles returns all profiles and the name of the default one
+func ListProfiles() ([]Profile, string) {
+	configLock.RLock()
+	defer configLock.RUnlock()
+	return append([]Profile(nil), config.Profiles...), config.DefaultProfile
+}
+
+// SaveProfile creates or replaces a profile and saves the config file. An
+// empty API key keeps the key of the profile being replaced as long as the
+// base URL stays the same, otherwise errAPIKeyRequired is returned;
+// references are rejected with errInvalidSecret.
+func SaveProfile(p Profile, makeDefault bool) error {
+	configLock.Lock()
+	defer configLock.Unlock()
+	if p.APIKey != "" {
+		if err := setSecretLocked(profileSecretLoc(p.Name), &p.APIKey, p.APIKey); err != nil {
+			return err
+		}
+	}
+	if old := findProfileLocked(p.Name); old != nil {
+		if p.APIKey == "" {
+			if old.APIKey != "" && !sameBaseURL(p.BaseURL, old.BaseURL) {
+				return errAPIKeyRequired
+			}
+			p.APIKey = old.APIKey
+		}
+		*old = p
+	} else {
+		config.Profiles = append(config.Profiles, p)
+	}
+	if makeDefault || config.DefaultProfile == "" {
+		config.DefaultProfile = p.Name
+	}
+	return SaveConfig()
+}
+
+// DeleteProfile removes a profile other than the default one
+func DeleteProfile(name string) error {
+	configLock.Lock()
+	defer configLock.Unlock()
+	if name == config.DefaultProfile {
+		return fmt.Errorf("the default profile cannot be deleted")
+	}
+	for i := range config.Profiles {
+		if config.Profiles[i].Name == name {
+			config.Profiles = append(config.Profiles[:i], config.Profiles[i+1:]...)
+			delete(storedSecrets, profileSecretLoc(name))
+			return SaveConfig()
+		}
+	}
+	return fmt.Errorf("%w %q", errUnknownProfile, name)
+}
+
+// profileModel is a chat model together with the profile it was created from
+type profileModel struct {
+	model.ToolCallingChatModel
+	Profile Profile
+}
+
+// answeredBy returns the name of the model that wrote msg, which differs
+// from the profile's own when a fallback answered
+func (m *profileModel) answeredBy(msg *schema.Message) string {
+	name, _ := msg.Extra[fallback.ProviderKey].(string)
+	if name == "" || name == m.Profile.Name {
+		return m.Profile.ModelName
+	}
+	if p, err := GetProfile(name); err == nil {
+		return p.ModelName
+	}
+	return m.Profile.ModelName
+}
+
+var (
+	mu     sync.Mutex
+	models = make(map[string]*profileModel)
+)
+
+// getChatModel returns the chat model of a profile, creating it on first
+// use; an empty name selects the default profile
+func getChatModel(name string) (*profileModel, error) {
+	p, err := GetProfile(name)
+	if err != nil {
+		return nil, err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if m, ok := models[p.Name]; ok {
+		return m, nil
+	}
+	llm, err := createChainLocked(context.Background(), p)
+	if err != nil {
+		return nil, err
+	}
+	m := &profileModel{ToolCallingChatModel: llm, Profile: p}
+	models[p.Name] = m
+	return m, nil
+}
+
+// invalidateModels drops cached chat models so they get recreated from the
+// current config; without names every model is dropped. A changed profile
+// also invalidates the chains it is a fallback of and starts with a fresh
+// circuit breaker.
+func invalidateModels(names ...string) {
+	mu.Lock()
+	defer mu.Unlock()
+	if len(names) == 0 {
+		clear(models)
+		clear(breakers)
+		return
+	}
+	for _, name := range names {
+		delete(breakers, name)
+		for key, m := range models {
+			if key == name || slices.Contains(m.Profile.Fallbacks, name) {
+				delete(models, key)
+			}
+		}
+	}
+}
+
+// chatModelError answers a request whose chat model could not be created
+func chatModelError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errUnknownProfile) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Error(w, fmt.Sprintf("Chat model not configured: %v. Please set a valid API key in the settings.", err), http.StatusBadRequest)
+}
+
+// profileInfo is a profile as returned by the API, without its key
+type profileInfo struct {
+	Name      string `json:"name"`
+	BaseURL   string `json:"base_url"`
+	ModelName string `json:"model_name"`
+	GenerationParams
+	Timeout   string   `json:"timeout,omitempty"`
+	Fallbacks []string `json:"fallbacks,omitempty"`
+	HasAPIKey bool     `json:"has_api_key"`
+	Default   bool     `json:"default"`
+}
+
+func newProfileInfo(p Profile, defaultName string) profileInfo {
+	return profileInfo{
+		Name:             p.Name,
+		BaseURL:          p.BaseURL,
+		ModelName:        p.ModelName,
+		GenerationParams: p.GenerationParams,
+		Timeout:          p.Timeout,
+		Fallbacks:        p.Fallbacks,
+		HasAPIKey:        p.APIKey != "",
+		Default:          p.Name == defaultName,
+	}
+}
+
+func listProfilesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		profiles, defaultName := ListProfiles()
+		infos := make([]profileInfo, 0, len(profiles))
+		for _, p := range profiles {
+			infos = append(infos, newProfileInfo(p, defaultName))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"default":  defaultName,
+			"profiles": infos,
+		})
+		return
+	}
+
+	http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+}
+
+// saveProfileHandler serves POST /profiles, which creates a profile, and
+// PUT /profiles/{name}, which replaces one
+func saveProfileHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost || r.Method == http.MethodPut {
+		var req struct {
+			Profile
+			Default bool `json:"default"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid input", http.StatusBadRequest)
+			return
+		}
+		exists := false
+		if r.Method == http.MethodPut {
+			req.Name = mux.Vars(r)["name"]
+			if exists = HasProfile(req.Name); !exists {
+				http.Error(w, "Profile not found", http.StatusNotFound)
+				return
+			}
+		} else if HasProfile(req.Name) {
+			http.Error(w, "Profile already exists", http.StatusConflict)
+			return
+		}
+		if err := req.Profile.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.APIKey != "" {
+			warnInsecureSecret(r, "API key of profile "+req.Name)
+		}
+		if err := SaveProfile(req.Profile, req.Default); err != nil {
+			if errors.Is(err, errInvalidSecret) || errors.Is(err, errAPIKeyRequired) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			http.Error(w, "Failed to save profile", http.StatusInternalServerError)
+			return
+		}
+		invalidateModels(req.Name)
+		log.Printf("Profile %s saved.", req.Name)
+
+		p, _ := GetProfile(req.Name)
+		_, defaultName := ListProfiles()
+		w.Header().Set("Content-Type", "application/json")
+		if !exists {
+			w.WriteHeader(http.StatusCreated)
+		}
+		json.NewEncoder(w).Encode(newProfileInfo(p, defaultName))
+		return
+	}
+
+	http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+}
+
+func deleteProfileHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodDelete {
+		name := mux.Vars(r)["name"]
+		if err := DeleteProfile(name); err != nil {
+			status := http.StatusConflict
+			if errors.Is(err, errUnknownProfile) {
+				status = http.StatusNotFound
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		invalidateModels(name)
+		log.Printf("Profile %s deleted.", name)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"status": "ok"})
+		return
+	}
+
+	http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+}
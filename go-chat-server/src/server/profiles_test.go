@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestSaveProfileKeepsKeyOnlyForSameBaseURL(t *testing.T) {
+	put := func(body string) *httptest.ResponseRecorder {
+		req := mux.SetURLVars(httptest.NewRequest(http.MethodPut, "/profiles/main", bytes.NewBufferString(body)), map[string]string{"name": "main"})
+		rec := httptest.NewRecorder()
+		saveProfileHandler(rec, req)
+		return rec
+	}
+	tests := []struct {
+		name    string
+		body    string
+		status  int
+		baseURL string
+		apiKey  string
+	}{
+		{"same base URL keeps the key", `{"base_url":"https://api.example.com/v1/","model_name":"m2"}`, http.StatusOK, "https://api.example.com/v1/", "sk-stored"},
+		{"new base URL without a key", `{"base_url":"http://attacker.invalid/v1","model_name":"m2"}`, http.StatusBadRequest, "https://api.example.com/v1", "sk-stored"},
+		{"new base URL with a key", `{"base_url":"https://other.example.com/v1","api_key":"sk-new","model_name":"m2"}`, http.StatusOK, "https://other.example.com/v1", "sk-new"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			useConfig(t, testProfileConfig())
+			if rec := put(tc.body); rec.Code != tc.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tc.status, rec.Body)
+			}
+			p, _ := GetProfile("main")
+			if p.BaseURL != tc.baseURL || p.APIKey != tc.apiKey {
+				t.Errorf("profile = %s with key %q, want %s with key %q", p.BaseURL, p.APIKey, tc.baseURL, tc.apiKey)
+			}
+		})
+	}
+}
+
+func TestUpdateConfigRequiresKeyForNewBaseURL(t *testing.T) {
+	useConfig(t, testProfileConfig())
+	rec := httptest.NewRecorder()
+	updateConfigHandler(rec, httptest.NewRequest(http.MethodPost, "/update-config", bytes.NewBufferString(`{"base_url":"http://attacker.invalid/v1","model_name":"other"}`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400: %s", rec.Code, rec.Body)
+	}
+	if p, _ := GetProfile(""); p.BaseURL != "https://api.example.com/v1" || p.ModelName != "m" {
+		t.Errorf("profile changed: %+v", p)
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultQueueTimeout = 30 * time.Second
+	// busyRetryAfter is suggested to clients turned away because every slot is taken
+	busyRetryAfter = 5 * time.Second
+	// maxIdleBuckets is how many client buckets are kept before full ones are dropped
+	maxIdleBuckets = 10000
+)
+
+// RateLimitConfig limits how often and how many generations a client may
+// run. Zero values disable the corresponding limit, except for MaxQueue.
+type RateLimitConfig struct {
+	// RequestsPerMinute is the sustained rate of generations per client
+	RequestsPerMinute float64 `json:"requests_per_minute,omitempty"`
+	// Burst is how many generations a client may start at once. Default: RequestsPerMinute
+	Burst int `json:"burst,omitempty"`
+	// MaxConcurrentPerClient caps the streams a single client has open
+	MaxConcurrentPerClient int `json:"max_concurrent_per_client,omitempty"`
+	// MaxConcurrent caps the streams open across all clients
+	MaxConcurrent int `json:"max_concurrent,omitempty"`
+	// MaxQueue is how many requests may wait for a free stream; beyond that
+	// they are rejected right away. Zero means no queue: requests that find
+	// no free stream are rejected.
+	MaxQueue int `json:"max_queue,omitempty"`
+	// QueueTimeout bounds the wait for a free stream, e.g. "10s". Default: 30s
+	QueueTimeout string `json:"queue_timeout,omitempty"`
+}
+
+// GetRateLimitConfig returns the current rate limits
+func GetRateLimitConfig() RateLimitConfig {
+	configLock.RLock()
+	defer configLock.RUnlock()
+	return config.RateLimit
+}
+
+func (c *RateLimitConfig) burst() float64 {
+	if c.Burst > 0 {
+		return float64(c.Burst)
+	}
+	return math.Max(1, math.Floor(c.RequestsPerMinute))
+}
+
+func (c *RateLimitConfig) queueTimeout() time.Duration {
+	if c.QueueTimeout != "" {
+		if d, err := time.ParseDuration(c.QueueTimeout); err == nil && d >= 0 {
+			return d
+		}
+		log.Printf("Invalid rate_limit.queue_timeout %q, using %s", c.QueueTimeout, defaultQueueTimeout)
+	}
+	return defaultQueueTimeout
+}
+
+// errBusy is returned when no stream slot frees up in time
+type errBusy struct{ reason string }
+
+func (e errBusy) Error() string { return e.reason }
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter keeps a token bucket and the open streams of every client
+type rateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	active  map[string]int
+	total   int
+	waiting int
+	// freed is closed and replaced whenever a stream ends, waking the waiters
+	freed chan struct{}
+}
+
+var limits = newRateLimiter()
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{
+		buckets: make(map[string]*bucket),
+		active:  make(map[string]int),
+		freed:   make(chan struct{}),
+	}
+}
+
+// Allow takes a token from the client's bucket, or reports how long until
+// one is available
+func (l *rateLimiter) Allow(cfg *RateLimitConfig, client string, now time.Time) (bool, time.Duration) {
+	if cfg.RequestsPerMinute <= 0 {
+		return true, 0
+	}
+	rate := cfg.RequestsPerMinute / 60 // tokens per second
+	burst := cfg.burst()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[client]
+	if !ok {
+		l.pruneLocked(cfg, now)
+		b = &bucket{tokens: burst, last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / rate * float64(time.Second))
+}
+
+// pruneLocked forgets clients whose bucket has refilled, since a new bucket
+// would be identical
+func (l *rateLimiter) pruneLocked(cfg *RateLimitConfig, now time.Time) {
+	if len(l.buckets) < maxIdleBuckets {
+		return
+	}
+	rate, burst := cfg.RequestsPerMinute/60, cfg.burst()
+	for client, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*rate >= burst {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// Acquire reserves a stream slot for the client, waiting in the queue while
+// the client or the server is at its concurrency limit. The returned func
+// releases the slot.
+func (l *rateLimiter) Acquire(ctx context.Context, cfg *RateLimitConfig, client string) (func(), error) {
+	l.mu.Lock()
+	if !l.tryAcquireLocked(cfg, client) {
+		if l.waiting >= cfg.MaxQueue {
+			l.mu.Unlock()
+			return nil, errBusy{"Too many concurrent requests"}
+		}
+		l.waiting++
+		defer func() {
+			l.mu.Lock()
+			l.waiting--
+			l.mu.Unlock()
+		}()
+
+		timer := time.NewTimer(cfg.queueTimeout())
+		defer timer.Stop()
+		for !l.tryAcquireLocked(cfg, client) {
+			freed := l.freed
+			l.mu.Unlock()
+			select {
+			case <-freed:
+			case <-timer.C:
+				return nil, errBusy{"Timed out waiting for a free slot"}
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			l.mu.Lock()
+		}
+	}
+	l.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { l.release(client) })
+	}, nil
+}
+
+func (l *rateLimiter) tryAcquireLocked(cfg *RateLimitConfig, client string) bool {
+	if cfg.MaxConcurrent > 0 && l.total >= cfg.MaxConcurrent {
+		return false
+	}
+	if cfg.MaxConcurrentPerClient > 0 && l.active[client] >= cfg.MaxConcurrentPerClient {
+		return false
+	}
+	l.total++
+	l.active[client]++
+	return true
+}
+
+func (l *rateLimiter) release(client string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.total--
+	if l.active[client]--; l.active[client] <= 0 {
+		delete(l.active, client)
+	}
+	close(l.freed)
+	l.freed = make(chan struct{})
+}
+
+// clientKey identifies the caller for rate limiting: the signed-in user, or
+// the remote IP while auth is disabled
+func clientKey(r *http.Request) string {
+	if p := principalFrom(r.Context()); p != nil {
+		return "user:" + p.Name
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+func setRetryAfter(w http.ResponseWriter, d time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(d.Seconds()))))
+}
+
+// rateLimitMiddleware guards the routes that open an upstream stream; it must
+// run after authMiddleware so that limits apply per user
+func rateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := GetRateLimitConfig()
+		client := clientKey(r)
+
+		if ok, wait := limits.Allow(&cfg, client, time.Now()); !ok {
+			setRetryAfter(w, wait)
+			rejectRequest(w, r, http.StatusTooManyRequests,
+				fmt.Sprintf("Rate limit exceeded, retry in %.0f seconds", math.Ceil(wait.Seconds())))
+			return
+		}
+
+		release, err := limits.Acquire(r.Context(), &cfg, client)
+		if err != nil {
+			if _, busy := err.(errBusy); busy {
+				setRetryAfter(w, busyRetryAfter)
+				rejectRequest(w, r, http.StatusTooManyRequests, err.Error())
+			}
+			// Otherwise the client went away while queued
+			return
+		}
+		defer release()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	l := newRateLimiter()
+	cfg := &RateLimitConfig{RequestsPerMinute: 60, Burst: 2}
+	now := time.Now()
+	for i := range 2 {
+		if ok, _ := l.Allow(cfg, "a", now); !ok {
+			t.Fatalf("request %d within the burst was refused", i)
+		}
+	}
+	if ok, wait := l.Allow(cfg, "a", now); ok || wait != time.Second {
+		t.Errorf("third request = %v, retry in %s, want refused for 1s", ok, wait)
+	}
+	if ok, _ := l.Allow(cfg, "b", now); !ok {
+		t.Error("another client was refused")
+	}
+	if ok, _ := l.Allow(cfg, "a", now.Add(time.Second)); !ok {
+		t.Error("request after the refill was refused")
+	}
+}
+
+// acquireAsync starts an Acquire and returns the channel its error is sent on
+func acquireAsync(l *rateLimiter, cfg *RateLimitConfig, client string) <-chan error {
+	done := make(chan error, 1)
+	go func() {
+		release, err := l.Acquire(context.Background(), cfg, client)
+		if err == nil {
+			defer release()
+		}
+		done <- err
+	}()
+	return done
+}
+
+// waitQueued waits until n requests are queued
+func waitQueued(t *testing.T, l *rateLimiter, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		l.mu.Lock()
+		waiting := l.waiting
+		l.mu.Unlock()
+		if waiting == n {
+			return
+		}
+	}
+	t.Fatalf("%d requests were not queued", n)
+}
+
+func TestRateLimiterQueue(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("no queue", func(t *testing.T) {
+		l := newRateLimiter()
+		cfg := &RateLimitConfig{MaxConcurrent: 1}
+		release, err := l.Acquire(ctx, cfg, "a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer release()
+		if _, err := l.Acquire(ctx, cfg, "b"); !errors.As(err, new(errBusy)) {
+			t.Errorf("error = %v, want the request rejected right away", err)
+		}
+	})
+
+	t.Run("queued until a stream ends", func(t *testing.T) {
+		l := newRateLimiter()
+		cfg := &RateLimitConfig{MaxConcurrent: 1, MaxQueue: 1}
+		release, err := l.Acquire(ctx, cfg, "a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		queued := acquireAsync(l, cfg, "b")
+		waitQueued(t, l, 1)
+		if _, err := l.Acquire(ctx, cfg, "c"); !errors.As(err, new(errBusy)) {
+			t.Errorf("error = %v, want the request beyond the queue rejected", err)
+		}
+		release()
+		if err := <-queued; err != nil {
+			t.Errorf("queued request: %v", err)
+		}
+	})
+
+	t.Run("queue timeout", func(t *testing.T) {
+		l := newRateLimiter()
+		cfg := &RateLimitConfig{MaxConcurrentPerClient: 1, MaxQueue: 1, QueueTimeout: "10ms"}
+		release, err := l.Acquire(ctx, cfg, "a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer release()
+		if _, err := l.Acquire(ctx, cfg, "a"); !errors.As(err, new(errBusy)) {
+			t.Errorf("error = %v, want a timeout", err)
+		}
+		other, err := l.Acquire(ctx, cfg, "b")
+		if err != nil {
+			t.Errorf("another client had to wait: %v", err)
+		} else {
+			other()
+		}
+	})
+}
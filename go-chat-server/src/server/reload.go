@@ -0,0 +1,261 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultReloadInterval is how often the config file is checked for changes
+const defaultReloadInterval = 2 * time.Second
+
+// secretConfigKeys are the JSON keys whose values are masked in config diffs
+// and in the objects they name, like the env of MCP servers
+var secretConfigKeys = map[string]bool{"api_key": true, "session_secret": true, "token": true, "password_hash": true, "env": true}
+
+// restartConfigKeys are read once at startup; changing them needs a restart
+var restartConfigKeys = []string{"server.", "history_store", "history_dir"}
+
+// reloadInterval returns how often the config file is checked, 0 if never
+func (s ServerConfig) reloadInterval() time.Duration {
+	if s.ReloadInterval == "" {
+		return defaultReloadInterval
+	}
+	d, err := time.ParseDuration(s.ReloadInterval)
+	if err != nil && s.ReloadInterval != "0" {
+		log.Printf("Invalid server.reload_interval %q, using %s", s.ReloadInterval, defaultReloadInterval)
+		return defaultReloadInterval
+	}
+	return max(d, 0)
+}
+
+// validateConfigLocked checks the parts of the config that the server would
+// otherwise silently replace by defaults
+func validateConfigLocked() error {
+	var errs []error
+	seen := make(map[string]bool)
+	for _, p := range config.Profiles {
+		if err := p.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("profile %q: %w", p.Name, err))
+		}
+		if seen[p.Name] {
+			errs = append(errs, fmt.Errorf("profile %q is defined twice", p.Name))
+		}
+		seen[p.Name] = true
+	}
+	for _, p := range config.Profiles {
+		for _, name := range p.Fallbacks {
+			if !seen[name] {
+				errs = append(errs, fmt.Errorf("profile %q: unknown fallback %q", p.Name, name))
+			}
+		}
+	}
+	if err := config.Generation.validate(); err != nil {
+		errs = append(errs, fmt.Errorf("generation: %w", err))
+	}
+	for name, value := range map[string]string{
+		"rate_limit.queue_timeout": config.RateLimit.QueueTimeout,
+		"fallback.initial_backoff": config.Fallback.InitialBackoff,
+		"fallback.max_backoff":     config.Fallback.MaxBackoff,
+		"fallback.open_timeout":    config.Fallback.OpenTimeout,
+		"auth.token_ttl":           config.Auth.TokenTTL,
+		"tools.timeout":            config.Tools.Timeout,
+	} {
+		if value == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(value); err != nil || d < 0 {
+			errs = append(errs, fmt.Errorf("invalid %s %q", name, value))
+		}
+	}
+	if config.Tools.MaxRounds < 0 {
+		errs = append(errs, fmt.Errorf("tools.max_rounds must not be negative"))
+	}
+	if dir := config.Tools.FileRoot; dir != "" {
+		if st, err := os.Stat(dir); err != nil || !st.IsDir() {
+			errs = append(errs, fmt.Errorf("tools.file_root %q is not a directory", dir))
+		}
+	}
+	servers := make(map[string]bool)
+	for _, s := range config.Tools.MCPServers {
+		if err := s.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("tools.mcp_servers %q: %w", s.Name, err))
+		}
+		if servers[s.Name] {
+			errs = append(errs, fmt.Errorf("tools.mcp_servers %q is defined twice", s.Name))
+		}
+		servers[s.Name] = true
+	}
+	switch config.HistoryStore {
+	case "", historyStoreFile, historyStoreMemory:
+	default:
+		errs = append(errs, fmt.Errorf("unknown history_store %q", config.HistoryStore))
+	}
+	// Users and tokens own sessions by name, so a name must identify one of them
+	principals := make(map[string]string)
+	addPrincipal := func(kind, name string) {
+		if prev, ok := principals[name]; ok && name != "" {
+			errs = append(errs, fmt.Errorf("auth %s %q has the same name as an auth %s", kind, name, prev))
+			return
+		}
+		principals[name] = kind
+	}
+	for _, u := range config.Auth.Users {
+		if u.Name == "" || !strings.HasPrefix(u.PasswordHash, passwordHashScheme+"$") {
+			errs = append(errs, fmt.Errorf("auth user %q needs a name and a password_hash from -hash-password", u.Name))
+		}
+		addPrincipal("user", u.Name)
+	}
+	for _, t := range config.Auth.Tokens {
+		if t.Name == "" || t.Token == "" {
+			errs = append(errs, fmt.Errorf("auth token %q needs a name and a token", t.Name))
+		}
+		addPrincipal("token", t.Name)
+	}
+	return errors.Join(errs...)
+}
+
+// ReloadConfig reads the config file again and swaps it in if it is valid.
+// An invalid file is rejected and the running config is kept.
+func ReloadConfig() error {
+	configLock.Lock()
+	prev, prevSnapshot, prevPaths, prevSecrets, prevHash := config, fileSnapshot, overriddenPaths, storedSecrets, configHash
+	before, _ := toJSONMap(config)
+
+	_, plaintext, err := loadConfigLocked(false)
+	if err == nil {
+		err = validateConfigLocked()
+	}
+	if err != nil {
+		config, fileSnapshot, overriddenPaths, storedSecrets, configHash = prev, prevSnapshot, prevPaths, prevSecrets, prevHash
+		refreshLogSecretsLocked()
+		configLock.Unlock()
+		return err
+	}
+	after, _ := toJSONMap(config)
+	if err := encryptPlaintextSecretsLocked(plaintext); err != nil {
+		log.Printf("Failed to save config: %v", err)
+	}
+	configLock.Unlock()
+
+	invalidateModels()
+	syncMCPServers()
+	changes := diffConfig(before, after)
+	if len(changes) == 0 {
+		log.Printf("Reloaded config file %s: no changes", configFile)
+		return nil
+	}
+	log.Printf("Reloaded config file %s:\n  %s", configFile, strings.Join(changes, "\n  "))
+	for _, key := range restartConfigKeys {
+		for _, change := range changes {
+			if strings.HasPrefix(change, key) {
+				log.Printf("Config field %s changed; restart the server to apply it.", strings.TrimSuffix(key, "."))
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// watchConfig reloads the config file whenever its content changes. Writes
+// by the server itself are recognized by their hash and skipped.
+func watchConfig(interval time.Duration) {
+	configLock.RLock()
+	last := configHash
+	configLock.RUnlock()
+	for range time.Tick(interval) {
+		data, err := os.ReadFile(configFile)
+		if err != nil {
+			continue
+		}
+		sum := sha256.Sum256(data)
+		if sum == last {
+			continue
+		}
+		// Remember a rejected file too, so it is reported only once
+		last = sum
+		configLock.RLock()
+		own := sum == configHash
+		configLock.RUnlock()
+		if own {
+			continue
+		}
+		if err := ReloadConfig(); err != nil {
+			log.Printf("Rejected config file %s, keeping the running config: %v", configFile, err)
+		}
+	}
+}
+
+// diffConfig lists the fields that differ between two configs, one line per
+// field, with secrets masked
+func diffConfig(before, after map[string]any) []string {
+	a, b := make(map[string]string), make(map[string]string)
+	flattenConfig("", before, false, a)
+	flattenConfig("", after, false, b)
+	keys := make(map[string]bool)
+	for k := range a {
+		keys[k] = true
+	}
+	for k := range b {
+		keys[k] = true
+	}
+	var changes []string
+	for k := range keys {
+		old, hadOld := a[k]
+		cur, hasCur := b[k]
+		switch {
+		case !hadOld:
+			changes = append(changes, fmt.Sprintf("%s: added %s", k, cur))
+		case !hasCur:
+			changes = append(changes, fmt.Sprintf("%s: removed", k))
+		case old != cur:
+			changes = append(changes, fmt.Sprintf("%s: %s -> %s", k, old, cur))
+		}
+	}
+	sort.Strings(changes)
+	return changes
+}
+
+// flattenConfig maps the dotted paths of the leaves of v to their JSON.
+// Elements of lists of named objects are addressed by name, e.g.
+// profiles[default].model_name.
+func flattenConfig(prefix string, v any, secret bool, out map[string]string) {
+	join := func(key string) string {
+		if prefix == "" {
+			return key
+		}
+		return prefix + "." + key
+	}
+	switch v := v.(type) {
+	case map[string]any:
+		for k, item := range v {
+			flattenConfig(join(k), item, secret || secretConfigKeys[k], out)
+		}
+		return
+	case []any:
+		named := len(v) > 0
+		for _, item := range v {
+			if m, ok := item.(map[string]any); !ok || m["name"] == nil {
+				named = false
+			}
+		}
+		if named {
+			for _, item := range v {
+				m := item.(map[string]any)
+				flattenConfig(fmt.Sprintf("%s[%v]", prefix, m["name"]), m, false, out)
+			}
+			return
+		}
+	}
+	if s, ok := v.(string); ok && secret {
+		v = maskSecret(s)
+	}
+	data, _ := json.Marshal(v)
+	out[prefix] = string(data)
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+// Secrets in the config file may be given as a literal, as a reference to
+// an environment variable or a file, or encrypted with the master key:
+//
+//	"api_key": "env:OPENAI_API_KEY"
+//	"api_key": "file:/run/secrets/openai"
+//	"api_key": "enc:v1:<base64 of nonce and AES-GCM ciphertext>"
+//
+// References are kept as they are when the config is saved. Literal values
+// are encrypted on save once a master key is configured.
+const (
+	secretEnvPrefix  = "env:"
+	secretFilePrefix = "file:"
+	secretEncPrefix  = "enc:v1:"
+)
+
+// Environment variables holding the master secret, or the path of a file
+// holding it
+const (
+	envMasterKey     = envPrefix + "MASTER_KEY"
+	envMasterKeyFile = envPrefix + "MASTER_KEY_FILE"
+)
+
+// redacted replaces secrets in log output
+const redacted = "[REDACTED]"
+
+// minRedactLen keeps very short values from redacting unrelated log text
+const minRedactLen = 6
+
+var errInvalidSecret = errors.New("invalid secret")
+
+var (
+	// secretKey is the AES-256 key derived from the master secret; nil
+	// when no master secret is configured
+	secretKey []byte
+	// storedSecrets maps the location of a secret in the config to the form
+	// it has in the file, so that unchanged references and ciphertexts are
+	// written back as they were. Guarded by configLock.
+	storedSecrets = make(map[string]storedSecret)
+	// logSecrets are the values currently redacted from the log
+	logSecrets atomic.Pointer[[]string]
+)
+
+type storedSecret struct {
+	Stored string // as written in the file
+	Value  string // as used by the server
+}
+
+// loadMasterKey derives the config encryption key from the master secret
+// in EINO_MASTER_KEY, or in the file named by path or EINO_MASTER_KEY_FILE
+func loadMasterKey(path string) error {
+	secret := os.Getenv(envMasterKey)
+	if path == "" {
+		path = os.Getenv(envMasterKeyFile)
+	}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("read master key: %w", err)
+		}
+		secret = strings.TrimSpace(string(data))
+	}
+	if secret == "" {
+		return nil
+	}
+	if len(secret) < 16 {
+		return fmt.Errorf("master key must be at least 16 characters")
+	}
+	key, err := hkdf.Key(sha256.New, []byte(secret), nil, "go-chat-server config secrets", 32)
+	if err != nil {
+		return err
+	}
+	secretKey = key
+	return nil
+}
+
+func encryptSecret(plain string) (string, error) {
+	if secretKey == nil {
+		return "", fmt.Errorf("no master key configured (%s or %s)", envMasterKey, envMasterKeyFile)
+	}
+	gcm, err := newSecretCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(plain), nil)
+	return secretEncPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func decryptSecret(enc string) (string, error) {
+	if secretKey == nil {
+		return "", fmt.Errorf("encrypted secret but no master key configured (%s or %s)", envMasterKey, envMasterKeyFile)
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(enc, secretEncPrefix))
+	if err != nil {
+		return "", fmt.Errorf("malformed encrypted secret")
+	}
+	gcm, err := newSecretCipher()
+	if err != nil {
+		return "", err
+	}
+	if len(data) < gcm.NonceSize() {
+		return "", fmt.Errorf("malformed encrypted secret")
+	}
+	plain, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("cannot decrypt secret: wrong master key?")
+	}
+	return string(plain), nil
+}
+
+func newSecretCipher() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(secretKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// isSecretRef reports whether a value is stored in another form than the
+// one the server uses
+func isSecretRef(v string) bool {
+	return strings.HasPrefix(v, secretEnvPrefix) || strings.HasPrefix(v, secretFilePrefix) || strings.HasPrefix(v, secretEncPrefix)
+}
+
+// resolveSecret returns the value a reference or ciphertext stands for;
+// literal values are returned as they are
+func resolveSecret(v string) (string, error) {
+	switch {
+	case strings.HasPrefix(v, secretEncPrefix):
+		return decryptSecret(v)
+	case strings.HasPrefix(v, secretEnvPrefix):
+		name := strings.TrimPrefix(v, secretEnvPrefix)
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return value, nil
+	case strings.HasPrefix(v, secretFilePrefix):
+		data, err := os.ReadFile(strings.TrimPrefix(v, secretFilePrefix))
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+	return v, nil
+}
+
+// secretField is a secret of the config together with its location
+type secretField struct {
+	loc string
+	get func() string
+	set func(string)
+}
+
+func pointerField(loc string, p *string) secretField {
+	return secretField{loc, func() string { return *p }, func(v string) { *p = v }}
+}
+
+func secretFieldsLocked() []secretField {
+	fields := []secretField{
+		pointerField("api_key", &config.APIKey),
+		pointerField("auth/session_secret", &config.Auth.SessionSecret),
+	}
+	for i := range config.Profiles {
+		fields = append(fields, pointerField(profileSecretLoc(config.Profiles[i].Name), &config.Profiles[i].APIKey))
+	}
+	for i := range config.Auth.Tokens {
+		fields = append(fields, pointerField("auth/tokens/"+config.Auth.Tokens[i].Name+"/token", &config.Auth.Tokens[i].Token))
+	}
+	// MCP servers are often given API keys through their environment
+	for _, s := range config.Tools.MCPServers {
+		for _, k := range slices.Sorted(maps.Keys(s.Env)) {
+			env := s.Env
+			fields = append(fields, secretField{mcpEnvSecretLoc(s.Name, k), func() string { return env[k] }, func(v string) { env[k] = v }})
+		}
+	}
+	return fields
+}
+
+func profileSecretLoc(name string) string {
+	return "profiles/" + name + "/api_key"
+}
+
+func mcpEnvSecretLoc(server, name string) string {
+	return "tools/mcp_servers/" + server + "/env/" + name
+}
+
+// resolveSecretsLocked replaces references and ciphertexts in the config
+// just read from the file by their values. A secret that cannot be resolved
+// is left empty, and logged, but written back unchanged. It returns the
+// number of secrets the file holds in plaintext.
+func resolveSecretsLocked() (int, error) {
+	storedSecrets = make(map[string]storedSecret)
+	plaintext := 0
+	var errs []error
+	for _, f := range secretFieldsLocked() {
+		stored := f.get()
+		if !isSecretRef(stored) {
+			if stored != "" {
+				plaintext++
+			}
+			continue
+		}
+		value, err := resolveSecret(stored)
+		if err != nil {
+			log.Printf("Failed to resolve secret %s: %v", f.loc, err)
+			errs = append(errs, fmt.Errorf("%w %s: %v", errInvalidSecret, f.loc, err))
+		}
+		f.set(value)
+		storedSecrets[f.loc] = storedSecret{Stored: stored, Value: value}
+	}
+	if plaintext > 0 && secretKey == nil {
+		log.Printf("Config file holds %d plaintext secrets; set %s to encrypt them, or use env: or file: references.", plaintext, envMasterKey)
+	}
+	return plaintext, errors.Join(errs...)
+}
+
+// resolveOverrideSecretsLocked resolves references given through overrides,
+// e.g. EINO_API_KEY=file:/run/secrets/openai
+func resolveOverrideSecretsLocked() {
+	for _, f := range secretFieldsLocked() {
+		if !isSecretRef(f.get()) {
+			continue
+		}
+		value, err := resolveSecret(f.get())
+		if err != nil {
+			log.Printf("Failed to resolve secret %s: %v", f.loc, err)
+		}
+		f.set(value)
+	}
+}
+
+// setSecretLocked stores a secret given through the API; literal values are
+// encrypted on save. References are rejected: resolving them would let any
+// client of the API read the server's files and environment and send them to
+// a base URL of its choosing. They can be set in the config file or through
+// EINO_* overrides only.
+func setSecretLocked(loc string, field *string, input string) error {
+	if isSecretRef(input) {
+		return fmt.Errorf("%w: references such as env: and file: can only be set in the config file", errInvalidSecret)
+	}
+	delete(storedSecrets, loc)
+	*field = input
+	return nil
+}
+
+// encodeSecretsLocked turns the secrets of a config about to be saved into
+// their stored form
+func encodeSecretsLocked(out map[string]any) {
+	encode := func(loc string, m map[string]any, key string) {
+		v, _ := m[key].(string)
+		if s, ok := storedSecrets[loc]; ok && s.Value == v {
+			m[key] = s.Stored
+			return
+		}
+		if v == "" || secretKey == nil {
+			return
+		}
+		enc, err := encryptSecret(v)
+		if err != nil {
+			log.Printf("Failed to encrypt secret %s: %v", loc, err)
+			return
+		}
+		storedSecrets[loc] = storedSecret{Stored: enc, Value: v}
+		m[key] = enc
+	}
+
+	encode("api_key", out, "api_key")
+	profiles, _ := out["profiles"].([]any)
+	for _, item := range profiles {
+		if p, ok := item.(map[string]any); ok {
+			name, _ := p["name"].(string)
+			encode(profileSecretLoc(name), p, "api_key")
+		}
+	}
+	if auth, ok := out["auth"].(map[string]any); ok {
+		encode("auth/session_secret", auth, "session_secret")
+		tokens, _ := auth["tokens"].([]any)
+		for _, item := range tokens {
+			if t, ok := item.(map[string]any); ok {
+				name, _ := t["name"].(string)
+				encode("auth/tokens/"+name+"/token", t, "token")
+			}
+		}
+	}
+	if tools, ok := out["tools"].(map[string]any); ok {
+		servers, _ := tools["mcp_servers"].([]any)
+		for _, item := range servers {
+			if s, ok := item.(map[string]any); ok {
+				name, _ := s["name"].(string)
+				env, _ := s["env"].(map[string]any)
+				for k := range env {
+					encode(mcpEnvSecretLoc(name, k), env, k)
+				}
+			}
+		}
+	}
+}
+
+// refreshLogSecretsLocked updates the values redacted from the log
+func refreshLogSecretsLocked() {
+	var values []string
+	for _, f := range secretFieldsLocked() {
+		if v := f.get(); len(v) >= minRedactLen {
+			values = append(values, v)
+		}
+	}
+	logSecrets.Store(&values)
+}
+
+// redactingWriter hides the configured secrets from everything written to it
+type redactingWriter struct{ w io.Writer }
+
+func (rw redactingWriter) Write(p []byte) (int, error) {
+	out := p
+	if values := logSecrets.Load(); values != nil {
+		for _, v := range *values {
+			out = bytes.ReplaceAll(out, []byte(v), []byte(redacted))
+		}
+	}
+	if _, err := rw.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// maskSecret shows just enough of a secret to tell two apart
+func maskSecret(v string) string {
+	if v == "" {
+		return ""
+	}
+	if len(v) < 12 {
+		return "****"
+	}
+	return "****" + v[len(v)-4:]
+}
+
+// warnInsecureSecret logs secrets sent in clear text over the network
+func warnInsecureSecret(r *http.Request, what string) {
+	if r.TLS != nil {
+		return
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err == nil {
+		if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+			return
+		}
+	}
+	log.Printf("%s was sent over plain HTTP from %s; enable TLS or use an env: or file: reference instead.", what, r.RemoteAddr)
+}
+
+// printEncryptedSecret reads a secret from stdin and prints its encrypted
+// form for config.json
+func printEncryptedSecret() {
+	fmt.Fprint(os.Stderr, "Secret: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	secret := strings.TrimRight(line, "\r\n")
+	if secret == "" {
+		log.Fatalf("No secret given: %v", err)
+	}
+	enc, err := encryptSecret(secret)
+	if err != nil {
+		log.Fatalf("Failed to encrypt secret: %v", err)
+	}
+	fmt.Println(enc)
+}
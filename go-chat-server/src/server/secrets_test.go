@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// useConfig swaps in cfg, saved to a temporary config file, for the rest of
+// the test
+func useConfig(t *testing.T, cfg Config) {
+	t.Helper()
+	configLock.Lock()
+	saved, savedFile, savedSecrets := config, configFile, storedSecrets
+	savedSnapshot, savedHash := fileSnapshot, configHash
+	config, configFile, storedSecrets = cfg, filepath.Join(t.TempDir(), "config.json"), make(map[string]storedSecret)
+	configLock.Unlock()
+	t.Cleanup(func() {
+		configLock.Lock()
+		config, configFile, storedSecrets = saved, savedFile, savedSecrets
+		fileSnapshot, configHash = savedSnapshot, savedHash
+		refreshLogSecretsLocked()
+		configLock.Unlock()
+	})
+}
+
+func testProfileConfig() Config {
+	return Config{
+		DefaultProfile: "main",
+		Profiles:       []Profile{{Name: "main", BaseURL: "https://api.example.com/v1", APIKey: "sk-stored", ModelName: "m"}},
+	}
+}
+
+func TestAPIRejectsSecretReferences(t *testing.T) {
+	t.Setenv("SECRETS_TEST_VALUE", "leaked")
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		body    string
+	}{
+		{"update-config env", updateConfigHandler, `{"api_key":"env:SECRETS_TEST_VALUE"}`},
+		{"update-config file", updateConfigHandler, `{"base_url":"http://attacker.invalid/v1","api_key":"file:/etc/hostname"}`},
+		{"update-config enc", updateConfigHandler, `{"api_key":"enc:v1:AAAA"}`},
+		{"create profile env", saveProfileHandler, `{"name":"evil","base_url":"http://attacker.invalid/v1","api_key":"env:SECRETS_TEST_VALUE","model_name":"m"}`},
+		{"create profile file", saveProfileHandler, `{"name":"evil","base_url":"http://attacker.invalid/v1","api_key":"file:/etc/hostname","model_name":"m"}`},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			useConfig(t, testProfileConfig())
+			rec := httptest.NewRecorder()
+			tc.handler(rec, httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(tc.body)))
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want 400: %s", rec.Code, rec.Body)
+			}
+			p, err := GetProfile("")
+			if err != nil || p.APIKey != "sk-stored" || p.BaseURL != "https://api.example.com/v1" {
+				t.Errorf("default profile changed: %+v", p)
+			}
+			if HasProfile("evil") {
+				t.Error("profile with a reference was saved")
+			}
+		})
+	}
+}
+
+// useMasterKey derives the config encryption key from secret for the rest of
+// the test; an empty secret removes the key
+func useMasterKey(t *testing.T, secret string) {
+	t.Helper()
+	saved := secretKey
+	t.Cleanup(func() { secretKey = saved })
+	secretKey = nil
+	t.Setenv(envMasterKey, secret)
+	t.Setenv(envMasterKeyFile, "")
+	if err := loadMasterKey(""); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEncryptSecret(t *testing.T) {
+	useMasterKey(t, "first master key")
+	enc, err := encryptSecret("sk-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(enc, secretEncPrefix) || strings.Contains(enc, "sk-secret") {
+		t.Fatalf("encryptSecret() = %q", enc)
+	}
+	if again, _ := encryptSecret("sk-secret"); again == enc {
+		t.Error("encrypting twice gave the same ciphertext")
+	}
+	if plain, err := resolveSecret(enc); err != nil || plain != "sk-secret" {
+		t.Errorf("round trip = %q, %v", plain, err)
+	}
+
+	data, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(enc, secretEncPrefix))
+	data[len(data)-1] ^= 1
+	tampered := secretEncPrefix + base64.StdEncoding.EncodeToString(data)
+	tests := []struct {
+		name string
+		enc  string
+		err  string
+	}{
+		{"tampered ciphertext", tampered, "cannot decrypt"},
+		{"not base64", secretEncPrefix + "!!!", "malformed"},
+		{"shorter than a nonce", secretEncPrefix + "AAAA", "malformed"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := decryptSecret(tc.enc); err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("error = %v, want it to contain %q", err, tc.err)
+			}
+		})
+	}
+
+	t.Run("wrong master key", func(t *testing.T) {
+		useMasterKey(t, "second master key")
+		if _, err := decryptSecret(enc); err == nil || !strings.Contains(err.Error(), "wrong master key") {
+			t.Errorf("error = %v", err)
+		}
+	})
+	t.Run("no master key", func(t *testing.T) {
+		useMasterKey(t, "")
+		if _, err := encryptSecret("sk-secret"); err == nil {
+			t.Error("encrypted without a master key")
+		}
+		if _, err := decryptSecret(enc); err == nil {
+			t.Error("decrypted without a master key")
+		}
+	})
+}
+
+func TestResolveSecret(t *testing.T) {
+	t.Setenv("SECRETS_TEST_VALUE", "from-env")
+	path := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(path, []byte("from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		in   string
+		want string
+		err  string
+	}{
+		{in: "sk-literal", want: "sk-literal"},
+		{in: "", want: ""},
+		{in: "env:SECRETS_TEST_VALUE", want: "from-env"},
+		{in: "env:SECRETS_TEST_MISSING", err: "SECRETS_TEST_MISSING is not set"},
+		{in: "file:" + path, want: "from-file"},
+		{in: "file:" + path + ".missing", err: "no such file"},
+	}
+	for _, tc := range tests {
+		got, err := resolveSecret(tc.in)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("resolveSecret(%q) error = %v, want it to contain %q", tc.in, err, tc.err)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("resolveSecret(%q) = %q, %v, want %q", tc.in, got, err, tc.want)
+		}
+	}
+}
+
+func TestMCPEnvSecrets(t *testing.T) {
+	useMasterKey(t, "mcp test master key")
+	useConfig(t, Config{})
+	t.Setenv("SECRETS_TEST_VALUE", "mcp-from-env")
+	file := `{"tools": {"mcp_servers": [{"name": "docs", "command": "docs-mcp", "env": {"TOKEN": "mcp-literal-token", "REF": "env:SECRETS_TEST_VALUE"}}]}}`
+	if err := os.WriteFile(configFile, []byte(file), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	configLock.Lock()
+	defer configLock.Unlock()
+	_, plaintext, err := loadConfigLocked(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plaintext != 1 {
+		t.Errorf("plaintext = %d, want the literal TOKEN counted", plaintext)
+	}
+	env := config.Tools.MCPServers[0].Env
+	if env["TOKEN"] != "mcp-literal-token" || env["REF"] != "mcp-from-env" {
+		t.Errorf("env = %v, want the references resolved", env)
+	}
+
+	var logged bytes.Buffer
+	redactingWriter{&logged}.Write([]byte("token mcp-literal-token and mcp-from-env"))
+	if strings.Contains(logged.String(), "mcp-") {
+		t.Errorf("log = %q, want the env values redacted", logged.String())
+	}
+
+	if err := SaveConfig(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved struct {
+		Tools ToolsConfig `json:"tools"`
+	}
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+	savedEnv := saved.Tools.MCPServers[0].Env
+	if !strings.HasPrefix(savedEnv["TOKEN"], secretEncPrefix) {
+		t.Errorf("TOKEN saved as %q, want it encrypted", savedEnv["TOKEN"])
+	}
+	if savedEnv["REF"] != "env:SECRETS_TEST_VALUE" {
+		t.Errorf("REF saved as %q, want the reference kept", savedEnv["REF"])
+	}
+}
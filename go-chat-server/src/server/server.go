@@ -2,38 +2,46 @@ package main
 
 import (
 	"bufio"
-	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"strings"
-	"sync"
+
+	"eino-rag/fallback"
+	"eino-rag/reasoning"
 
 	"github.com/cloudwego/eino/components/model"
 	"github.com/cloudwego/eino/schema"
 )
 
-var (
-	mu          sync.Mutex
-	chatHistory = make([]*schema.Message, 0) // Initialize as an empty slice
-	cm          model.ToolCallingChatModel
-)
+var sessions *SessionStore
 
-func init() {
-	if err := LoadConfig(); err != nil {
-		log.Printf("Failed to load config: %v, using defaults", err)
-	}
+// initSessions opens the history store once the config is loaded
+func initSessions() {
 	// Don't create chat model here, create it on demand
+
+	// Reload conversations saved before the last restart
+	store, err := openHistoryStore()
+	if err != nil {
+		log.Printf("Failed to open history store: %v, keeping history in memory only", err)
+		store = NewMemoryHistoryStore()
+	}
+	sessions, err = NewSessionStore(store)
+	if err != nil {
+		log.Fatalf("Failed to restore sessions: %v", err)
+	}
 }
 
 func clearHistoryHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodPost {
-		mu.Lock()
-		defer mu.Unlock()
-		chatHistory = make([]*schema.Message, 0) // Reset the history
-		log.Println("Chat history cleared.")
+		// Only the caller's own session is cleared
+		if s, ok := lookupSession(r); ok {
+			s.Clear()
+			log.Printf("Chat history of session %s cleared.", s.ID)
+		}
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("History cleared"))
 		return
@@ -44,57 +52,160 @@ func chatHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodPost {
 		var req struct {
 			Message string `json:"message"`
+			// Profile overrides the session's profile for this message only
+			Profile string `json:"profile"`
+			GenerationParams
 		}
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			http.Error(w, "Invalid input", http.StatusBadRequest)
 			return
 		}
-
-		// Get the latest system prompt
-		currentPrompt := GetSystemPrompt()
+		if err := req.GenerationParams.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 
 		// Check if we need to create or recreate the chat model
-		mu.Lock()
-		if cm == nil {
-			ctx := context.Background()
-			newCm, err := createOpenAIChatModel(ctx)
-			if err != nil {
-				mu.Unlock()
-				http.Error(w, fmt.Sprintf("Chat model not configured: %v. Please set a valid API key in the settings.", err), http.StatusBadRequest)
-				return
-			}
-			cm = newCm
+		profile := req.Profile
+		if s, ok := lookupSession(r); ok && profile == "" {
+			profile = s.ProfileName()
 		}
-		mu.Unlock()
-
-		// Process the chat message using the current system prompt
-		messages := buildMessages(chatHistory, req.Message, currentPrompt)
-		streamResult, err := stream(r.Context(), cm, messages)
+		cm, err := getChatModel(profile)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Failed to start stream: %v", err), http.StatusInternalServerError)
+			chatModelError(w, err)
 			return
 		}
-		defer streamResult.Close()
 
-		// Switch to streaming response
-		w.Header().Set("Content-Type", "text/event-stream")
-		w.Header().Set("Cache-Control", "no-cache")
-		w.Header().Set("Connection", "keep-alive")
+		session := sessionForRequest(w, r)
+
+		// The new turn continues the active branch of the conversation
+		streamReply(w, r, cm, session, replyTarget{
+			ParentID: session.ActiveLeafID(),
+			Question: req.Message,
+		}, req.GenerationParams)
+		return
+	}
+
+	http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+}
+
+// replyTarget says where in a session's tree a generated reply goes
+type replyTarget struct {
+	// ParentID is the message the question follows; empty for the first turn
+	ParentID string
+	Question string
+	// UserID is set when the question is already stored and only a new
+	// reply to it is generated
+	UserID string
+}
+
+// save stores the reply, and the question if it is new, in the session
+func (t replyTarget) save(session *Session, reply *schema.Message) {
+	if t.UserID != "" {
+		session.AppendReply(t.UserID, reply)
+		return
+	}
+	session.AppendTurn(t.ParentID, t.Question, reply)
+}
+
+// streamReply generates an answer to target.Question from the branch ending
+// at target.ParentID and streams it to the client using the /send protocol.
+// params are the generation parameters given with the request.
+func streamReply(w http.ResponseWriter, r *http.Request, llm *profileModel, session *Session, target replyTarget, params GenerationParams) {
+	// Keep the history inside the token budget, folding older turns into a summary
+	history, memState, err := newMemoryManager(llm).Window(r.Context(), session.PathTo(target.ParentID), session.MemoryState())
+	if err != nil {
+		log.Printf("Failed to summarize history of session %s: %v", session.ID, err)
+	}
+	session.SetMemoryState(memState)
+
+	// Process the chat message using the current system prompt
+	messages := buildMessages(history, target.Question, GetSystemPrompt())
 
-		flusher, ok := w.(http.Flusher)
-		if !ok {
-			http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+	// Register the generation so the client can stop it through /cancel/{id}
+	genID, ctx, finish := generations.Start(r.Context(), session.ID)
+	defer finish()
+
+	// Switch to streaming response
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	ew := newEventWriter(w, flusher)
+	reply := &schema.Message{
+		Role:         schema.Assistant,
+		ResponseMeta: &schema.ResponseMeta{},
+		Extra:        map[string]any{messageIDKey: newSessionID(), profileKey: llm.Profile.Name},
+	}
+	// The first event tells the client which generation it can cancel
+	ew.Send(eventStart, startEvent{
+		Version:      sseProtocolVersion,
+		SessionID:    session.ID,
+		GenerationID: genID,
+		MessageID:    messageID(reply),
+		Model:        llm.Profile.ModelName,
+		Profile:      llm.Profile.Name,
+	})
+
+	// Offer the enabled tools; the model may call them for a few rounds
+	// before it has to answer
+	var bound model.ToolCallingChatModel = llm
+	toolInfos := enabledTools()
+	if len(toolInfos) > 0 {
+		withTools, err := llm.WithTools(toolInfos)
+		if err != nil {
+			log.Printf("Failed to bind tools to %s: %v", llm.Profile.Name, err)
+		} else {
+			bound = withTools
+		}
+	}
+	toolsCfg := GetToolsConfig()
+	opts := generationOptions(llm.Profile, params)
+
+	// Thinking is streamed on its own event and kept out of the history by default
+	var thinking strings.Builder
+	var calls []toolCallRecord
+	for round := 0; ; round++ {
+		m := bound
+		if round >= toolsCfg.maxRounds() {
+			m = llm
+		}
+		streamResult, err := stream(ctx, m, messages, opts...)
+		if err != nil {
+			log.Printf("Failed to start stream: %v", err)
+			ew.Error(errCodeUpstream, fmt.Sprintf("Failed to start stream: %v", err))
 			return
 		}
 
-		bw := bufio.NewWriter(w)
-		var assistantMsg string
+		var splitter reasoning.Splitter
+		var content strings.Builder
+		var toolChunks []*schema.Message
+		roundMeta := &schema.ResponseMeta{}
+		emit := func(d reasoning.Delta) {
+			if d.Reasoning != "" {
+				thinking.WriteString(d.Reasoning)
+				ew.Send(eventReasoning, textEvent{Text: d.Reasoning})
+			}
+			if d.Content != "" {
+				content.WriteString(d.Content)
+				reply.Content += d.Content
+				ew.Send(eventDelta, textEvent{Text: d.Content})
+			}
+		}
+
 		for {
 			// 在接收数据前，先检查上下文是否已被取消
 			select {
-			case <-r.Context().Done():
-				log.Println("Request context cancelled, stopping stream.")
-				return // 直接返回，允许服务器关闭
+			case <-ctx.Done():
+				streamResult.Close()
+				stopGeneration(ew, r, session, genID, target, reply)
+				return
 			default:
 				// 上下文未取消，继续执行 Recv()
 			}
@@ -104,28 +215,87 @@ func chatHandler(w http.ResponseWriter, r *http.Request) {
 				if err == io.EOF {
 					break // 流正常结束
 				}
+				streamResult.Close()
 				// 再次检查错误是否由上下文取消引起
-				if r.Context().Err() != nil {
-					log.Println("Stream stopped due to context cancellation.")
-					return // 直接返回
+				if ctx.Err() != nil {
+					stopGeneration(ew, r, session, genID, target, reply)
+					return
 				}
 				// 其他类型的错误
 				log.Printf("Stream recv failed: %v", err)
-				writeSSE(bw, flusher, "error", fmt.Sprintf("recv failed: %v", err))
+				ew.Error(errCodeUpstream, fmt.Sprintf("recv failed: %v", err))
 				return
 			}
-			chunk := message.Content
-			assistantMsg += chunk
-			// SSE data lines per newline
-			writeSSE(bw, flusher, "", chunk)
+			mergeResponseMeta(roundMeta, message.ResponseMeta)
+			if provider, ok := message.Extra[fallback.ProviderKey].(string); ok {
+				reply.Extra[fallback.ProviderKey] = provider
+			}
+			if len(message.ToolCalls) > 0 {
+				toolChunks = append(toolChunks, &schema.Message{Role: schema.Assistant, ToolCalls: message.ToolCalls})
+			}
+			emit(splitter.Push(message))
+		}
+		streamResult.Close()
+		emit(splitter.Flush())
+		// Usage adds up over the rounds
+		reply.ResponseMeta.FinishReason = roundMeta.FinishReason
+		addUsage(reply.ResponseMeta, roundMeta.Usage)
+		if len(toolChunks) == 0 {
+			break
 		}
 
-		// Append to chat history after completion
-		chatHistory = AppendHistory(chatHistory, req.Message, assistantMsg)
-		return
+		// Run the requested tools and let the model continue with their results
+		merged, err := schema.ConcatMessages(toolChunks)
+		if err != nil {
+			log.Printf("Failed to merge tool calls: %v", err)
+			ew.Error(errCodeUpstream, fmt.Sprintf("invalid tool calls: %v", err))
+			return
+		}
+		messages = append(messages, &schema.Message{Role: schema.Assistant, Content: content.String(), ToolCalls: merged.ToolCalls})
+		for _, call := range merged.ToolCalls {
+			ew.Send(eventToolCall, toolCallEvent{ID: call.ID, Name: call.Function.Name, Arguments: call.Function.Arguments})
+			rec := runTool(ctx, call)
+			if ctx.Err() != nil {
+				stopGeneration(ew, r, session, genID, target, reply)
+				return
+			}
+			log.Printf("Tool %s ran in %d ms for session %s.", rec.Name, rec.DurationMS, session.ID)
+			ew.Send(eventToolResult, rec)
+			calls = append(calls, rec)
+			reply.Extra[toolCallsKey] = calls
+			messages = append(messages, schema.ToolMessage(rec.modelContent(), call.ID, schema.WithToolName(call.Function.Name)))
+		}
+	}
+	if KeepReasoning() {
+		reply.ReasoningContent = thinking.String()
 	}
 
-	http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	// Append to the session's history after completion
+	target.save(session, reply)
+	ew.Usage(reply.ResponseMeta)
+	provider, _ := reply.Extra[fallback.ProviderKey].(string)
+	ew.Send(eventDone, doneEvent{
+		MessageID:    messageID(reply),
+		FinishReason: openAIFinishReason(reply.ResponseMeta),
+		Provider:     provider,
+	})
+}
+
+// stopGeneration keeps the partial answer of a cancelled generation in the
+// session's history, marked as truncated. If the client is still connected
+// (it used /cancel rather than dropping the connection) it is told so.
+func stopGeneration(ew *eventWriter, r *http.Request, session *Session, genID string, target replyTarget, partial *schema.Message) {
+	log.Printf("Generation %s stopped, keeping %d bytes of partial answer.", genID, len(partial.Content))
+	markTruncated(partial)
+	target.save(session, partial)
+	if r.Context().Err() == nil {
+		ew.Usage(partial.ResponseMeta)
+		ew.Send(eventDone, doneEvent{
+			MessageID:    messageID(partial),
+			FinishReason: finishReasonCancelled,
+			Truncated:    true,
+		})
+	}
 }
 
 // writeSSE writes an SSE message, splitting data by newlines into multiple data: lines
@@ -159,10 +329,8 @@ func updatePromptHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		// Invalidate the current chat model so it gets recreated with the new prompt
-		mu.Lock()
-		cm = nil
-		mu.Unlock()
+		// Invalidate the cached chat models so they get recreated with the new prompt
+		invalidateModels()
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]any{"status": "ok"})
@@ -178,15 +346,43 @@ func updateConfigHandler(w http.ResponseWriter, r *http.Request) {
 			APIKey    string `json:"api_key"`
 			BaseURL   string `json:"base_url"`
 			ModelName string `json:"model_name"`
+			// Generation replaces the default generation parameters when present
+			Generation *GenerationParams `json:"generation"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			http.Error(w, "Invalid input", http.StatusBadRequest)
 			return
 		}
+		// Check the key before anything is changed
+		if isSecretRef(req.APIKey) {
+			http.Error(w, fmt.Sprintf("%v: references such as env: and file: can only be set in the config file", errInvalidSecret), http.StatusBadRequest)
+			return
+		}
+		if req.BaseURL != "" && req.APIKey == "" {
+			if p, err := GetProfile(""); err == nil && p.APIKey != "" && !sameBaseURL(req.BaseURL, p.BaseURL) {
+				http.Error(w, errAPIKeyRequired.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if req.Generation != nil {
+			if err := req.Generation.validate(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := UpdateGenerationDefaults(*req.Generation); err != nil {
+				http.Error(w, "Failed to update generation parameters", http.StatusInternalServerError)
+				return
+			}
+		}
 
 		// Update OpenAI configuration
 		if req.APIKey != "" {
+			warnInsecureSecret(r, "API key")
 			if err := UpdateAPIKey(req.APIKey); err != nil {
+				if errors.Is(err, errInvalidSecret) {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
 				http.Error(w, "Failed to update API key", http.StatusInternalServerError)
 				return
 			}
@@ -204,14 +400,16 @@ func updateConfigHandler(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
-		// Recreate the chat model with new config
-		ctx := context.Background()
-		newCm, err := createOpenAIChatModel(ctx)
+		// Recreate the chat model of the default profile with the new config
+		p, err := GetProfile("")
+		if err == nil {
+			invalidateModels(p.Name)
+			_, err = getChatModel(p.Name)
+		}
 		if err != nil {
 			http.Error(w, fmt.Sprintf("Failed to create chat model: %v", err), http.StatusInternalServerError)
 			return
 		}
-		cm = newCm
 
 		response := map[string]string{"status": "Configuration updated successfully"}
 		w.Header().Set("Content-Type", "application/json")
@@ -224,14 +422,15 @@ func updateConfigHandler(w http.ResponseWriter, r *http.Request) {
 
 func getConfigHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
-		configLock.RLock()
-		defer configLock.RUnlock()
-
-		response := map[string]string{
-			"system_prompt": config.SystemPrompt,
+		// The provider settings are those of the default profile
+		p, _ := GetProfile("")
+		response := map[string]any{
+			"system_prompt": GetSystemPrompt(),
 			// "api_key" is intentionally omitted for security
-			"base_url":   config.BaseURL,
-			"model_name": config.ModelName,
+			"base_url":        p.BaseURL,
+			"model_name":      p.ModelName,
+			"default_profile": p.Name,
+			"generation":      GetGenerationDefaults(),
 		}
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(response)
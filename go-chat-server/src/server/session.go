@@ -0,0 +1,420 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"eino-rag/memory"
+
+	"github.com/cloudwego/eino/schema"
+	"github.com/gorilla/mux"
+)
+
+// messageIDKey holds the ID of a stored message in Message.Extra
+const messageIDKey = "id"
+
+const (
+	sessionCookieName = "session_id"
+	sessionHeaderName = "X-Session-ID"
+	sessionTitleLen   = 30
+)
+
+// SessionMeta is the persisted metadata of a session
+type SessionMeta struct {
+	ID        string    `json:"id"`
+	Title     string    `json:"title"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// Memory is the rolling summary of turns that fell out of the history window
+	Memory memory.State `json:"memory"`
+	// ActiveLeaf is the last message of the branch the conversation continues from
+	ActiveLeaf string `json:"active_leaf,omitempty"`
+	// Owner is the user who created the session; empty while auth is disabled
+	Owner string `json:"owner,omitempty"`
+	// Profile is the model profile the session talks to; empty for the default
+	Profile string `json:"profile,omitempty"`
+}
+
+// Session holds the conversation of a single client. Its messages form a
+// tree (see tree.go); the history is the path to the active leaf.
+type Session struct {
+	SessionMeta
+
+	mu       sync.Mutex
+	nodes    []*schema.Message
+	byID     map[string]*schema.Message
+	children map[string][]string
+	store    HistoryStore
+}
+
+func newSession(meta SessionMeta, store HistoryStore) *Session {
+	return &Session{
+		SessionMeta: meta,
+		nodes:       make([]*schema.Message, 0),
+		byID:        make(map[string]*schema.Message),
+		children:    make(map[string][]string),
+		store:       store,
+	}
+}
+
+// History returns a copy of the active branch of the conversation
+func (s *Session) History() []*schema.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pathLocked(s.ActiveLeaf)
+}
+
+// Clear removes all messages from the session
+func (s *Session) Clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nodes = make([]*schema.Message, 0)
+	s.byID = make(map[string]*schema.Message)
+	s.children = make(map[string][]string)
+	s.ActiveLeaf = ""
+	s.Memory = memory.State{}
+	s.UpdatedAt = time.Now()
+
+	if err := s.store.ClearMessages(s.ID); err != nil {
+		log.Printf("Failed to clear stored messages of session %s: %v", s.ID, err)
+	}
+	s.saveMetaLocked()
+}
+
+// MemoryState returns the summarization state of the session
+func (s *Session) MemoryState() memory.State {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Memory
+}
+
+// SetMemoryState stores a new summarization state, persisting it if it changed
+func (s *Session) SetMemoryState(state memory.State) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Memory == state {
+		return
+	}
+	s.Memory = state
+	s.saveMetaLocked()
+}
+
+func (s *Session) saveMetaLocked() {
+	if err := s.store.SaveMeta(s.SessionMeta); err != nil {
+		log.Printf("Failed to persist session %s: %v", s.ID, err)
+	}
+}
+
+// ProfileName returns the session's model profile, falling back to the
+// default one if it was deleted
+func (s *Session) ProfileName() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Profile != "" && !HasProfile(s.Profile) {
+		log.Printf("Profile %s of session %s no longer exists, using the default profile.", s.Profile, s.ID)
+		return ""
+	}
+	return s.Profile
+}
+
+// SetProfile switches the session to another model profile
+func (s *Session) SetProfile(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Profile = name
+	s.saveMetaLocked()
+}
+
+// SessionInfo is the summary of a session returned by the /sessions endpoints
+type SessionInfo struct {
+	ID           string    `json:"id"`
+	Title        string    `json:"title"`
+	Profile      string    `json:"profile"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	MessageCount int       `json:"message_count"`
+}
+
+// Info returns a snapshot of the session's metadata
+func (s *Session) Info() SessionInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return SessionInfo{
+		ID:           s.ID,
+		Title:        s.Title,
+		Profile:      s.Profile,
+		CreatedAt:    s.CreatedAt,
+		UpdatedAt:    s.UpdatedAt,
+		MessageCount: len(s.pathLocked(s.ActiveLeaf)),
+	}
+}
+
+// SessionStore keeps all live sessions keyed by ID, backed by a HistoryStore
+type SessionStore struct {
+	mu       sync.RWMutex
+	sessions map[string]*Session
+	store    HistoryStore
+}
+
+// NewSessionStore creates a session store and reloads every session
+// previously saved in the history store
+func NewSessionStore(store HistoryStore) (*SessionStore, error) {
+	st := &SessionStore{
+		sessions: make(map[string]*Session),
+		store:    store,
+	}
+	stored, err := store.Load()
+	if err != nil {
+		return nil, fmt.Errorf("load sessions: %w", err)
+	}
+	for _, ss := range stored {
+		s := newSession(ss.Meta, store)
+		s.loadNodes(ss.Messages)
+		st.sessions[ss.Meta.ID] = s
+	}
+	return st, nil
+}
+
+// Create issues a new session with a random ID
+func (st *SessionStore) Create(owner string) *Session {
+	now := time.Now()
+	s := newSession(SessionMeta{
+		ID:        newSessionID(),
+		CreatedAt: now,
+		UpdatedAt: now,
+		Owner:     owner,
+	}, st.store)
+	st.mu.Lock()
+	st.sessions[s.ID] = s
+	st.mu.Unlock()
+
+	s.mu.Lock()
+	s.saveMetaLocked()
+	s.mu.Unlock()
+	return s
+}
+
+// Get returns the session with the given ID
+func (st *SessionStore) Get(id string) (*Session, bool) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	s, ok := st.sessions[id]
+	return s, ok
+}
+
+// Delete removes a session, reporting whether it existed
+func (st *SessionStore) Delete(id string) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if _, ok := st.sessions[id]; !ok {
+		return false
+	}
+	delete(st.sessions, id)
+	if err := st.store.Delete(id); err != nil {
+		log.Printf("Failed to delete stored session %s: %v", id, err)
+	}
+	return true
+}
+
+// List returns the sessions of an owner, most recently updated first
+func (st *SessionStore) List(owner string) []SessionInfo {
+	st.mu.RLock()
+	infos := make([]SessionInfo, 0, len(st.sessions))
+	for _, s := range st.sessions {
+		if s.Owner == owner {
+			infos = append(infos, s.Info())
+		}
+	}
+	st.mu.RUnlock()
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].UpdatedAt.After(infos[j].UpdatedAt)
+	})
+	return infos
+}
+
+// messageID returns the ID of a stored message
+func messageID(msg *schema.Message) string {
+	id, _ := msg.Extra[messageIDKey].(string)
+	return id
+}
+
+func newSessionID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func makeTitle(text string) string {
+	runes := []rune(text)
+	if len(runes) > sessionTitleLen {
+		return string(runes[:sessionTitleLen]) + "…"
+	}
+	return string(runes)
+}
+
+// requestSessionID extracts the session ID from the header or the cookie
+func requestSessionID(r *http.Request) string {
+	if id := r.Header.Get(sessionHeaderName); id != "" {
+		return id
+	}
+	if c, err := r.Cookie(sessionCookieName); err == nil {
+		return c.Value
+	}
+	return ""
+}
+
+// canAccess reports whether the caller may use a session: its owner and
+// admins can. While auth is disabled the session ID is the only credential,
+// so anyone who knows it can.
+func canAccess(r *http.Request, s *Session) bool {
+	p := principalFrom(r.Context())
+	return p == nil || p.IsAdmin() || s.Owner == p.Name
+}
+
+// sessionByID returns a session the caller may access
+func sessionByID(r *http.Request, id string) (*Session, bool) {
+	s, ok := sessions.Get(id)
+	if !ok || !canAccess(r, s) {
+		return nil, false
+	}
+	return s, true
+}
+
+// lookupSession returns the caller's session if it exists
+func lookupSession(r *http.Request) (*Session, bool) {
+	id := requestSessionID(r)
+	if id == "" {
+		return nil, false
+	}
+	return sessionByID(r, id)
+}
+
+// sessionForRequest returns the caller's session, issuing a new one if the
+// request carries no known session ID
+func sessionForRequest(w http.ResponseWriter, r *http.Request) *Session {
+	if s, ok := lookupSession(r); ok {
+		return s
+	}
+	s := sessions.Create(requestOwner(r))
+	setSessionCookie(w, s.ID)
+	return s
+}
+
+func setSessionCookie(w http.ResponseWriter, id string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    id,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	w.Header().Set(sessionHeaderName, id)
+}
+
+func createSessionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		s := sessions.Create(requestOwner(r))
+		setSessionCookie(w, s.ID)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(s.Info())
+		return
+	}
+
+	http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+}
+
+// listSessionsHandler lists the caller's sessions. Without auth all sessions
+// share the empty owner, so only the session the request carries is listed;
+// listing the others would hand out their IDs.
+func listSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		list := []SessionInfo{}
+		if principalFrom(r.Context()) != nil {
+			list = sessions.List(requestOwner(r))
+		} else if s, ok := lookupSession(r); ok {
+			list = append(list, s.Info())
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"current":  requestSessionID(r),
+			"sessions": list,
+		})
+		return
+	}
+
+	http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+}
+
+func deleteSessionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodDelete {
+		id := mux.Vars(r)["id"]
+		if _, ok := sessionByID(r, id); !ok || !sessions.Delete(id) {
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Session %s deleted.", id)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"status": "ok"})
+		return
+	}
+
+	http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+}
+
+func updateSessionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPatch {
+		s, ok := sessionByID(r, mux.Vars(r)["id"])
+		if !ok {
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		}
+		var req struct {
+			Profile *string `json:"profile"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid input", http.StatusBadRequest)
+			return
+		}
+		if req.Profile != nil {
+			if *req.Profile != "" && !HasProfile(*req.Profile) {
+				http.Error(w, fmt.Sprintf("Unknown profile %q", *req.Profile), http.StatusBadRequest)
+				return
+			}
+			s.SetProfile(*req.Profile)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.Info())
+		return
+	}
+
+	http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+}
+
+func sessionMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		s, ok := sessionByID(r, mux.Vars(r)["id"])
+		if !ok {
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"session":  s.Info(),
+			"messages": s.History(),
+			"branches": s.Branches(),
+		})
+		return
+	}
+
+	http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+}
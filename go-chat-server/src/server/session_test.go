@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// useSessions swaps in an empty in-memory session store for the test
+func useSessions(t *testing.T) {
+	t.Helper()
+	st, err := NewSessionStore(NewMemoryHistoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := sessions
+	sessions = st
+	t.Cleanup(func() { sessions = saved })
+}
+
+func listSessionIDs(t *testing.T, r *http.Request) []string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	listSessionsHandler(rec, r)
+	var resp struct {
+		Sessions []SessionInfo `json:"sessions"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	ids := []string{}
+	for _, info := range resp.Sessions {
+		ids = append(ids, info.ID)
+	}
+	return ids
+}
+
+func TestListSessionsWithoutAuth(t *testing.T) {
+	useSessions(t)
+	a, b := sessions.Create(""), sessions.Create("")
+
+	if ids := listSessionIDs(t, httptest.NewRequest(http.MethodGet, "/sessions", nil)); len(ids) != 0 {
+		t.Errorf("a request without a session listed %v", ids)
+	}
+	r := httptest.NewRequest(http.MethodGet, "/sessions", nil)
+	r.Header.Set(sessionHeaderName, a.ID)
+	if ids := listSessionIDs(t, r); len(ids) != 1 || ids[0] != a.ID {
+		t.Errorf("sessions = %v, want only %s", ids, a.ID)
+	}
+	r = httptest.NewRequest(http.MethodGet, "/sessions", nil)
+	r.AddCookie(&http.Cookie{Name: sessionCookieName, Value: b.ID})
+	if ids := listSessionIDs(t, r); len(ids) != 1 || ids[0] != b.ID {
+		t.Errorf("sessions = %v, want only %s", ids, b.ID)
+	}
+	r = httptest.NewRequest(http.MethodGet, "/sessions", nil)
+	r.Header.Set(sessionHeaderName, "0123456789abcdef0123456789abcdef")
+	if ids := listSessionIDs(t, r); len(ids) != 0 {
+		t.Errorf("an unknown session ID listed %v", ids)
+	}
+}
+
+func TestListSessionsWithAuth(t *testing.T) {
+	useSessions(t)
+	alice1, alice2 := sessions.Create("alice"), sessions.Create("alice")
+	bob := sessions.Create("bob")
+
+	asUser := func(name, role string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/sessions", nil)
+		return r.WithContext(context.WithValue(r.Context(), principalKey{}, &Principal{Name: name, Role: role}))
+	}
+	ids := listSessionIDs(t, asUser("alice", roleUser))
+	if len(ids) != 2 || (ids[0] != alice1.ID && ids[0] != alice2.ID) || (ids[1] != alice1.ID && ids[1] != alice2.ID) {
+		t.Errorf("alice's sessions = %v", ids)
+	}
+
+	r := asUser("alice", roleUser)
+	r.Header.Set(sessionHeaderName, bob.ID)
+	if ids := listSessionIDs(t, r); len(ids) != 2 {
+		t.Errorf("carrying bob's session ID changed alice's list: %v", ids)
+	}
+	if _, ok := lookupSession(r); ok {
+		t.Error("alice can access bob's session")
+	}
+	r = asUser("root", roleAdmin)
+	r.Header.Set(sessionHeaderName, bob.ID)
+	if _, ok := lookupSession(r); !ok {
+		t.Error("an admin cannot access bob's session")
+	}
+}
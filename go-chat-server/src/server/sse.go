@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+
+	"github.com/cloudwego/eino/schema"
+)
+
+// sseProtocolVersion is the version of the /send event protocol. Every event
+// carries a JSON object as its data:
+//
+//	start     {"version", "session_id", "generation_id", "message_id", "model", "profile"}
+//	delta     {"text"}                  a piece of the answer
+//	reasoning {"text"}                  a piece of the model's thinking
+//	tool_call {"id", "name", "arguments"}
+//	tool_result {"id", "name", "result", "error", "duration_ms"}
+//	usage     {"prompt_tokens", "completion_tokens", "total_tokens"}
+//	done      {"message_id", "finish_reason", "truncated", "provider"}
+//	error     {"code", "message"}
+//
+// tool_call and tool_result come in pairs when the model calls one of the
+// enabled tools; the answer continues with further deltas once the tools
+// have run. A stream that ends without done or error was dropped.
+const sseProtocolVersion = 1
+
+// Event names of the /send protocol
+const (
+	eventStart      = "start"
+	eventDelta      = "delta"
+	eventReasoning  = "reasoning"
+	eventToolCall   = "tool_call"
+	eventToolResult = "tool_result"
+	eventUsage      = "usage"
+	eventDone       = "done"
+	eventError      = "error"
+)
+
+// Error codes carried by the error event
+const (
+	errCodeUpstream    = "upstream_error"
+	errCodeStream      = "stream_error"
+	errCodeInvalidArgs = "invalid_request"
+)
+
+// finishReasonCancelled is reported by done when the client stopped the generation
+const finishReasonCancelled = "cancelled"
+
+type startEvent struct {
+	Version      int    `json:"version"`
+	SessionID    string `json:"session_id"`
+	GenerationID string `json:"generation_id"`
+	MessageID    string `json:"message_id"`
+	Model        string `json:"model"`
+	Profile      string `json:"profile"`
+}
+
+type textEvent struct {
+	Text string `json:"text"`
+}
+
+type toolCallEvent struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+type usageEvent struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+type doneEvent struct {
+	MessageID    string `json:"message_id"`
+	FinishReason string `json:"finish_reason"`
+	Truncated    bool   `json:"truncated"`
+	// Provider is the profile that answered, set for profiles with fallbacks
+	Provider string `json:"provider,omitempty"`
+}
+
+type errorEvent struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// eventWriter writes the events of one /send response
+type eventWriter struct {
+	bw      *bufio.Writer
+	flusher http.Flusher
+}
+
+func newEventWriter(w http.ResponseWriter, flusher http.Flusher) *eventWriter {
+	return &eventWriter{bw: bufio.NewWriter(w), flusher: flusher}
+}
+
+// Send writes one event with a JSON encoded payload
+func (ew *eventWriter) Send(event string, payload any) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		data, _ = json.Marshal(errorEvent{Code: errCodeStream, Message: err.Error()})
+		event = eventError
+	}
+	writeSSE(ew.bw, ew.flusher, event, string(data))
+}
+
+// Error writes a typed error event
+func (ew *eventWriter) Error(code, message string) {
+	ew.Send(eventError, errorEvent{Code: code, Message: message})
+}
+
+// Usage writes the usage event if the model reported token usage
+func (ew *eventWriter) Usage(meta *schema.ResponseMeta) {
+	if meta == nil || meta.Usage == nil {
+		return
+	}
+	ew.Send(eventUsage, usageEvent{
+		PromptTokens:     meta.Usage.PromptTokens,
+		CompletionTokens: meta.Usage.CompletionTokens,
+		TotalTokens:      meta.Usage.TotalTokens,
+	})
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/cloudwego/eino/schema"
+)
+
+const (
+	historyStoreFile   = "file"
+	historyStoreMemory = "memory"
+	defaultHistoryDir  = "./history"
+)
+
+// StoredSession is a session as kept by a HistoryStore
+type StoredSession struct {
+	Meta     SessionMeta
+	Messages []*schema.Message
+}
+
+// HistoryStore persists sessions and their messages across restarts
+type HistoryStore interface {
+	// SaveMeta creates or replaces the metadata of a session
+	SaveMeta(meta SessionMeta) error
+	// AppendMessages appends messages to the end of a session's history
+	AppendMessages(id string, msgs ...*schema.Message) error
+	// ClearMessages removes all messages of a session but keeps the session
+	ClearMessages(id string) error
+	// Delete removes a session and all of its messages
+	Delete(id string) error
+	// Load returns every stored session with its messages, most recent first
+	Load() ([]StoredSession, error)
+}
+
+// openHistoryStore creates the history store selected by the configuration
+func openHistoryStore() (HistoryStore, error) {
+	configLock.RLock()
+	kind, dir := config.HistoryStore, config.HistoryDir
+	configLock.RUnlock()
+
+	switch kind {
+	case "", historyStoreFile:
+		if dir == "" {
+			dir = defaultHistoryDir
+		}
+		return NewFileHistoryStore(dir)
+	case historyStoreMemory:
+		return NewMemoryHistoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown history store %q", kind)
+	}
+}
+
+// FileHistoryStore keeps one metadata file and one append-only JSON Lines
+// message log per session inside a directory
+type FileHistoryStore struct {
+	mu  sync.Mutex
+	dir string
+}
+
+// NewFileHistoryStore creates a file store rooted at dir, creating it if needed
+func NewFileHistoryStore(dir string) (*FileHistoryStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("create history dir: %w", err)
+	}
+	return &FileHistoryStore{dir: dir}, nil
+}
+
+func (f *FileHistoryStore) metaPath(id string) string {
+	return filepath.Join(f.dir, id+".json")
+}
+
+func (f *FileHistoryStore) logPath(id string) string {
+	return filepath.Join(f.dir, id+".jsonl")
+}
+
+func (f *FileHistoryStore) SaveMeta(meta SessionMeta) error {
+	if err := validateSessionID(meta.ID); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// Write to a temp file first so a crash never leaves half a metadata file
+	tmp := f.metaPath(meta.ID) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.metaPath(meta.ID))
+}
+
+func (f *FileHistoryStore) AppendMessages(id string, msgs ...*schema.Message) error {
+	if err := validateSessionID(id); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	file, err := os.OpenFile(f.logPath(id), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	bw := bufio.NewWriter(file)
+	enc := json.NewEncoder(bw)
+	for _, msg := range msgs {
+		if err := enc.Encode(msg); err != nil {
+			return err
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+func (f *FileHistoryStore) ClearMessages(id string) error {
+	if err := validateSessionID(id); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return os.WriteFile(f.logPath(id), nil, 0600)
+}
+
+func (f *FileHistoryStore) Delete(id string) error {
+	if err := validateSessionID(id); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, path := range []string{f.metaPath(id), f.logPath(id)} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *FileHistoryStore) listLocked() ([]SessionMeta, error) {
+	paths, err := filepath.Glob(filepath.Join(f.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	metas := make([]SessionMeta, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var meta SessionMeta
+		if err := json.Unmarshal(data, &meta); err != nil {
+			log.Printf("Skipping corrupt session file %s: %v", path, err)
+			continue
+		}
+		metas = append(metas, meta)
+	}
+	sortMetas(metas)
+	return metas, nil
+}
+
+func (f *FileHistoryStore) Load() ([]StoredSession, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	metas, err := f.listLocked()
+	if err != nil {
+		return nil, err
+	}
+	stored := make([]StoredSession, 0, len(metas))
+	for _, meta := range metas {
+		msgs, err := f.readLog(meta.ID)
+		if err != nil {
+			return nil, fmt.Errorf("load session %s: %w", meta.ID, err)
+		}
+		stored = append(stored, StoredSession{Meta: meta, Messages: msgs})
+	}
+	return stored, nil
+}
+
+func (f *FileHistoryStore) readLog(id string) ([]*schema.Message, error) {
+	file, err := os.Open(f.logPath(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	var msgs []*schema.Message
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var msg schema.Message
+		if err := json.Unmarshal([]byte(line), &msg); err != nil {
+			// A torn last line after a crash should not lose the whole session
+			log.Printf("Skipping corrupt message in session %s: %v", id, err)
+			continue
+		}
+		msgs = append(msgs, &msg)
+	}
+	return msgs, scanner.Err()
+}
+
+// MemoryHistoryStore keeps sessions in memory only; it is meant for tests and
+// for deployments that do not want anything written to disk
+type MemoryHistoryStore struct {
+	mu       sync.Mutex
+	sessions map[string]*StoredSession
+}
+
+// NewMemoryHistoryStore creates an empty in-memory store
+func NewMemoryHistoryStore() *MemoryHistoryStore {
+	return &MemoryHistoryStore{sessions: make(map[string]*StoredSession)}
+}
+
+func (m *MemoryHistoryStore) SaveMeta(meta SessionMeta) error {
+	if err := validateSessionID(meta.ID); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s, ok := m.sessions[meta.ID]; ok {
+		s.Meta = meta
+		return nil
+	}
+	m.sessions[meta.ID] = &StoredSession{Meta: meta}
+	return nil
+}
+
+func (m *MemoryHistoryStore) AppendMessages(id string, msgs ...*schema.Message) error {
+	if err := validateSessionID(id); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		s = &StoredSession{Meta: SessionMeta{ID: id}}
+		m.sessions[id] = s
+	}
+	s.Messages = append(s.Messages, msgs...)
+	return nil
+}
+
+func (m *MemoryHistoryStore) ClearMessages(id string) error {
+	if err := validateSessionID(id); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s, ok := m.sessions[id]; ok {
+		s.Messages = nil
+	}
+	return nil
+}
+
+func (m *MemoryHistoryStore) Delete(id string) error {
+	if err := validateSessionID(id); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, id)
+	return nil
+}
+
+func (m *MemoryHistoryStore) Load() ([]StoredSession, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored := make([]StoredSession, 0, len(m.sessions))
+	for _, s := range m.sessions {
+		msgs := make([]*schema.Message, len(s.Messages))
+		copy(msgs, s.Messages)
+		stored = append(stored, StoredSession{Meta: s.Meta, Messages: msgs})
+	}
+	sort.Slice(stored, func(i, j int) bool {
+		return stored[i].Meta.UpdatedAt.After(stored[j].Meta.UpdatedAt)
+	})
+	return stored, nil
+}
+
+func sortMetas(metas []SessionMeta) {
+	sort.Slice(metas, func(i, j int) bool {
+		return metas[i].UpdatedAt.After(metas[j].UpdatedAt)
+	})
+}
+
+// validateSessionID makes sure an ID is safe to use as a file name
+func validateSessionID(id string) error {
+	if id == "" {
+		return fmt.Errorf("empty session id")
+	}
+	for _, c := range id {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return fmt.Errorf("invalid session id %q", id)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cloudwego/eino/schema"
+)
+
+// historyStores returns each HistoryStore implementation together with a
+// function that reopens it the way a restarted server would
+func historyStores(t *testing.T) map[string]func() HistoryStore {
+	dir := t.TempDir()
+	mem := NewMemoryHistoryStore()
+	return map[string]func() HistoryStore{
+		historyStoreFile: func() HistoryStore {
+			f, err := NewFileHistoryStore(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return f
+		},
+		historyStoreMemory: func() HistoryStore { return mem },
+	}
+}
+
+func loadSessions(t *testing.T, store HistoryStore) []StoredSession {
+	t.Helper()
+	stored, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stored
+}
+
+func TestHistoryStoreAppendAndReload(t *testing.T) {
+	for kind, open := range historyStores(t) {
+		t.Run(kind, func(t *testing.T) {
+			now := time.Now()
+			older := SessionMeta{ID: newSessionID(), Title: "older", UpdatedAt: now.Add(-time.Hour)}
+			newer := SessionMeta{ID: newSessionID(), Title: "newer", UpdatedAt: now}
+
+			store := open()
+			for _, meta := range []SessionMeta{older, newer} {
+				if err := store.SaveMeta(meta); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := store.AppendMessages(newer.ID, schema.UserMessage("你好")); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.AppendMessages(newer.ID, schema.AssistantMessage("你好！", nil), schema.UserMessage("再见")); err != nil {
+				t.Fatal(err)
+			}
+
+			stored := loadSessions(t, open())
+			if len(stored) != 2 || stored[0].Meta.ID != newer.ID || stored[1].Meta.ID != older.ID {
+				t.Fatalf("sessions = %+v, want newer then older", stored)
+			}
+			msgs := stored[0].Messages
+			if len(msgs) != 3 || msgs[0].Content != "你好" || msgs[1].Role != schema.Assistant || msgs[2].Content != "再见" {
+				t.Errorf("messages = %v", msgs)
+			}
+			if len(stored[1].Messages) != 0 {
+				t.Errorf("older session has messages: %v", stored[1].Messages)
+			}
+		})
+	}
+}
+
+func TestHistoryStoreRewriteMeta(t *testing.T) {
+	for kind, open := range historyStores(t) {
+		t.Run(kind, func(t *testing.T) {
+			store := open()
+			meta := SessionMeta{ID: newSessionID(), Title: "first", UpdatedAt: time.Now()}
+			if err := store.SaveMeta(meta); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.AppendMessages(meta.ID, schema.UserMessage("保留")); err != nil {
+				t.Fatal(err)
+			}
+			meta.Title = "second"
+			meta.Profile = "fast"
+			if err := store.SaveMeta(meta); err != nil {
+				t.Fatal(err)
+			}
+
+			stored := loadSessions(t, open())
+			if len(stored) != 1 {
+				t.Fatalf("sessions = %+v", stored)
+			}
+			if got := stored[0].Meta; got.Title != "second" || got.Profile != "fast" {
+				t.Errorf("meta = %+v", got)
+			}
+			if len(stored[0].Messages) != 1 {
+				t.Errorf("rewriting the metadata changed the messages: %v", stored[0].Messages)
+			}
+
+			if err := store.ClearMessages(meta.ID); err != nil {
+				t.Fatal(err)
+			}
+			stored = loadSessions(t, open())
+			if len(stored) != 1 || len(stored[0].Messages) != 0 {
+				t.Errorf("after clear: %+v", stored)
+			}
+		})
+	}
+}
+
+func TestHistoryStoreDelete(t *testing.T) {
+	for kind, open := range historyStores(t) {
+		t.Run(kind, func(t *testing.T) {
+			store := open()
+			keep := SessionMeta{ID: newSessionID(), UpdatedAt: time.Now()}
+			gone := SessionMeta{ID: newSessionID(), UpdatedAt: time.Now()}
+			for _, meta := range []SessionMeta{keep, gone} {
+				if err := store.SaveMeta(meta); err != nil {
+					t.Fatal(err)
+				}
+				if err := store.AppendMessages(meta.ID, schema.UserMessage(meta.ID)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := store.Delete(gone.ID); err != nil {
+				t.Fatal(err)
+			}
+			// deleting a session that does not exist is not an error
+			if err := store.Delete(gone.ID); err != nil {
+				t.Errorf("second delete: %v", err)
+			}
+
+			stored := loadSessions(t, open())
+			if len(stored) != 1 || stored[0].Meta.ID != keep.ID || len(stored[0].Messages) != 1 {
+				t.Errorf("sessions = %+v, want only %s", stored, keep.ID)
+			}
+		})
+	}
+}
+
+func TestHistoryStoreRejectsInvalidIDs(t *testing.T) {
+	for kind, open := range historyStores(t) {
+		t.Run(kind, func(t *testing.T) {
+			store := open()
+			for _, id := range []string{"", "../etc/passwd", "ABCDEF", "abc.json", "abc/def", "abc xyz"} {
+				if err := store.SaveMeta(SessionMeta{ID: id}); err == nil {
+					t.Errorf("SaveMeta(%q) succeeded", id)
+				}
+				if err := store.AppendMessages(id, schema.UserMessage("x")); err == nil {
+					t.Errorf("AppendMessages(%q) succeeded", id)
+				}
+				if err := store.ClearMessages(id); err == nil {
+					t.Errorf("ClearMessages(%q) succeeded", id)
+				}
+				if err := store.Delete(id); err == nil {
+					t.Errorf("Delete(%q) succeeded", id)
+				}
+			}
+			if stored := loadSessions(t, open()); len(stored) != 0 {
+				t.Errorf("invalid sessions were stored: %+v", stored)
+			}
+		})
+	}
+}
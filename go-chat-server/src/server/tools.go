@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/cloudwego/eino/components/tool"
+	"github.com/cloudwego/eino/schema"
+)
+
+const (
+	// defaultToolRounds bounds the rounds of tool calls of one answer
+	defaultToolRounds = 5
+	// defaultToolTimeout bounds a single tool call
+	defaultToolTimeout = 30 * time.Second
+	// maxToolResult is how much of a tool's output is passed to the model
+	maxToolResult = 16 << 10
+)
+
+// toolCallsKey holds the tool calls made while answering in Message.Extra
+const toolCallsKey = "tool_calls"
+
+// ToolsConfig selects the tools the model may call while answering
+type ToolsConfig struct {
+	// Enabled names the tools offered to the model; none are offered by default
+	Enabled []string `json:"enabled,omitempty"`
+	// FileRoot is the directory read_file may read from; read_file is not
+	// offered without it
+	FileRoot string `json:"file_root,omitempty"`
+	// MaxRounds bounds the rounds of tool calls per answer. Default: 5
+	MaxRounds int `json:"max_rounds,omitempty"`
+	// Timeout bounds a single tool call, e.g. "10s". Default: 30s
+	Timeout string `json:"timeout,omitempty"`
+	// MCPServers are launched at startup and offer their tools to the model
+	MCPServers []MCPServerConfig `json:"mcp_servers,omitempty"`
+}
+
+// GetToolsConfig returns the current tool settings
+func GetToolsConfig() ToolsConfig {
+	configLock.RLock()
+	defer configLock.RUnlock()
+	return config.Tools
+}
+
+func (c *ToolsConfig) maxRounds() int {
+	if c.MaxRounds > 0 {
+		return c.MaxRounds
+	}
+	return defaultToolRounds
+}
+
+func (c *ToolsConfig) timeout() time.Duration {
+	if c.Timeout != "" {
+		if d, err := time.ParseDuration(c.Timeout); err == nil && d > 0 {
+			return d
+		}
+		log.Printf("Invalid tools.timeout %q, using %s", c.Timeout, defaultToolTimeout)
+	}
+	return defaultToolTimeout
+}
+
+// toolRegistry holds the tools the server can offer to the model by name
+type toolRegistry struct {
+	mu    sync.RWMutex
+	tools map[string]registeredTool
+}
+
+type registeredTool struct {
+	tool tool.InvokableTool
+	info *schema.ToolInfo
+	// server is the MCP server the tool comes from; empty for built-in tools
+	server string
+	// remoteName is the tool's name on its MCP server
+	remoteName string
+}
+
+var tools = &toolRegistry{tools: make(map[string]registeredTool)}
+
+// Register adds a built-in tool under the name of its ToolInfo, replacing
+// an earlier registration of the same tool
+func (r *toolRegistry) Register(t tool.InvokableTool) error {
+	return r.register(t, "", "")
+}
+
+// register fails when the name is taken by a different tool, so that the
+// model never reaches one tool under the name of another
+func (r *toolRegistry) register(t tool.InvokableTool, server, remoteName string) error {
+	info, err := t.Info(context.Background())
+	if err != nil {
+		return err
+	}
+	if info.Name == "" {
+		return fmt.Errorf("tool has no name")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if prev, ok := r.tools[info.Name]; ok && (prev.server != server || prev.remoteName != remoteName) {
+		return fmt.Errorf("tool name %q is already used by %s", info.Name, prev.describe())
+	}
+	r.tools[info.Name] = registeredTool{tool: t, info: info, server: server, remoteName: remoteName}
+	return nil
+}
+
+// describe names the tool and where it comes from, for error messages
+func (t *registeredTool) describe() string {
+	if t.server == "" {
+		return "the built-in tool " + t.info.Name
+	}
+	return fmt.Sprintf("tool %q of MCP server %s", t.remoteName, t.server)
+}
+
+// UnregisterServer removes the tools of an MCP server
+func (r *toolRegistry) UnregisterServer(server string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for name, t := range r.tools {
+		if t.server == server {
+			delete(r.tools, name)
+		}
+	}
+}
+
+// Get returns a tool by name
+func (r *toolRegistry) Get(name string) (registeredTool, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	t, ok := r.tools[name]
+	return t, ok
+}
+
+// List returns all registered tools sorted by name
+func (r *toolRegistry) List() []registeredTool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	list := make([]registeredTool, 0, len(r.tools))
+	for _, t := range r.tools {
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].info.Name < list[j].info.Name })
+	return list
+}
+
+// enabled reports whether the config lets the model use the tool. Built-in
+// tools are enabled by name, those of MCP servers through the server's
+// allow and deny lists.
+func (t *registeredTool) enabled(cfg *ToolsConfig) bool {
+	if t.server != "" {
+		s := cfg.mcpServer(t.server)
+		return s != nil && !s.Disabled && s.allows(t.remoteName)
+	}
+	if t.info.Name == readFileToolName && cfg.FileRoot == "" {
+		return false
+	}
+	return slices.Contains(cfg.Enabled, t.info.Name)
+}
+
+// enabledTools returns the descriptions of the tools the config enables
+func enabledTools() []*schema.ToolInfo {
+	cfg := GetToolsConfig()
+	var infos []*schema.ToolInfo
+	for _, t := range tools.List() {
+		if t.enabled(&cfg) {
+			infos = append(infos, t.info)
+		}
+	}
+	return infos
+}
+
+// toolCallRecord is a tool call made while answering, as kept in the
+// history and sent in the tool_call and tool_result events
+type toolCallRecord struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Arguments  string `json:"arguments"`
+	Result     string `json:"result,omitempty"`
+	Error      string `json:"error,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+// runTool executes a tool call requested by the model. Failures are
+// reported to the model as the result rather than ending the answer.
+func runTool(ctx context.Context, call schema.ToolCall) toolCallRecord {
+	rec := toolCallRecord{ID: call.ID, Name: call.Function.Name, Arguments: call.Function.Arguments}
+	cfg := GetToolsConfig()
+	t, ok := tools.Get(call.Function.Name)
+	if !ok || !t.enabled(&cfg) {
+		rec.Error = fmt.Sprintf("unknown tool %q", call.Function.Name)
+		return rec
+	}
+	ctx, cancel := context.WithTimeout(ctx, cfg.timeout())
+	defer cancel()
+	start := time.Now()
+	out, err := t.tool.InvokableRun(ctx, call.Function.Arguments)
+	rec.DurationMS = time.Since(start).Milliseconds()
+	if err != nil {
+		rec.Error = err.Error()
+		return rec
+	}
+	rec.Result = truncateToolResult(out)
+	return rec
+}
+
+// modelContent is what the model is told about the call
+func (rec *toolCallRecord) modelContent() string {
+	if rec.Error != "" {
+		return "Error: " + rec.Error
+	}
+	return rec.Result
+}
+
+func truncateToolResult(s string) string {
+	if len(s) <= maxToolResult {
+		return s
+	}
+	cut := maxToolResult
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut] + "\n[truncated]"
+}
+
+// toolInfo is a tool as listed by GET /tools
+type toolInfo struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// Server is the MCP server the tool comes from; empty for built-in tools
+	Server  string `json:"server,omitempty"`
+	Enabled bool   `json:"enabled"`
+}
+
+func listToolsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		cfg := GetToolsConfig()
+		list := []toolInfo{}
+		for _, t := range tools.List() {
+			list = append(list, toolInfo{Name: t.info.Name, Description: t.info.Desc, Server: t.server, Enabled: t.enabled(&cfg)})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+		return
+	}
+
+	http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+}
@@ -0,0 +1,429 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"io/fs"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudwego/eino/components/tool"
+	"github.com/cloudwego/eino/components/tool/utils"
+	"github.com/cloudwego/eino/schema"
+)
+
+// Names of the built-in tools
+const (
+	currentTimeToolName = "current_time"
+	calculatorToolName  = "calculator"
+	convertToolName     = "convert_units"
+	readFileToolName    = "read_file"
+)
+
+// registerBuiltinTools adds the tools that come with the server
+func registerBuiltinTools() {
+	for _, t := range []tool.InvokableTool{
+		utils.NewTool(&schema.ToolInfo{
+			Name: currentTimeToolName,
+			Desc: "Returns the current date and time.",
+			ParamsOneOf: schema.NewParamsOneOfByParams(map[string]*schema.ParameterInfo{
+				"timezone": {Type: schema.String, Desc: "IANA time zone, e.g. Europe/Berlin. Default: the server's time zone"},
+			}),
+		}, currentTime),
+		utils.NewTool(&schema.ToolInfo{
+			Name: calculatorToolName,
+			Desc: "Evaluates an arithmetic expression with + - * / %, parentheses, the constants pi and e, and the functions sqrt, abs, exp, ln, log10, log2, sin, cos, tan, asin, acos, atan, floor, ceil, round, min, max and pow(x, y).",
+			ParamsOneOf: schema.NewParamsOneOfByParams(map[string]*schema.ParameterInfo{
+				"expression": {Type: schema.String, Desc: "The expression, e.g. (2 + 3) * sqrt(16)", Required: true},
+			}),
+		}, calculate),
+		utils.NewTool(&schema.ToolInfo{
+			Name: convertToolName,
+			Desc: "Converts a value between units of length, mass, volume, time, speed, data size or temperature.",
+			ParamsOneOf: schema.NewParamsOneOfByParams(map[string]*schema.ParameterInfo{
+				"value": {Type: schema.Number, Desc: "The value to convert", Required: true},
+				"from":  {Type: schema.String, Desc: "Unit of the value, e.g. km, lb, gal, h, mph, MiB, F", Required: true},
+				"to":    {Type: schema.String, Desc: "Unit to convert to", Required: true},
+			}),
+		}, convertUnits),
+		utils.NewTool(&schema.ToolInfo{
+			Name: readFileToolName,
+			Desc: "Reads a text file, or lists a directory, below the directory the server shares with the model.",
+			ParamsOneOf: schema.NewParamsOneOfByParams(map[string]*schema.ParameterInfo{
+				"path": {Type: schema.String, Desc: "Path relative to the shared directory; \".\" lists its top level", Required: true},
+			}),
+		}, readFile),
+	} {
+		if err := tools.Register(t); err != nil {
+			log.Printf("Failed to register built-in tool: %v", err)
+		}
+	}
+}
+
+type currentTimeInput struct {
+	Timezone string `json:"timezone"`
+}
+
+type currentTimeOutput struct {
+	Time     string `json:"time"`
+	Timezone string `json:"timezone"`
+	Weekday  string `json:"weekday"`
+	Unix     int64  `json:"unix"`
+}
+
+func currentTime(_ context.Context, in currentTimeInput) (currentTimeOutput, error) {
+	now := time.Now()
+	if in.Timezone != "" {
+		loc, err := time.LoadLocation(in.Timezone)
+		if err != nil {
+			return currentTimeOutput{}, fmt.Errorf("unknown time zone %q", in.Timezone)
+		}
+		now = now.In(loc)
+	}
+	return currentTimeOutput{
+		Time:     now.Format(time.RFC3339),
+		Timezone: now.Location().String(),
+		Weekday:  now.Weekday().String(),
+		Unix:     now.Unix(),
+	}, nil
+}
+
+type calculatorInput struct {
+	Expression string `json:"expression"`
+}
+
+type calculatorOutput struct {
+	Expression string  `json:"expression"`
+	Result     float64 `json:"result"`
+}
+
+// calculate parses the expression as a Go expression and evaluates it in
+// floating point. There is no power operator: Go's ^ is XOR and binds like
+// +, so pow is a function.
+func calculate(_ context.Context, in calculatorInput) (calculatorOutput, error) {
+	expr, err := parser.ParseExpr(in.Expression)
+	if err != nil {
+		return calculatorOutput{}, fmt.Errorf("invalid expression: %v", err)
+	}
+	v, err := evalExpr(expr)
+	if err != nil {
+		return calculatorOutput{}, err
+	}
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return calculatorOutput{}, fmt.Errorf("result is not a finite number")
+	}
+	return calculatorOutput{Expression: in.Expression, Result: v}, nil
+}
+
+var calcConstants = map[string]float64{"pi": math.Pi, "e": math.E}
+
+var calcFuncs = map[string]func(...float64) (float64, error){
+	"sqrt":  unary(math.Sqrt),
+	"abs":   unary(math.Abs),
+	"exp":   unary(math.Exp),
+	"ln":    unary(math.Log),
+	"log10": unary(math.Log10),
+	"log2":  unary(math.Log2),
+	"sin":   unary(math.Sin),
+	"cos":   unary(math.Cos),
+	"tan":   unary(math.Tan),
+	"asin":  unary(math.Asin),
+	"acos":  unary(math.Acos),
+	"atan":  unary(math.Atan),
+	"floor": unary(math.Floor),
+	"ceil":  unary(math.Ceil),
+	"round": unary(math.Round),
+	"min":   variadic(math.Min),
+	"max":   variadic(math.Max),
+	"pow": func(args ...float64) (float64, error) {
+		if len(args) != 2 {
+			return 0, fmt.Errorf("pow takes 2 arguments")
+		}
+		return math.Pow(args[0], args[1]), nil
+	},
+}
+
+func unary(f func(float64) float64) func(...float64) (float64, error) {
+	return func(args ...float64) (float64, error) {
+		if len(args) != 1 {
+			return 0, fmt.Errorf("takes 1 argument")
+		}
+		return f(args[0]), nil
+	}
+}
+
+func variadic(f func(a, b float64) float64) func(...float64) (float64, error) {
+	return func(args ...float64) (float64, error) {
+		if len(args) == 0 {
+			return 0, fmt.Errorf("takes at least 1 argument")
+		}
+		v := args[0]
+		for _, a := range args[1:] {
+			v = f(v, a)
+		}
+		return v, nil
+	}
+}
+
+func evalExpr(e ast.Expr) (float64, error) {
+	switch e := e.(type) {
+	case *ast.BasicLit:
+		if e.Kind != token.INT && e.Kind != token.FLOAT {
+			return 0, fmt.Errorf("unexpected %s", e.Value)
+		}
+		return strconv.ParseFloat(e.Value, 64)
+	case *ast.ParenExpr:
+		return evalExpr(e.X)
+	case *ast.Ident:
+		if v, ok := calcConstants[e.Name]; ok {
+			return v, nil
+		}
+		return 0, fmt.Errorf("unknown name %q", e.Name)
+	case *ast.UnaryExpr:
+		x, err := evalExpr(e.X)
+		if err != nil {
+			return 0, err
+		}
+		switch e.Op {
+		case token.SUB:
+			return -x, nil
+		case token.ADD:
+			return x, nil
+		}
+	case *ast.BinaryExpr:
+		x, err := evalExpr(e.X)
+		if err != nil {
+			return 0, err
+		}
+		y, err := evalExpr(e.Y)
+		if err != nil {
+			return 0, err
+		}
+		switch e.Op {
+		case token.ADD:
+			return x + y, nil
+		case token.SUB:
+			return x - y, nil
+		case token.MUL:
+			return x * y, nil
+		case token.QUO:
+			if y == 0 {
+				return 0, fmt.Errorf("division by zero")
+			}
+			return x / y, nil
+		case token.REM:
+			if y == 0 {
+				return 0, fmt.Errorf("division by zero")
+			}
+			return math.Mod(x, y), nil
+		}
+	case *ast.CallExpr:
+		name, ok := e.Fun.(*ast.Ident)
+		if !ok {
+			break
+		}
+		f, ok := calcFuncs[name.Name]
+		if !ok {
+			return 0, fmt.Errorf("unknown function %q", name.Name)
+		}
+		args := make([]float64, len(e.Args))
+		for i, a := range e.Args {
+			v, err := evalExpr(a)
+			if err != nil {
+				return 0, err
+			}
+			args[i] = v
+		}
+		v, err := f(args...)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %v", name.Name, err)
+		}
+		return v, nil
+	}
+	return 0, fmt.Errorf("unsupported expression")
+}
+
+type convertInput struct {
+	Value float64 `json:"value"`
+	From  string  `json:"from"`
+	To    string  `json:"to"`
+}
+
+type convertOutput struct {
+	Value float64 `json:"value"`
+	Unit  string  `json:"unit"`
+}
+
+// unitFactors holds, per kind of quantity, the size of each unit in the
+// kind's base unit. Temperatures are not proportional and handled apart.
+var unitFactors = map[string]map[string]float64{
+	"length": {
+		"mm": 0.001, "cm": 0.01, "m": 1, "km": 1000,
+		"in": 0.0254, "ft": 0.3048, "yd": 0.9144, "mi": 1609.344, "nmi": 1852,
+	},
+	"mass": {
+		"mg": 1e-6, "g": 0.001, "kg": 1, "t": 1000,
+		"oz": 0.028349523125, "lb": 0.45359237, "st": 6.35029318,
+	},
+	"volume": {
+		"ml": 0.001, "cl": 0.01, "dl": 0.1, "l": 1, "m3": 1000,
+		"tsp": 0.00492892159375, "tbsp": 0.0147867647813, "floz": 0.0295735295625,
+		"cup": 0.2365882365, "pt": 0.473176473, "qt": 0.946352946, "gal": 3.785411784,
+	},
+	"time": {
+		"ms": 0.001, "s": 1, "min": 60, "h": 3600, "d": 86400, "wk": 604800,
+	},
+	"speed": {
+		"m/s": 1, "km/h": 1 / 3.6, "mph": 0.44704, "kn": 1852.0 / 3600, "ft/s": 0.3048,
+	},
+	"data": {
+		"b": 0.125, "B": 1, "kB": 1e3, "MB": 1e6, "GB": 1e9, "TB": 1e12,
+		"KiB": 1 << 10, "MiB": 1 << 20, "GiB": 1 << 30, "TiB": 1 << 40,
+	},
+}
+
+// unitAliases maps other spellings to the names used in unitFactors
+var unitAliases = map[string]string{
+	"meter": "m", "meters": "m", "kilometer": "km", "kilometers": "km", "mile": "mi", "miles": "mi",
+	"inch": "in", "inches": "in", "foot": "ft", "feet": "ft", "yard": "yd", "yards": "yd",
+	"gram": "g", "grams": "g", "kilogram": "kg", "kilograms": "kg", "pound": "lb", "pounds": "lb", "lbs": "lb", "ounce": "oz", "ounces": "oz",
+	"liter": "l", "liters": "l", "litre": "l", "litres": "l", "L": "l", "mL": "ml", "gallon": "gal", "gallons": "gal",
+	"sec": "s", "second": "s", "seconds": "s", "minute": "min", "minutes": "min", "hour": "h", "hours": "h", "day": "d", "days": "d", "week": "wk", "weeks": "wk",
+	"kmh": "km/h", "kph": "km/h", "knots": "kn",
+	"bit": "b", "bits": "b", "byte": "B", "bytes": "B", "KB": "kB",
+}
+
+func convertUnits(_ context.Context, in convertInput) (convertOutput, error) {
+	from, to := normalizeUnit(in.From), normalizeUnit(in.To)
+	if isTemperature(from) || isTemperature(to) {
+		if !isTemperature(from) || !isTemperature(to) {
+			return convertOutput{}, fmt.Errorf("cannot convert %s to %s", in.From, in.To)
+		}
+		return convertOutput{Value: fromKelvin(toKelvin(in.Value, from), to), Unit: to}, nil
+	}
+	for _, units := range unitFactors {
+		f, okFrom := units[from]
+		t, okTo := units[to]
+		if okFrom && okTo {
+			return convertOutput{Value: in.Value * f / t, Unit: to}, nil
+		}
+		if okFrom || okTo {
+			return convertOutput{}, fmt.Errorf("cannot convert %s to %s", in.From, in.To)
+		}
+	}
+	return convertOutput{}, fmt.Errorf("unknown unit %q or %q", in.From, in.To)
+}
+
+func normalizeUnit(u string) string {
+	u = strings.TrimSpace(u)
+	if a, ok := unitAliases[u]; ok {
+		return a
+	}
+	if a, ok := unitAliases[strings.ToLower(u)]; ok {
+		return a
+	}
+	switch strings.ToLower(strings.TrimPrefix(u, "°")) {
+	case "c", "celsius":
+		return "C"
+	case "f", "fahrenheit":
+		return "F"
+	case "k", "kelvin":
+		return "K"
+	}
+	return u
+}
+
+func isTemperature(u string) bool {
+	return u == "C" || u == "F" || u == "K"
+}
+
+func toKelvin(v float64, unit string) float64 {
+	switch unit {
+	case "C":
+		return v + 273.15
+	case "F":
+		return (v-32)*5/9 + 273.15
+	}
+	return v
+}
+
+func fromKelvin(v float64, unit string) float64 {
+	switch unit {
+	case "C":
+		return v - 273.15
+	case "F":
+		return (v-273.15)*9/5 + 32
+	}
+	return v
+}
+
+type readFileInput struct {
+	Path string `json:"path"`
+}
+
+// readFile reads below tools.file_root only. os.Root rejects paths, and
+// symbolic links, that lead out of the directory.
+func readFile(_ context.Context, in readFileInput) (string, error) {
+	rootDir := GetToolsConfig().FileRoot
+	if rootDir == "" {
+		return "", fmt.Errorf("no directory is shared with the model")
+	}
+	root, err := os.OpenRoot(rootDir)
+	if err != nil {
+		return "", fmt.Errorf("shared directory is not available")
+	}
+	defer root.Close()
+
+	name := filepath.Clean(strings.TrimPrefix(filepath.FromSlash(in.Path), string(filepath.Separator)))
+	f, err := root.Open(name)
+	if err != nil {
+		return "", fmt.Errorf("cannot open %s: %v", in.Path, unwrapPathError(err))
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	if st.IsDir() {
+		entries, err := f.ReadDir(-1)
+		if err != nil {
+			return "", err
+		}
+		var b strings.Builder
+		for _, e := range entries {
+			if e.IsDir() {
+				fmt.Fprintf(&b, "%s/\n", e.Name())
+			} else if info, err := e.Info(); err == nil {
+				fmt.Fprintf(&b, "%s\t%d bytes\n", e.Name(), info.Size())
+			}
+		}
+		return b.String(), nil
+	}
+	// Longer files are cut to maxToolResult by runTool
+	data, err := io.ReadAll(io.LimitReader(f, maxToolResult+1))
+	if err != nil {
+		return "", err
+	}
+	if strings.IndexByte(string(data), 0) >= 0 {
+		return "", fmt.Errorf("%s is not a text file", in.Path)
+	}
+	return string(data), nil
+}
+
+// unwrapPathError drops the path of a PathError, which would reveal where
+// the shared directory is
+func unwrapPathError(err error) error {
+	if pe, ok := err.(*fs.PathError); ok {
+		return pe.Err
+	}
+	return err
+}
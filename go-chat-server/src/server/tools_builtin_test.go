@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCalculate(t *testing.T) {
+	tests := []struct {
+		expr string
+		want float64
+		err  string
+	}{
+		{expr: "1 + 2 * 3", want: 7},
+		{expr: "(1 + 2) * 3", want: 9},
+		{expr: "-7 % 3", want: -1},
+		{expr: "pow(2, 10) + sqrt(16)", want: 1028},
+		{expr: "max(1, 5, 3) - min(4, 2)", want: 3},
+		{expr: "round(pi * 100)", want: 314},
+		{expr: "1 / 0", err: "division by zero"},
+		{expr: "1 % (2 - 2)", err: "division by zero"},
+		{expr: "pow(10, 400)", err: "not a finite number"},
+		{expr: "exp(1000)", err: "not a finite number"},
+		{expr: "1e400", err: "out of range"},
+		{expr: "sqrt(-1)", err: "not a finite number"},
+		{expr: "2 ^ 3", err: "unsupported expression"},
+		{expr: "os.Exit(1)", err: "unsupported expression"},
+		{expr: "math.Pi", err: "unsupported expression"},
+		{expr: "func() {}()", err: "unsupported expression"},
+		{expr: "x[0]", err: "unsupported expression"},
+		{expr: "!1", err: "unsupported expression"},
+		{expr: `"1" + 1`, err: `unexpected "1"`},
+		{expr: "exit(1)", err: `unknown function "exit"`},
+		{expr: "x + 1", err: `unknown name "x"`},
+		{expr: "pow(2)", err: "pow takes 2 arguments"},
+		{expr: "sqrt(1, 2)", err: "sqrt: takes 1 argument"},
+		{expr: "1 +", err: "invalid expression"},
+	}
+	for _, tc := range tests {
+		out, err := calculate(context.Background(), calculatorInput{Expression: tc.expr})
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("calculate(%q) error = %v, want it to contain %q", tc.expr, err, tc.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("calculate(%q): %v", tc.expr, err)
+		} else if math.Abs(out.Result-tc.want) > 1e-9 {
+			t.Errorf("calculate(%q) = %v, want %v", tc.expr, out.Result, tc.want)
+		}
+	}
+}
+
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	root, outside := t.TempDir(), t.TempDir()
+	writeTestFile(t, filepath.Join(root, "a.txt"), "hello")
+	writeTestFile(t, filepath.Join(root, "sub", "b.txt"), "nested")
+	writeTestFile(t, filepath.Join(root, "big.txt"), strings.Repeat("x", maxToolResult+100))
+	writeTestFile(t, filepath.Join(root, "bin"), "\x00\x01")
+	writeTestFile(t, filepath.Join(outside, "secret.txt"), "secret")
+	if err := os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(root, "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "out")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("a.txt", filepath.Join(root, "inside.txt")); err != nil {
+		t.Fatal(err)
+	}
+	useConfig(t, Config{Tools: ToolsConfig{FileRoot: root}})
+
+	tests := []struct {
+		path string
+		want string
+		err  string
+	}{
+		{path: "a.txt", want: "hello"},
+		{path: "sub/b.txt", want: "nested"},
+		{path: "sub/../a.txt", want: "hello"},
+		{path: "/a.txt", want: "hello"},
+		{path: "inside.txt", want: "hello"},
+		{path: "sub", want: "b.txt\t6 bytes\n"},
+		{path: "../" + filepath.Base(outside) + "/secret.txt", err: "escapes"},
+		{path: "sub/../../secret.txt", err: "escapes"},
+		{path: filepath.Join(outside, "secret.txt"), err: "no such file"},
+		{path: "link.txt", err: "escapes"},
+		{path: "out/secret.txt", err: "escapes"},
+		{path: "missing.txt", err: "no such file"},
+		{path: "bin", err: "not a text file"},
+	}
+	for _, tc := range tests {
+		got, err := readFile(context.Background(), readFileInput{Path: tc.path})
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("readFile(%q) = %q, %v, want an error containing %q", tc.path, got, err, tc.err)
+			} else if strings.Contains(err.Error(), root) {
+				t.Errorf("readFile(%q) error %q reveals the shared directory", tc.path, err)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("readFile(%q) = %q, %v, want %q", tc.path, got, err, tc.want)
+		}
+	}
+
+	got, err := readFile(context.Background(), readFileInput{Path: "big.txt"})
+	if err != nil || len(got) != maxToolResult+1 {
+		t.Errorf("big file read %d bytes, %v, want %d for runTool to cut", len(got), err, maxToolResult+1)
+	}
+
+	useConfig(t, Config{})
+	if _, err := readFile(context.Background(), readFileInput{Path: "a.txt"}); err == nil {
+		t.Error("read a file without a shared directory")
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"eino-rag/transcript"
+
+	"github.com/cloudwego/eino/schema"
+	"github.com/gorilla/mux"
+)
+
+// maxImportSize bounds the body of an import request
+const maxImportSize = 8 << 20
+
+// Import creates a session seeded with the given messages as a single branch.
+// Only user and assistant messages are kept since the system prompt comes
+// from the server's configuration; the number of dropped messages is returned.
+func (st *SessionStore) Import(owner, title string, msgs []*schema.Message) (*Session, int) {
+	s := st.Create(owner)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kept := make([]*schema.Message, 0, len(msgs))
+	parent := ""
+	for _, msg := range msgs {
+		if msg.Role != schema.User && msg.Role != schema.Assistant {
+			continue
+		}
+		extra := make(map[string]any, len(msg.Extra)+2)
+		for k, v := range msg.Extra {
+			extra[k] = v
+		}
+		// Fresh IDs keep imported copies apart from the sessions they came from
+		extra[messageIDKey] = newSessionID()
+		extra[parentIDKey] = parent
+		parent = extra[messageIDKey].(string)
+
+		content := msg.Content
+		if !strings.HasSuffix(content, "\n") {
+			content += "\n" // same layout as AppendHistory
+		}
+		kept = append(kept, &schema.Message{
+			Role:             msg.Role,
+			Content:          content,
+			ReasoningContent: msg.ReasoningContent,
+			ResponseMeta:     msg.ResponseMeta,
+			Extra:            extra,
+		})
+	}
+
+	s.Title = title
+	if s.Title == "" {
+		for _, msg := range kept {
+			if msg.Role == schema.User {
+				s.Title = makeTitle(strings.TrimSuffix(msg.Content, "\n"))
+				break
+			}
+		}
+	}
+	if len(kept) > 0 {
+		s.appendLocked(kept...)
+	} else {
+		s.saveMetaLocked()
+	}
+	return s, len(msgs) - len(kept)
+}
+
+func exportSessionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		s, ok := sessionByID(r, mux.Vars(r)["id"])
+		if !ok {
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		}
+		format := transcript.Markdown
+		if name := r.URL.Query().Get("format"); name != "" {
+			var err error
+			if format, err = transcript.ParseFormat(name); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		info := s.Info()
+		var buf bytes.Buffer
+		err := transcript.Write(&buf, format, &transcript.Transcript{
+			Title:      info.Title,
+			ExportedAt: time.Now(),
+			Messages:   s.History(),
+		})
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to export session: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", format.ContentType())
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="session-%s%s"`, info.ID, format.Ext()))
+		w.Write(buf.Bytes())
+		return
+	}
+
+	http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+}
+
+func importSessionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		t, err := transcript.Read(http.MaxBytesReader(w, r.Body, maxImportSize))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid transcript: %v", err), http.StatusBadRequest)
+			return
+		}
+		if title := r.URL.Query().Get("title"); title != "" {
+			t.Title = title
+		}
+
+		s, skipped := sessions.Import(requestOwner(r), t.Title, t.Messages)
+		log.Printf("Session %s imported with %d messages.", s.ID, len(t.Messages)-skipped)
+		setSessionCookie(w, s.ID)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]any{
+			"session": s.Info(),
+			"skipped": skipped,
+		})
+		return
+	}
+
+	http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/cloudwego/eino/schema"
+	"github.com/gorilla/mux"
+)
+
+func TestExportImportRoundTrip(t *testing.T) {
+	useSessions(t)
+	src := sessions.Create("")
+	src.AppendTurn("", "你好", &schema.Message{Role: schema.Assistant, Content: "你好！", ReasoningContent: "greet", Extra: map[string]any{messageIDKey: newSessionID(), "provider": "main"}})
+	src.AppendTurn(src.ActiveLeafID(), "再见", &schema.Message{Role: schema.Assistant, Content: "再见！", Extra: map[string]any{messageIDKey: newSessionID()}})
+
+	r := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/sessions/"+src.ID+"/export?format=json", nil), map[string]string{"id": src.ID})
+	rec := httptest.NewRecorder()
+	exportSessionHandler(rec, r)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("export = %d: %s", rec.Code, rec.Body)
+	}
+
+	rec2 := httptest.NewRecorder()
+	importSessionHandler(rec2, httptest.NewRequest(http.MethodPost, "/sessions/import", rec.Body))
+	if rec2.Code != http.StatusCreated {
+		t.Fatalf("import = %d: %s", rec2.Code, rec2.Body)
+	}
+	var resp struct {
+		Session SessionInfo `json:"session"`
+		Skipped int         `json:"skipped"`
+	}
+	if err := json.NewDecoder(rec2.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	dst, ok := sessions.Get(resp.Session.ID)
+	if !ok || dst.ID == src.ID {
+		t.Fatalf("imported session %q not found", resp.Session.ID)
+	}
+	if resp.Session.Title != src.Info().Title {
+		t.Errorf("title = %q, want %q", resp.Session.Title, src.Info().Title)
+	}
+
+	want, got := src.History(), dst.History()
+	if len(got) != len(want) {
+		t.Fatalf("imported %d messages, want %d", len(got), len(want))
+	}
+	srcIDs := make(map[string]bool)
+	for _, m := range want {
+		srcIDs[messageID(m)] = true
+	}
+	parent := ""
+	for i, m := range got {
+		if m.Role != want[i].Role || m.Content != want[i].Content || m.ReasoningContent != want[i].ReasoningContent {
+			t.Errorf("message %d = %+v, want %+v", i, m, want[i])
+		}
+		if srcIDs[messageID(m)] || parentID(m) != parent {
+			t.Errorf("message %d has ID %s under %s, want a fresh ID under %q", i, messageID(m), parentID(m), parent)
+		}
+		parent = messageID(m)
+	}
+	if got[1].Extra["provider"] != "main" {
+		t.Errorf("provider = %v, want it kept", got[1].Extra["provider"])
+	}
+}
+
+func TestImportRejectsBrokenTree(t *testing.T) {
+	useSessions(t)
+	body := `{"messages": [{"role": "user", "content": "a", "extra": {"id": "u1", "parent_id": "a1"}}, {"role": "assistant", "content": "b", "extra": {"id": "a1", "parent_id": "u1"}}]}`
+	rec := httptest.NewRecorder()
+	importSessionHandler(rec, httptest.NewRequest(http.MethodPost, "/sessions/import", strings.NewReader(body)))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("import = %d, want 400: %s", rec.Code, rec.Body)
+	}
+	if list := sessions.List(""); len(list) != 0 {
+		t.Errorf("sessions were created: %+v", list)
+	}
+}
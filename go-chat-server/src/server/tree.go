@@ -0,0 +1,339 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"eino-rag/memory"
+
+	"github.com/cloudwego/eino/schema"
+	"github.com/gorilla/mux"
+)
+
+// parentIDKey holds the ID of a message's parent in Message.Extra. Root
+// messages have an empty parent. Regenerating a reply or editing a question
+// adds a sibling, so every path from the root is a branch of the conversation.
+const parentIDKey = "parent_id"
+
+// parentID returns the ID of a message's parent
+func parentID(msg *schema.Message) string {
+	id, _ := msg.Extra[parentIDKey].(string)
+	return id
+}
+
+// addNodeLocked links a message into the tree
+func (s *Session) addNodeLocked(msg *schema.Message) {
+	id := messageID(msg)
+	s.nodes = append(s.nodes, msg)
+	s.byID[id] = msg
+	p := parentID(msg)
+	s.children[p] = append(s.children[p], id)
+}
+
+// loadNodes rebuilds the tree from persisted messages. Messages stored before
+// the history became a tree have no ID or parent and are chained in order.
+func (s *Session) loadNodes(msgs []*schema.Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prev := ""
+	for i, msg := range msgs {
+		if msg.Extra == nil {
+			msg.Extra = make(map[string]any)
+		}
+		if messageID(msg) == "" {
+			msg.Extra[messageIDKey] = fmt.Sprintf("legacy-%d", i)
+		}
+		if _, ok := msg.Extra[parentIDKey]; !ok {
+			msg.Extra[parentIDKey] = prev
+		}
+		s.addNodeLocked(msg)
+		prev = messageID(msg)
+	}
+	if _, ok := s.byID[s.ActiveLeaf]; !ok {
+		s.ActiveLeaf = prev
+	}
+}
+
+// pathLocked returns the messages from the root down to leaf
+func (s *Session) pathLocked(leaf string) []*schema.Message {
+	var path []*schema.Message
+	for id := leaf; id != "" && len(path) <= len(s.nodes); {
+		msg, ok := s.byID[id]
+		if !ok {
+			break
+		}
+		path = append(path, msg)
+		id = parentID(msg)
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	if path == nil {
+		path = make([]*schema.Message, 0)
+	}
+	return path
+}
+
+// PathTo returns the branch ending at the given message; an empty ID is the
+// empty branch before the first message
+func (s *Session) PathTo(id string) []*schema.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pathLocked(id)
+}
+
+// Message returns the message with the given ID
+func (s *Session) Message(id string) (*schema.Message, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	msg, ok := s.byID[id]
+	return msg, ok
+}
+
+// ActiveLeafID returns the last message of the active branch
+func (s *Session) ActiveLeafID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ActiveLeaf
+}
+
+// LastUserMessage returns the last user message of the active branch
+func (s *Session) LastUserMessage() (*schema.Message, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	path := s.pathLocked(s.ActiveLeaf)
+	for i := len(path) - 1; i >= 0; i-- {
+		if path[i].Role == schema.User {
+			return path[i], true
+		}
+	}
+	return nil, false
+}
+
+// AppendTurn adds a user message under parentID and the reply under it, and
+// continues the conversation from the reply. The reply's metadata (ID, usage,
+// truncation) is kept with the answer.
+func (s *Session) AppendTurn(parentID, userInput string, reply *schema.Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Title == "" {
+		s.Title = makeTitle(userInput)
+	}
+	turn := AppendHistory(nil, userInput, reply.Content)
+	user, answer := turn[0], turn[1]
+	user.Extra = map[string]any{messageIDKey: newSessionID(), parentIDKey: parentID}
+	answer.ResponseMeta = reply.ResponseMeta
+	answer.ReasoningContent = reply.ReasoningContent
+	answer.Extra = reply.Extra
+	answer.Extra[parentIDKey] = messageID(user)
+	s.appendLocked(user, answer)
+}
+
+// AppendReply adds another reply to an existing user message and continues
+// the conversation from it
+func (s *Session) AppendReply(userID string, reply *schema.Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	answer := &schema.Message{
+		Role:             schema.Assistant,
+		Content:          reply.Content + "\n", // same layout as AppendHistory
+		ReasoningContent: reply.ReasoningContent,
+		ResponseMeta:     reply.ResponseMeta,
+		Extra:            reply.Extra,
+	}
+	answer.Extra[parentIDKey] = userID
+	s.appendLocked(answer)
+}
+
+func (s *Session) appendLocked(msgs ...*schema.Message) {
+	for _, msg := range msgs {
+		s.addNodeLocked(msg)
+	}
+	s.setActiveLocked(messageID(msgs[len(msgs)-1]))
+	s.UpdatedAt = time.Now()
+
+	if err := s.store.AppendMessages(s.ID, msgs...); err != nil {
+		log.Printf("Failed to persist messages of session %s: %v", s.ID, err)
+	}
+	s.saveMetaLocked()
+}
+
+// setActiveLocked moves the conversation to another leaf. The rolling summary
+// only stays valid if the new branch shares the summarized messages.
+func (s *Session) setActiveLocked(leaf string) {
+	if n := s.Memory.Covered; n > 0 {
+		oldPath, newPath := s.pathLocked(s.ActiveLeaf), s.pathLocked(leaf)
+		if len(oldPath) < n || len(newPath) < n || oldPath[n-1] != newPath[n-1] {
+			s.Memory = memory.State{}
+		}
+	}
+	s.ActiveLeaf = leaf
+}
+
+// SwitchBranch continues the conversation from the branch containing the
+// given message, following the most recent replies below it
+func (s *Session) SwitchBranch(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.byID[id]; !ok {
+		return fmt.Errorf("message %s not found", id)
+	}
+	leaf := id
+	for {
+		kids := s.children[leaf]
+		if len(kids) == 0 {
+			break
+		}
+		leaf = kids[len(kids)-1]
+	}
+	s.setActiveLocked(leaf)
+	s.UpdatedAt = time.Now()
+	s.saveMetaLocked()
+	return nil
+}
+
+// Branches maps every message of the active branch that has alternatives to
+// the IDs of all its siblings, itself included, oldest first
+func (s *Session) Branches() map[string][]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	branches := make(map[string][]string)
+	for _, msg := range s.pathLocked(s.ActiveLeaf) {
+		if siblings := s.children[parentID(msg)]; len(siblings) > 1 {
+			branches[messageID(msg)] = append([]string(nil), siblings...)
+		}
+	}
+	return branches
+}
+
+// questionOf recovers the user's input from a stored user message
+func questionOf(msg *schema.Message) string {
+	return strings.TrimSuffix(msg.Content, "\n")
+}
+
+func regenerateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		session, ok := sessionByID(r, mux.Vars(r)["id"])
+		if !ok {
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		}
+		user, ok := session.LastUserMessage()
+		if !ok {
+			http.Error(w, "Nothing to regenerate", http.StatusBadRequest)
+			return
+		}
+		// The body is optional and may pick another profile for the new answer
+		var req struct {
+			Profile string `json:"profile"`
+			GenerationParams
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			http.Error(w, "Invalid input", http.StatusBadRequest)
+			return
+		}
+		if err := req.GenerationParams.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Profile == "" {
+			req.Profile = session.ProfileName()
+		}
+		llm, err := getChatModel(req.Profile)
+		if err != nil {
+			chatModelError(w, err)
+			return
+		}
+
+		// The new reply becomes a sibling of the current one
+		streamReply(w, r, llm, session, replyTarget{
+			ParentID: parentID(user),
+			Question: questionOf(user),
+			UserID:   messageID(user),
+		}, req.GenerationParams)
+		return
+	}
+
+	http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+}
+
+func editMessageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		vars := mux.Vars(r)
+		session, ok := sessionByID(r, vars["id"])
+		if !ok {
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		}
+		var req struct {
+			Message string `json:"message"`
+			Profile string `json:"profile"`
+			GenerationParams
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Message == "" {
+			http.Error(w, "Invalid input", http.StatusBadRequest)
+			return
+		}
+		if err := req.GenerationParams.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		original, ok := session.Message(vars["msgID"])
+		if !ok || original.Role != schema.User {
+			http.Error(w, "User message not found", http.StatusNotFound)
+			return
+		}
+		if req.Profile == "" {
+			req.Profile = session.ProfileName()
+		}
+		llm, err := getChatModel(req.Profile)
+		if err != nil {
+			chatModelError(w, err)
+			return
+		}
+
+		// The edited question forks a new branch next to the original one
+		streamReply(w, r, llm, session, replyTarget{
+			ParentID: parentID(original),
+			Question: req.Message,
+		}, req.GenerationParams)
+		return
+	}
+
+	http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+}
+
+func switchBranchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		session, ok := sessionByID(r, mux.Vars(r)["id"])
+		if !ok {
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		}
+		var req struct {
+			MessageID string `json:"message_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid input", http.StatusBadRequest)
+			return
+		}
+		if err := session.SwitchBranch(req.MessageID); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"session":  session.Info(),
+			"messages": session.History(),
+			"branches": session.Branches(),
+		})
+		return
+	}
+
+	http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+}
@@ -0,0 +1,201 @@
+// Package memory keeps a chat history inside a token budget. The most recent
+// turns are passed to the model verbatim while older turns are folded into a
+// rolling summary written by the chat model itself.
+package memory
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/schema"
+)
+
+const (
+	// DefaultMaxTokens is the history budget used when Config.MaxTokens is zero
+	DefaultMaxTokens = 6000
+
+	// messageOverhead approximates the tokens spent on role markers per message
+	messageOverhead = 4
+)
+
+const summaryInstruction = "你是一个对话摘要助手。请把已有摘要和新的对话内容合并成一份简洁的摘要，" +
+	"保留用户的目标、偏好、已确认的事实、结论以及尚未解决的问题，省略寒暄和重复内容。" +
+	"直接输出摘要正文，不要添加任何解释。"
+
+// State is the summarization state of one conversation. It must be stored
+// alongside the conversation's history and passed back to Window every turn.
+type State struct {
+	// Summary is the rolling summary of the folded messages
+	Summary string `json:"summary,omitempty"`
+	// Covered is the number of leading history messages folded into Summary
+	Covered int `json:"covered,omitempty"`
+}
+
+// Config configures a Manager
+type Config struct {
+	// MaxTokens is the budget for the history window, summary included.
+	// Optional. Default: DefaultMaxTokens
+	MaxTokens int
+	// Model writes the rolling summary. Optional: when nil, turns that fall
+	// out of the window are dropped instead of summarized.
+	Model model.BaseChatModel
+}
+
+// Manager selects the part of a history that is sent to the model
+type Manager struct {
+	maxTokens int
+	model     model.BaseChatModel
+}
+
+// NewManager creates a Manager from the given configuration
+func NewManager(cfg *Config) *Manager {
+	m := &Manager{maxTokens: DefaultMaxTokens}
+	if cfg != nil {
+		if cfg.MaxTokens > 0 {
+			m.maxTokens = cfg.MaxTokens
+		}
+		m.model = cfg.Model
+	}
+	return m
+}
+
+// Window returns the messages to put in the prompt for the given history: a
+// summary of older turns, if any, followed by the most recent messages that
+// fit in the budget. Messages that no longer fit are folded into the summary
+// and the updated state is returned. The last user message is always kept,
+// even when it alone exceeds the budget. If summarization fails the window
+// is still returned, together with the unchanged state and an error that
+// says how many messages were left out of it.
+func (m *Manager) Window(ctx context.Context, history []*schema.Message, state State) ([]*schema.Message, State, error) {
+	// The history was cleared or rewritten behind our back
+	if state.Covered > len(history) {
+		state = State{}
+	}
+
+	recent := history[state.Covered:]
+	budget := m.maxTokens - CountMessageTokens(summaryMessage(state.Summary))
+	if CountMessagesTokens(recent) <= budget {
+		return withSummary(state.Summary, recent), state, nil
+	}
+
+	// Trim below the budget so that the summary is not rewritten every turn
+	cut := cutIndex(recent, budget*3/4)
+	if m.model == nil {
+		state.Covered += cut
+		return withSummary(state.Summary, recent[cut:]), state, nil
+	}
+
+	summary, err := m.summarize(ctx, state.Summary, recent[:cut])
+	if err != nil {
+		return withSummary(state.Summary, recent[cut:]), state, fmt.Errorf("%w; %d older messages left out of the prompt", err, cut)
+	}
+	next := State{Summary: summary, Covered: state.Covered + cut}
+	return withSummary(next.Summary, recent[cut:]), next, nil
+}
+
+// cutIndex returns the index of the first message to keep so that the kept
+// messages fit in target tokens. The window always starts at a user message
+// and never starts after the last one, so the current question is kept even
+// when it does not fit.
+func cutIndex(msgs []*schema.Message, target int) int {
+	used := 0
+	cut := len(msgs)
+	for i := len(msgs) - 1; i >= 0; i-- {
+		used += CountMessageTokens(msgs[i])
+		if used > target {
+			break
+		}
+		cut = i
+	}
+	lastUser := -1
+	for i := len(msgs) - 1; i >= 0; i-- {
+		if msgs[i].Role == schema.User {
+			lastUser = i
+			break
+		}
+	}
+	if lastUser < 0 {
+		return len(msgs)
+	}
+	for cut < lastUser && msgs[cut].Role != schema.User {
+		cut++
+	}
+	return min(cut, lastUser)
+}
+
+func (m *Manager) summarize(ctx context.Context, previous string, msgs []*schema.Message) (string, error) {
+	var b strings.Builder
+	if previous != "" {
+		b.WriteString("已有摘要：\n")
+		b.WriteString(previous)
+		b.WriteString("\n\n")
+	}
+	b.WriteString("新的对话内容：\n")
+	for _, msg := range msgs {
+		fmt.Fprintf(&b, "%s: %s\n", msg.Role, strings.TrimSpace(msg.Content))
+	}
+
+	out, err := m.model.Generate(ctx, []*schema.Message{
+		schema.SystemMessage(summaryInstruction),
+		schema.UserMessage(b.String()),
+	})
+	if err != nil {
+		return "", fmt.Errorf("summarize history: %w", err)
+	}
+	return strings.TrimSpace(out.Content), nil
+}
+
+func summaryMessage(summary string) *schema.Message {
+	if summary == "" {
+		return nil
+	}
+	return schema.SystemMessage("以下是之前对话的摘要：\n" + summary)
+}
+
+func withSummary(summary string, msgs []*schema.Message) []*schema.Message {
+	s := summaryMessage(summary)
+	if s == nil {
+		return msgs
+	}
+	out := make([]*schema.Message, 0, len(msgs)+1)
+	out = append(out, s)
+	return append(out, msgs...)
+}
+
+// CountTokens approximates the number of tokens in text: every CJK character
+// counts as one token and any other text as one token per four bytes
+func CountTokens(text string) int {
+	cjk, other := 0, 0
+	for _, r := range text {
+		if unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) {
+			cjk++
+		} else {
+			other += len(string(r))
+		}
+	}
+	return cjk + (other+3)/4
+}
+
+// CountMessageTokens approximates the tokens a message takes in a prompt
+func CountMessageTokens(msg *schema.Message) int {
+	if msg == nil {
+		return 0
+	}
+	n := messageOverhead + CountTokens(msg.Content)
+	for _, tc := range msg.ToolCalls {
+		n += CountTokens(tc.Function.Name) + CountTokens(tc.Function.Arguments)
+	}
+	return n
+}
+
+// CountMessagesTokens approximates the tokens a list of messages takes
+func CountMessagesTokens(msgs []*schema.Message) int {
+	n := 0
+	for _, msg := range msgs {
+		n += CountMessageTokens(msg)
+	}
+	return n
+}
@@ -0,0 +1,135 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/cloudwego/eino/components/model"
+	"github.com/cloudwego/eino/schema"
+)
+
+// fakeModel returns a fixed summary or error
+type fakeModel struct {
+	summary string
+	err     error
+	calls   int
+}
+
+func (f *fakeModel) Generate(ctx context.Context, input []*schema.Message, opts ...model.Option) (*schema.Message, error) {
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	return schema.AssistantMessage(f.summary, nil), nil
+}
+
+func (f *fakeModel) Stream(ctx context.Context, input []*schema.Message, opts ...model.Option) (*schema.StreamReader[*schema.Message], error) {
+	return nil, errors.New("not implemented")
+}
+
+// long returns text of about n tokens
+func long(n int) string {
+	return strings.Repeat("测", n)
+}
+
+func contains(msgs []*schema.Message, want *schema.Message) bool {
+	for _, m := range msgs {
+		if m == want {
+			return true
+		}
+	}
+	return false
+}
+
+func TestWindowKeepsOversizedLastUserMessage(t *testing.T) {
+	question := schema.UserMessage(long(500))
+	history := []*schema.Message{
+		schema.UserMessage("你好"),
+		schema.AssistantMessage("你好！", nil),
+		question,
+	}
+	fm := &fakeModel{summary: "打过招呼"}
+	window, state, err := NewManager(&Config{MaxTokens: 100, Model: fm}).Window(context.Background(), history, State{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if window[len(window)-1] != question {
+		t.Fatalf("window does not end with the current question: %v", window)
+	}
+	if state.Covered != 2 || state.Summary != "打过招呼" {
+		t.Errorf("state = %+v", state)
+	}
+}
+
+func TestWindowKeepsUserBeforeToolCalls(t *testing.T) {
+	question := schema.UserMessage("查一下天气")
+	call := schema.AssistantMessage("", []schema.ToolCall{{ID: "c1", Function: schema.FunctionCall{Name: "weather", Arguments: "{}"}}})
+	result := schema.ToolMessage(long(500), "c1")
+	history := []*schema.Message{
+		schema.UserMessage("早上好"),
+		schema.AssistantMessage("早上好！", nil),
+		question,
+		call,
+		result,
+	}
+	window, state, err := NewManager(&Config{MaxTokens: 100}).Window(context.Background(), history, State{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(window) != 3 || window[0] != question || window[1] != call || window[2] != result {
+		t.Fatalf("window = %v, want the last user message and its tool call", window)
+	}
+	if state.Covered != 2 {
+		t.Errorf("covered = %d, want 2", state.Covered)
+	}
+}
+
+func TestCutIndex(t *testing.T) {
+	u := func(n int) *schema.Message { return schema.UserMessage(long(n)) }
+	a := func(n int) *schema.Message { return schema.AssistantMessage(long(n), nil) }
+	tests := []struct {
+		name   string
+		msgs   []*schema.Message
+		target int
+		want   int
+	}{
+		{"all fit", []*schema.Message{u(5), a(5)}, 100, 0},
+		{"starts at a user message", []*schema.Message{u(50), a(50), u(5), a(5)}, 40, 2},
+		{"skips to the next user message", []*schema.Message{u(50), a(20), a(5), u(5), a(5)}, 40, 3},
+		{"last user too large", []*schema.Message{u(5), a(5), u(500)}, 40, 2},
+		{"tool results after last user", []*schema.Message{u(5), a(5), u(5), a(5), schema.ToolMessage(long(500), "c")}, 40, 2},
+		{"no user message", []*schema.Message{a(50), a(5)}, 20, 2},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := cutIndex(tc.msgs, tc.target); got != tc.want {
+				t.Errorf("cutIndex = %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestWindowSummarizeErrorReportsDroppedMessages(t *testing.T) {
+	old := []*schema.Message{
+		schema.UserMessage(long(60)),
+		schema.AssistantMessage(long(60), nil),
+	}
+	question := schema.UserMessage("最后的问题")
+	history := append(old, question)
+	fm := &fakeModel{err: errors.New("upstream down")}
+	window, state, err := NewManager(&Config{MaxTokens: 100, Model: fm}).Window(context.Background(), history, State{Summary: "旧摘要"})
+	if err == nil {
+		t.Fatal("want an error when summarizing fails")
+	}
+	if !strings.Contains(err.Error(), "upstream down") || !strings.Contains(err.Error(), "2 older messages left out") {
+		t.Errorf("error = %v", err)
+	}
+	if state.Covered != 0 || state.Summary != "旧摘要" {
+		t.Errorf("state changed on error: %+v", state)
+	}
+	if !contains(window, question) || contains(window, old[0]) {
+		t.Errorf("window = %v", window)
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/cloudwego/eino-ext/components/document/loader/file"
+	"github.com/cloudwego/eino/schema"
+)
+
+// ================== 引用来源 ==================
+
+// 上下文中的每段文字按顺序编号为 [1]、[2]……，模型在回答中用同样的编号标注引用。
+// 回答生成后解析这些编号，给出被引用的文档块，并提示没有标注引用的句子。
+
+// minClaimLength 是需要标注引用的句子的最小字符数，更短的句子（例如“你好。”）不检查
+const minClaimLength = 10
+
+var (
+	citationPattern = regexp.MustCompile(`\[(\d+(?:\s*[,，、]\s*\d+)*)\]`)
+	// 写在句末标点之后的引用归属于前一句，例如 “Eino 是框架。[1]”
+	trailingCitationPattern = regexp.MustCompile(`([。！？!?.])((?:\s*\[\d+(?:\s*[,，、]\s*\d+)*\])+)`)
+)
+
+// ragAnswer 是带引用来源的回答
+type ragAnswer struct {
+	Text      string       `json:"text"`
+	Citations []citedChunk `json:"citations"`
+	Warnings  []string     `json:"warnings,omitempty"`
+}
+
+// citedChunk 是回答中引用的一个文档块
+type citedChunk struct {
+	Ref       int      `json:"ref"` // 回答中使用的编号 [n]
+	ID        string   `json:"id"`
+	Source    string   `json:"source"`
+	CharStart *int     `json:"char_start,omitempty"`
+	CharEnd   *int     `json:"char_end,omitempty"`
+	Headings  []string `json:"headings,omitempty"`
+	Score     float64  `json:"score"`
+	Content   string   `json:"content"`
+}
+
+// newCitedChunk 从检索到的文档读取来源、位置和相似度
+func newCitedChunk(ref int, doc *schema.Document) citedChunk {
+	c := citedChunk{Ref: ref, ID: doc.ID, Content: doc.Content}
+	c.Source, _ = doc.MetaData[file.MetaKeySource].(string)
+	c.Score, _ = doc.MetaData[DocMetaDataVector].(float64)
+	if n, ok := metaInt(doc.MetaData, MetaKeyCharStart); ok {
+		c.CharStart = &n
+	}
+	if n, ok := metaInt(doc.MetaData, MetaKeyCharEnd); ok {
+		c.CharEnd = &n
+	}
+	c.Headings = metaStrings(doc.MetaData, MetaKeyHeadings)
+	return c
+}
+
+// sourceRef 返回文档块的来源引用，例如 docs/guide.md:120-245 (指南 > 安装)。
+// 同一来源中内容不变的文档块每次检索得到相同的引用。
+func (c *citedChunk) sourceRef() string {
+	ref := c.Source
+	if ref == "" {
+		ref = "unknown source"
+	}
+	if c.CharStart != nil && c.CharEnd != nil {
+		ref += fmt.Sprintf(":%d-%d", *c.CharStart, *c.CharEnd)
+	}
+	if len(c.Headings) > 0 {
+		ref += " (" + strings.Join(c.Headings, " > ") + ")"
+	}
+	return ref
+}
+
+// parseCitations 解析回答中的 [n] 引用。docs 是按编号顺序提供给模型的上下文。
+func parseCitations(text string, docs []*schema.Document) *ragAnswer {
+	answer := &ragAnswer{Text: text, Citations: []citedChunk{}}
+	cited := make(map[int]bool)
+	invalid := make(map[int]bool)
+	refs := citationRefs(text)
+	for _, ref := range refs {
+		switch {
+		case ref < 1 || ref > len(docs):
+			if !invalid[ref] {
+				invalid[ref] = true
+				answer.Warnings = append(answer.Warnings, fmt.Sprintf("citation [%d] does not match any of the %d passages", ref, len(docs)))
+			}
+		case !cited[ref]:
+			cited[ref] = true
+			answer.Citations = append(answer.Citations, newCitedChunk(ref, docs[ref-1]))
+		}
+	}
+	sort.Slice(answer.Citations, func(i, j int) bool { return answer.Citations[i].Ref < answer.Citations[j].Ref })
+
+	// 没有上下文或回答完全没有引用时，逐句提示只会把整个回答列一遍
+	if len(docs) == 0 || len(refs) == 0 {
+		return answer
+	}
+	for _, sentence := range splitSentences(text) {
+		if citationPattern.MatchString(sentence) || isLeadIn(sentence) {
+			continue
+		}
+		if utf8.RuneCountInString(sentence) >= minClaimLength {
+			answer.Warnings = append(answer.Warnings, fmt.Sprintf("uncited claim: %q", sentence))
+		}
+	}
+	return answer
+}
+
+// isListMarker 判断句点之前是否只有列表序号，例如 "1. 安装" 中的 "1"
+func isListMarker(s string) bool {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// isLeadIn 判断句子是否是以冒号结尾的引导语，例如“安装步骤如下：”，它本身不是需要引用的论断
+func isLeadIn(sentence string) bool {
+	return strings.HasSuffix(sentence, "：") || strings.HasSuffix(sentence, ":")
+}
+
+// citationRefs 按出现顺序返回回答中的所有引用编号，[1, 3] 这样的写法也会拆开
+func citationRefs(text string) []int {
+	var refs []int
+	for _, m := range citationPattern.FindAllStringSubmatch(text, -1) {
+		for _, part := range strings.FieldsFunc(m[1], func(r rune) bool {
+			return r == ',' || r == '，' || r == '、' || r == ' '
+		}) {
+			if n, err := strconv.Atoi(part); err == nil {
+				refs = append(refs, n)
+			}
+		}
+	}
+	return refs
+}
+
+// splitSentences 按句末标点和换行把回答拆成句子，句末标点之后的引用留在前一句中。
+// 英文句点只有后面是空白或文本结尾时才断句，以免拆开 "1.5" 或 "go.mod"，列表序号 "1." 也不断句。
+func splitSentences(text string) []string {
+	text = trailingCitationPattern.ReplaceAllString(text, "$2$1")
+	var sentences []string
+	start := 0
+	for i, r := range text {
+		end := -1
+		switch r {
+		case '。', '！', '？', '!', '?', '\n':
+			end = i + utf8.RuneLen(r)
+		case '.':
+			// 列表序号 "1. " 后面不断句
+			if isListMarker(text[start:i]) {
+				continue
+			}
+			if next := i + 1; next == len(text) || text[next] == ' ' || text[next] == '\n' || text[next] == '\t' {
+				end = next
+			}
+		}
+		if end < 0 {
+			continue
+		}
+		if s := strings.TrimSpace(text[start:end]); s != "" {
+			sentences = append(sentences, s)
+		}
+		start = end
+	}
+	if s := strings.TrimSpace(text[start:]); s != "" {
+		sentences = append(sentences, s)
+	}
+	return sentences
+}
+
+// metaInt 读取整数类型的 MetaData。注入时写入的是 int，从 Qdrant 读回的是 int64。
+func metaInt(metaData map[string]interface{}, key string) (int, bool) {
+	switch v := metaData[key].(type) {
+	case int:
+		return v, true
+	case int64:
+		return int(v), true
+	case float64:
+		return int(v), true
+	}
+	return 0, false
+}
+
+// metaStrings 读取字符串列表类型的 MetaData，兼容 []string 和从 Qdrant 读回的 []any
+func metaStrings(metaData map[string]interface{}, key string) []string {
+	switch v := metaData[key].(type) {
+	case []string:
+		return v
+	case []any:
+		out := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/cloudwego/eino-ext/components/document/loader/file"
+	"github.com/cloudwego/eino/schema"
+)
+
+func testPassages(n int) []*schema.Document {
+	docs := make([]*schema.Document, n)
+	for i := range docs {
+		docs[i] = &schema.Document{
+			ID:       fmt.Sprintf("chunk-%d", i+1),
+			Content:  fmt.Sprintf("第 %d 段", i+1),
+			MetaData: map[string]any{file.MetaKeySource: "docs/guide.md"},
+		}
+	}
+	return docs
+}
+
+func TestParseCitations(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		passages int
+		refs     []int
+		warnings []string
+	}{
+		{
+			name:     "comma separated",
+			text:     "Eino 支持流式输出和工具调用 [1,3]。",
+			passages: 3,
+			refs:     []int{1, 3},
+		},
+		{
+			name:     "full-width separators",
+			text:     "Eino 支持流式输出和工具调用 [3，1、2]。",
+			passages: 3,
+			refs:     []int{1, 2, 3},
+		},
+		{
+			name:     "adjacent brackets",
+			text:     "Eino 是一个大模型应用开发框架[1][2]。",
+			passages: 2,
+			refs:     []int{1, 2},
+		},
+		{
+			name:     "repeated reference",
+			text:     "Eino 是一个大模型应用开发框架[2]。它用 Go 语言编写而成[2]。",
+			passages: 2,
+			refs:     []int{2},
+		},
+		{
+			name:     "out of range",
+			text:     "Eino 是一个大模型应用开发框架[1][4]。它用 Go 语言编写而成[0]。",
+			passages: 2,
+			refs:     []int{1},
+			warnings: []string{
+				"citation [4] does not match any of the 2 passages",
+				"citation [0] does not match any of the 2 passages",
+			},
+		},
+		{
+			name:     "citation after the full stop",
+			text:     "Eino 是一个大模型应用开发框架。[1] 它用 Go 语言编写而成。[2]",
+			passages: 2,
+			refs:     []int{1, 2},
+		},
+		{
+			name:     "decimal point is not a sentence end",
+			text:     "Go 1.5 introduced the concurrent garbage collector [1]. It is still used today [1].",
+			passages: 1,
+			refs:     []int{1},
+		},
+		{
+			name:     "uncited claim",
+			text:     "Eino 是一个大模型应用开发框架[1]。它用 Go 语言编写而成。",
+			passages: 1,
+			refs:     []int{1},
+			warnings: []string{`uncited claim: "它用 Go 语言编写而成。"`},
+		},
+		{
+			name:     "short sentences are not claims",
+			text:     "你好。Eino 是一个大模型应用开发框架[1]。",
+			passages: 1,
+			refs:     []int{1},
+		},
+		{
+			name:     "lead-in lines",
+			text:     "安装 Eino 需要按照下面的步骤进行：\nInstall it with the following command:\n执行 go get 命令下载依赖包[1]。",
+			passages: 1,
+			refs:     []int{1},
+		},
+		{
+			name:     "no passages",
+			text:     "我没有找到相关资料，以下内容来自一般知识。Eino 是一个开发框架。",
+			passages: 0,
+		},
+		{
+			name:     "answer cites nothing",
+			text:     "Eino 是一个大模型应用开发框架。它用 Go 语言编写而成。",
+			passages: 2,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			docs := testPassages(tc.passages)
+			answer := parseCitations(tc.text, docs)
+			var refs []int
+			for _, c := range answer.Citations {
+				refs = append(refs, c.Ref)
+				if c.ID != docs[c.Ref-1].ID || c.Source != "docs/guide.md" {
+					t.Errorf("citation [%d] = %+v", c.Ref, c)
+				}
+			}
+			if !slices.Equal(refs, tc.refs) {
+				t.Errorf("refs = %v, want %v", refs, tc.refs)
+			}
+			if !slices.Equal(answer.Warnings, tc.warnings) {
+				t.Errorf("warnings:\n got %s\nwant %s", strings.Join(answer.Warnings, "\n     "), strings.Join(tc.warnings, "\n     "))
+			}
+		})
+	}
+}
+
+func TestSplitSentences(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"第一句。[1] 第二句！[2][3]", []string{"第一句[1]。", "第二句[2][3]！"}},
+		{"Version 1.5 is out. See go.mod.", []string{"Version 1.5 is out.", "See go.mod."}},
+		{"步骤如下：\n1. 安装\n2. 运行", []string{"步骤如下：", "1. 安装", "2. 运行"}},
+	}
+	for _, tc := range tests {
+		if got := splitSentences(tc.text); !slices.Equal(got, tc.want) {
+			t.Errorf("splitSentences(%q) = %q, want %q", tc.text, got, tc.want)
+		}
+	}
+}
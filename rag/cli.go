@@ -0,0 +1,603 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/cloudwego/eino/callbacks"
+	"github.com/cloudwego/eino/components/embedding"
+	"github.com/cloudwego/eino/components/model"
+	"github.com/qdrant/go-client/qdrant"
+)
+
+// ================== 命令行 ==================
+
+// 退出码，供脚本判断执行结果
+const (
+	exitOK       = 0
+	exitError    = 1 // 执行失败，例如连接不上 Qdrant 或模型
+	exitUsage    = 2 // 命令行参数或配置有误
+	exitNotFound = 3 // 要删除的来源不存在
+)
+
+// 目录中默认注入的文件扩展名
+const defaultIngestExtensions = ".txt,.md,.markdown"
+
+// scrollPageSize 是遍历集合时每页读取的点数
+const scrollPageSize = 256
+
+// usageError 表示命令行用法错误，对应退出码 exitUsage
+type usageError struct{ msg string }
+
+func (e *usageError) Error() string { return e.msg }
+
+func usagef(format string, args ...any) error {
+	return &usageError{fmt.Sprintf(format, args...)}
+}
+
+// errSourceNotFound 表示知识库中没有指定来源的文档块
+var errSourceNotFound = errors.New("source not found")
+
+// cli 保存一次命令执行所需的状态，外部组件在第一次使用时才初始化
+type cli struct {
+	jsonOutput bool
+	stdout     io.Writer
+	cfg        *Config
+
+	llm          model.ToolCallingChatModel
+	embedder     embedding.Embedder
+	qdrantClient *qdrant.Client
+}
+
+type command struct {
+	name    string
+	args    string
+	summary string
+	run     func(c *cli, ctx context.Context, fs *flag.FlagSet, args []string) error
+}
+
+var commands = []command{
+	{"ingest", "[-ext list] [paths...]", "load, split, embed and index files or directories", (*cli).ingest},
+	{"query", "<question>", "answer one question from the knowledge base", (*cli).query},
+	{"chat", "", "answer questions read line by line from stdin", (*cli).chat},
+	{"delete", "-source <path> | -unknown", "remove all chunks of a source, or the chunks without one", (*cli).delete},
+	{"list-sources", "", "list the ingested sources with their chunk counts", (*cli).listSources},
+	{"stats", "", "show collection statistics", (*cli).stats},
+	{"config", "[-keys]", "print the effective configuration", (*cli).config},
+}
+
+// runCLI 解析命令行并执行子命令，返回进程退出码
+func runCLI(ctx context.Context, args []string) int {
+	c := &cli{stdout: os.Stdout}
+	global := flag.NewFlagSet("rag", flag.ContinueOnError)
+	global.BoolVar(&c.jsonOutput, "json", false, "print results as JSON")
+	quiet := global.Bool("q", false, "suppress progress logs")
+	verbose := global.Bool("v", false, "log every component run")
+	configPath := global.String("config", "", "config file, YAML or JSON (default: $"+envConfigPath+" or ./"+defaultConfigFile+" if present)")
+	var sets setFlag
+	global.Var(&sets, "set", "override a config field, e.g. -set query.top_k=8 (repeatable)")
+	global.Usage = func() { printUsage(global) }
+	if err := global.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if global.NArg() == 0 {
+		printUsage(global)
+		return exitUsage
+	}
+	if *quiet {
+		log.SetOutput(io.Discard)
+	}
+	if *verbose {
+		callbacks.AppendGlobalHandlers(&loggerCallbacks{})
+	}
+
+	name := global.Arg(0)
+	if name == "help" {
+		printUsage(global)
+		return exitOK
+	}
+	i := slices.IndexFunc(commands, func(cmd command) bool { return cmd.name == name })
+	if i < 0 {
+		fmt.Fprintf(os.Stderr, "rag: unknown command %q\n", name)
+		printUsage(global)
+		return exitUsage
+	}
+	cmd := commands[i]
+
+	cfg, err := loadConfig(*configPath, sets)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rag: %v\n", err)
+		return exitUsage
+	}
+	c.cfg = cfg
+
+	fs := flag.NewFlagSet("rag "+cmd.name, flag.ContinueOnError)
+	fs.BoolVar(&c.jsonOutput, "json", c.jsonOutput, "print results as JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: rag %s %s\n\n%s\n", cmd.name, cmd.args, cmd.summary)
+		fs.PrintDefaults()
+	}
+	err = cmd.run(c, ctx, fs, global.Args()[1:])
+	if c.qdrantClient != nil {
+		c.qdrantClient.Close()
+	}
+
+	var usageErr *usageError
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, flag.ErrHelp):
+		return exitOK
+	case errors.As(err, &usageErr):
+		fmt.Fprintf(os.Stderr, "rag %s: %v\n", cmd.name, err)
+		fs.Usage()
+		return exitUsage
+	case errors.Is(err, errSourceNotFound):
+		fmt.Fprintf(os.Stderr, "rag %s: %v\n", cmd.name, err)
+		return exitNotFound
+	default:
+		fmt.Fprintf(os.Stderr, "rag %s: %v\n", cmd.name, err)
+		return exitError
+	}
+}
+
+func printUsage(global *flag.FlagSet) {
+	w := os.Stderr
+	fmt.Fprintln(w, "usage: rag [-config file] [-set key=value] [-json] [-q] [-v] <command> [arguments]")
+	fmt.Fprintln(w, "\ncommands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-14s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(w, "\nflags:")
+	global.SetOutput(w)
+	global.PrintDefaults()
+	fmt.Fprintf(w, "\nevery config field can also be set through an environment variable, e.g. query.top_k through %s; see rag config -keys\n", envName("query.top_k"))
+	fmt.Fprintf(w, "\nexit codes: %d ok, %d failure, %d usage or config error, %d source not found\n", exitOK, exitError, exitUsage, exitNotFound)
+}
+
+// parseFlags 解析子命令参数；解析失败时 flag 包已输出错误，这里只转换为用法错误
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	fs.SetOutput(os.Stderr)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return &usageError{err.Error()}
+	}
+	return nil
+}
+
+// setup 初始化模型、Embedder 和 Qdrant 客户端
+func (c *cli) setup(ctx context.Context) error {
+	if c.qdrantClient != nil {
+		return nil
+	}
+	llm, embedder, qdrantClient, err := setupComponents(ctx, c.cfg)
+	if err != nil {
+		return err
+	}
+	c.llm, c.embedder, c.qdrantClient = llm, embedder, qdrantClient
+	return nil
+}
+
+// print 按输出模式打印结果：JSON 模式输出 v，否则输出 text
+func (c *cli) print(v any, text string) error {
+	if c.jsonOutput {
+		return json.NewEncoder(c.stdout).Encode(v)
+	}
+	_, err := fmt.Fprintln(c.stdout, text)
+	return err
+}
+
+// --- ingest ---
+
+func (c *cli) ingest(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	exts := fs.String("ext", defaultIngestExtensions, "comma-separated file extensions to ingest from directories")
+	force := fs.Bool("force", false, "re-embed every chunk even if it is unchanged")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	paths := fs.Args()
+	if len(paths) == 0 {
+		// 未指定路径时注入示例知识库，与早期版本的行为一致
+		prepareKnowledgeFile()
+		paths = []string{KnowledgeFilePath}
+	}
+	files, err := expandPaths(paths, strings.Split(*exts, ","))
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return usagef("no files to ingest in %s", strings.Join(paths, ", "))
+	}
+	if err := c.setup(ctx); err != nil {
+		return err
+	}
+
+	var results []ingestResult
+	failed := 0
+	for _, f := range files {
+		res, err := ingestKnowledge(ctx, c.cfg, c.qdrantClient, c.embedder, f, *force)
+		if err != nil {
+			res = &ingestResult{Source: f, Error: err.Error()}
+			failed++
+		}
+		results = append(results, *res)
+		if !c.jsonOutput {
+			if err != nil {
+				fmt.Fprintf(c.stdout, "FAILED  %s: %v\n", f, err)
+			} else {
+				fmt.Fprintf(c.stdout, "%6d chunks (%d embedded, %d unchanged, %d deleted)  %s\n",
+					res.Chunks, res.Embedded, res.Unchanged, res.Deleted, f)
+			}
+		}
+	}
+	if c.jsonOutput {
+		if err := json.NewEncoder(c.stdout).Encode(map[string]any{"sources": results}); err != nil {
+			return err
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files failed", failed, len(files))
+	}
+	return nil
+}
+
+// expandPaths 把参数中的目录展开为其中带指定扩展名的文件，返回去重后的绝对路径。
+// 直接指定的文件不检查扩展名。
+func expandPaths(paths []string, exts []string) ([]string, error) {
+	for i := range exts {
+		exts[i] = strings.ToLower(strings.TrimSpace(exts[i]))
+	}
+	seen := make(map[string]bool)
+	var files []string
+	add := func(path string) error {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		if !seen[abs] {
+			seen[abs] = true
+			files = append(files, abs)
+		}
+		return nil
+	}
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, usagef("%v", err)
+		}
+		if !info.IsDir() {
+			if err := add(p); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		err = filepath.WalkDir(p, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				if path != p && strings.HasPrefix(d.Name(), ".") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if d.Type().IsRegular() && slices.Contains(exts, strings.ToLower(filepath.Ext(path))) {
+				return add(path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// normalizeSource 把来源路径转换为注入时记录的形式
+func normalizeSource(source string) string {
+	if abs, err := filepath.Abs(source); err == nil {
+		return abs
+	}
+	return source
+}
+
+// --- query / chat ---
+
+type queryResult struct {
+	Question  string       `json:"question"`
+	Answer    string       `json:"answer"`
+	Citations []citedChunk `json:"citations"`
+	Warnings  []string     `json:"warnings,omitempty"`
+	Error     string       `json:"error,omitempty"`
+}
+
+func newQueryResult(question string, answer *ragAnswer) queryResult {
+	return queryResult{
+		Question:  question,
+		Answer:    answer.Text,
+		Citations: answer.Citations,
+		Warnings:  answer.Warnings,
+	}
+}
+
+// text 返回回答及其引用来源的文本形式
+func (r *queryResult) text() string {
+	var b strings.Builder
+	b.WriteString(r.Answer)
+	if len(r.Citations) > 0 {
+		b.WriteString("\n\nSources:")
+		for _, c := range r.Citations {
+			fmt.Fprintf(&b, "\n  [%d] %s  score %.4f", c.Ref, c.sourceRef(), c.Score)
+		}
+	}
+	for _, w := range r.Warnings {
+		b.WriteString("\nwarning: " + w)
+	}
+	return b.String()
+}
+
+func (c *cli) query(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	question := strings.TrimSpace(strings.Join(fs.Args(), " "))
+	if question == "" {
+		return usagef("a question is required")
+	}
+	if err := c.setup(ctx); err != nil {
+		return err
+	}
+	answer, err := answerQuery(ctx, c.cfg, c.llm, c.qdrantClient, c.embedder, question)
+	if err != nil {
+		return err
+	}
+	res := newQueryResult(question, answer)
+	return c.print(res, res.text())
+}
+
+// chat 逐行读取问题并回答，遇到 EOF、exit 或 quit 时结束。
+// 单个问题失败不会中断会话。
+func (c *cli) chat(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return usagef("chat takes no arguments")
+	}
+	if err := c.setup(ctx); err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for {
+		if !c.jsonOutput {
+			fmt.Fprint(os.Stderr, "\n> ")
+		}
+		if !scanner.Scan() {
+			break
+		}
+		question := strings.TrimSpace(scanner.Text())
+		if question == "" {
+			continue
+		}
+		if question == "exit" || question == "quit" {
+			return nil
+		}
+		var res queryResult
+		var text string
+		answer, err := answerQuery(ctx, c.cfg, c.llm, c.qdrantClient, c.embedder, question)
+		if err != nil {
+			res = queryResult{Question: question, Citations: []citedChunk{}, Error: err.Error()}
+			text = "error: " + err.Error()
+		} else {
+			res = newQueryResult(question, answer)
+			text = res.text()
+		}
+		if err := c.print(res, text); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// --- delete ---
+
+// 早期版本写入的文档块没有来源字段，ID 也是随机的，重新注入不会覆盖它们，
+// 只能用 delete -unknown 清理。
+func (c *cli) delete(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	source := fs.String("source", "", "path of the source whose chunks are removed, as given to ingest")
+	unknown := fs.Bool("unknown", false, "remove the chunks without a source, left by versions that did not record it")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if (*source != "") == *unknown || fs.NArg() > 0 {
+		return usagef("exactly one of -source or -unknown is required")
+	}
+	if err := c.setup(ctx); err != nil {
+		return err
+	}
+	src := normalizeSource(*source)
+	what := "chunks of " + src
+	filter := &qdrant.Filter{Must: []*qdrant.Condition{qdrant.NewMatchKeyword(QdrantSourceKey, src)}}
+	if *unknown {
+		src, what = "", "chunks without a source"
+		filter = &qdrant.Filter{Must: []*qdrant.Condition{qdrant.NewIsEmpty(QdrantSourceKey)}}
+	}
+	n, err := c.qdrantClient.Count(ctx, &qdrant.CountPoints{
+		CollectionName: c.cfg.Qdrant.Collection,
+		Filter:         filter,
+		Exact:          qdrant.PtrOf(true),
+	})
+	if err != nil {
+		return fmt.Errorf("counting %s: %w", what, err)
+	}
+	if n == 0 {
+		if *unknown {
+			return c.print(map[string]any{"source": src, "deleted": 0}, "no chunks without a source")
+		}
+		return fmt.Errorf("%w: %s", errSourceNotFound, src)
+	}
+	_, err = c.qdrantClient.Delete(ctx, &qdrant.DeletePoints{
+		CollectionName: c.cfg.Qdrant.Collection,
+		Points:         qdrant.NewPointsSelectorFilter(filter),
+		Wait:           qdrant.PtrOf(true),
+	})
+	if err != nil {
+		return fmt.Errorf("deleting %s: %w", what, err)
+	}
+	if !*unknown {
+		if err := deleteManifest(ctx, c.qdrantClient, c.cfg, src); err != nil {
+			return err
+		}
+	}
+	return c.print(map[string]any{"source": src, "deleted": n}, fmt.Sprintf("deleted %d %s", n, what))
+}
+
+// --- list-sources / stats ---
+
+type sourceInfo struct {
+	Source string `json:"source"`
+	Chunks int    `json:"chunks"`
+}
+
+// collectSources 从来源清单中读取每个来源的文档块数量，不需要遍历集合。
+// 不属于任何清单的文档块（早期版本写入，可能没有来源字段）记在空来源下。
+func (c *cli) collectSources(ctx context.Context) ([]sourceInfo, error) {
+	counts, err := manifestChunkCounts(ctx, c.qdrantClient, c.cfg)
+	if err != nil {
+		return nil, err
+	}
+	total, err := c.qdrantClient.Count(ctx, &qdrant.CountPoints{
+		CollectionName: c.cfg.Qdrant.Collection,
+		Exact:          qdrant.PtrOf(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("counting chunks: %w", err)
+	}
+	sources := make([]sourceInfo, 0, len(counts)+1)
+	listed := 0
+	for s, n := range counts {
+		sources = append(sources, sourceInfo{Source: s, Chunks: n})
+		listed += n
+	}
+	if unknown := int(total) - listed; unknown > 0 {
+		sources = append(sources, sourceInfo{Chunks: unknown})
+	}
+	sort.Slice(sources, func(i, j int) bool { return sources[i].Source < sources[j].Source })
+	return sources, nil
+}
+
+func (c *cli) listSources(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := c.setup(ctx); err != nil {
+		return err
+	}
+	sources, err := c.collectSources(ctx)
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	for _, s := range sources {
+		name := s.Source
+		if name == "" {
+			name = "(unknown)"
+		}
+		fmt.Fprintf(&b, "%6d  %s\n", s.Chunks, name)
+	}
+	return c.print(sources, strings.TrimSuffix(b.String(), "\n"))
+}
+
+type collectionStats struct {
+	Collection     string `json:"collection"`
+	Status         string `json:"status"`
+	Points         uint64 `json:"points"`
+	IndexedVectors uint64 `json:"indexed_vectors"`
+	Segments       uint64 `json:"segments"`
+	VectorSize     uint64 `json:"vector_size"`
+	Distance       string `json:"distance"`
+	Sources        int    `json:"sources"`
+}
+
+func (c *cli) stats(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := c.setup(ctx); err != nil {
+		return err
+	}
+	info, err := c.qdrantClient.GetCollectionInfo(ctx, c.cfg.Qdrant.Collection)
+	if err != nil {
+		return fmt.Errorf("getting collection info: %w", err)
+	}
+	sources, err := c.collectSources(ctx)
+	if err != nil {
+		return err
+	}
+	params := info.GetConfig().GetParams().GetVectorsConfig().GetParams()
+	st := collectionStats{
+		Collection:     c.cfg.Qdrant.Collection,
+		Status:         info.GetStatus().String(),
+		Points:         info.GetPointsCount(),
+		IndexedVectors: info.GetIndexedVectorsCount(),
+		Segments:       info.GetSegmentsCount(),
+		VectorSize:     params.GetSize(),
+		Distance:       params.GetDistance().String(),
+		Sources:        len(sources),
+	}
+	text := fmt.Sprintf("collection:      %s\nstatus:          %s\npoints:          %d\nindexed vectors: %d\nsegments:        %d\nvector size:     %d\ndistance:        %s\nsources:         %d",
+		st.Collection, st.Status, st.Points, st.IndexedVectors, st.Segments, st.VectorSize, st.Distance, st.Sources)
+	return c.print(st, text)
+}
+
+// --- config ---
+
+func (c *cli) config(_ context.Context, fs *flag.FlagSet, args []string) error {
+	keys := fs.Bool("keys", false, "list the config fields with their environment variables")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return usagef("config takes no arguments")
+	}
+	if *keys {
+		var list []map[string]string
+		var b strings.Builder
+		for _, key := range configKeys() {
+			list = append(list, map[string]string{"key": key, "env": envName(key)})
+			fmt.Fprintf(&b, "%-28s %s\n", key, envName(key))
+		}
+		return c.print(list, strings.TrimSuffix(b.String(), "\n"))
+	}
+	cfg := c.cfg.redacted()
+	var b strings.Builder
+	for _, key := range configKeys() {
+		field := configField(reflect.ValueOf(cfg).Elem(), strings.Split(key, "."))
+		value := fmt.Sprint(field.Interface())
+		if field.Kind() == reflect.Slice {
+			data, _ := json.Marshal(field.Interface())
+			value = string(data)
+		}
+		fmt.Fprintf(&b, "%s = %s\n", key, value)
+	}
+	return c.print(cfg, strings.TrimSuffix(b.String(), "\n"))
+}
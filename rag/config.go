@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ================== 配置文件 ==================
+
+// 配置按默认值、配置文件、RAG_* 环境变量、命令行参数的顺序逐层覆盖
+const (
+	envPrefix     = "RAG_"
+	envConfigPath = envPrefix + "CONFIG" // 指定配置文件路径，-config 参数优先
+	// defaultConfigFile 存在时自动加载，不存在时只使用默认值
+	defaultConfigFile = "rag.yaml"
+)
+
+// 密钥可以写成引用，避免明文写入配置文件
+const (
+	secretEnvPrefix  = "env:"
+	secretFilePrefix = "file:"
+)
+
+// Config 是 RAG 程序的全部配置，可以用 YAML 或 JSON 编写
+type Config struct {
+	Qdrant QdrantConfig `json:"qdrant" yaml:"qdrant"`
+	Model  ModelConfig  `json:"model" yaml:"model"`
+	Ingest IngestConfig `json:"ingest" yaml:"ingest"`
+	Query  QueryConfig  `json:"query" yaml:"query"`
+}
+
+type QdrantConfig struct {
+	Host       string `json:"host" yaml:"host"`
+	Port       int    `json:"port" yaml:"port"` // gRPC 端口
+	Collection string `json:"collection" yaml:"collection"`
+	VectorDim  int    `json:"vector_dim" yaml:"vector_dim"` // 必须与 Embedding 模型的输出维度一致
+}
+
+type ModelConfig struct {
+	BaseURL string `json:"base_url" yaml:"base_url"` // OpenAI 兼容 API 的基础 URL
+	// APIKey 可以写成 env:NAME 或 file:/path，从环境变量或文件读取
+	APIKey         string `json:"api_key" yaml:"api_key"`
+	EmbeddingModel string `json:"embedding_model" yaml:"embedding_model"`
+	LLMModel       string `json:"llm_model" yaml:"llm_model"`
+	Timeout        string `json:"timeout" yaml:"timeout"` // 例如 "60s"
+}
+
+type IngestConfig struct {
+	ChunkSize          int      `json:"chunk_size" yaml:"chunk_size"`
+	ChunkOverlap       int      `json:"chunk_overlap" yaml:"chunk_overlap"`
+	ChunkSeparators    []string `json:"chunk_separators" yaml:"chunk_separators"`
+	EmbeddingBatchSize int      `json:"embedding_batch_size" yaml:"embedding_batch_size"` // Embedding API 允许的最大批处理大小
+}
+
+type QueryConfig struct {
+	TopK int `json:"top_k" yaml:"top_k"` // 检索时返回的文档数量
+}
+
+// defaultConfig 返回未做任何配置时使用的值
+func defaultConfig() *Config {
+	return &Config{
+		Qdrant: QdrantConfig{
+			Host:       "localhost",
+			Port:       6334,
+			Collection: "eino_best_practice_kb",
+			VectorDim:  1024,
+		},
+		Model: ModelConfig{
+			BaseURL:        "https://api.siliconflow.cn/v1",
+			EmbeddingModel: "BAAI/bge-m3",
+			LLMModel:       "Qwen/Qwen3-8B",
+			Timeout:        "60s",
+		},
+		Ingest: IngestConfig{
+			ChunkSize:          500,
+			ChunkOverlap:       100,
+			ChunkSeparators:    []string{"\n\n", "\n", "。", "！", "？", " "},
+			EmbeddingBatchSize: 32,
+		},
+		Query: QueryConfig{TopK: 5},
+	}
+}
+
+// configOverride 通过环境变量或命令行参数设置一个配置项
+type configOverride struct {
+	Key    string // 配置项的路径，例如 "qdrant.port"
+	Value  string
+	Source string // 用于错误信息，例如 "environment variable RAG_QDRANT_PORT"
+}
+
+// loadConfig 依次应用默认值、配置文件和覆盖项，并校验结果。
+// path 为空时读取 RAG_CONFIG 或默认配置文件（不存在时忽略）。
+func loadConfig(path string, overrides []configOverride) (*Config, error) {
+	cfg := defaultConfig()
+	explicit := path != ""
+	if !explicit {
+		path = os.Getenv(envConfigPath)
+		explicit = path != ""
+	}
+	if !explicit {
+		path = defaultConfigFile
+	}
+	if err := cfg.readFile(path); err != nil {
+		if explicit || !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	for _, o := range append(envOverrides(), overrides...) {
+		if err := setConfigField(reflect.ValueOf(cfg).Elem(), strings.Split(o.Key, "."), o.Value); err != nil {
+			return nil, fmt.Errorf("%s: %w", o.Source, err)
+		}
+	}
+
+	apiKey, err := resolveSecret(cfg.Model.APIKey)
+	if err != nil {
+		return nil, fmt.Errorf("model.api_key: %w", err)
+	}
+	cfg.Model.APIKey = apiKey
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	return cfg, nil
+}
+
+// readFile 把配置文件中的值合并到 c。扩展名为 .json 时按 JSON 解析，否则按 YAML 解析；
+// 未知字段视为错误，以便发现拼写错误。
+func (c *Config) readFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(c)
+	} else {
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err = dec.Decode(c); errors.Is(err, io.EOF) {
+			err = nil // 空文件
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// validate 检查配置是否可用，一次报告所有错误
+func (c *Config) validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	check(c.Qdrant.Host != "", "qdrant.host is required")
+	check(c.Qdrant.Port > 0 && c.Qdrant.Port < 65536, "qdrant.port %d is out of range", c.Qdrant.Port)
+	check(c.Qdrant.Collection != "", "qdrant.collection is required")
+	check(c.Qdrant.VectorDim > 0, "qdrant.vector_dim must be positive")
+
+	u, err := url.Parse(c.Model.BaseURL)
+	check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "model.base_url %q must be an http or https URL", c.Model.BaseURL)
+	check(c.Model.EmbeddingModel != "", "model.embedding_model is required")
+	check(c.Model.LLMModel != "", "model.llm_model is required")
+	d, err := time.ParseDuration(c.Model.Timeout)
+	check(err == nil && d > 0, "model.timeout %q must be a positive duration such as 60s", c.Model.Timeout)
+
+	check(c.Ingest.ChunkSize > 0, "ingest.chunk_size must be positive")
+	check(c.Ingest.ChunkOverlap >= 0 && c.Ingest.ChunkOverlap < c.Ingest.ChunkSize, "ingest.chunk_overlap must be at least 0 and less than ingest.chunk_size")
+	check(len(c.Ingest.ChunkSeparators) > 0, "ingest.chunk_separators must not be empty")
+	check(c.Ingest.EmbeddingBatchSize > 0, "ingest.embedding_batch_size must be positive")
+
+	check(c.Query.TopK > 0, "query.top_k must be positive")
+	return errors.Join(errs...)
+}
+
+// timeout 返回模型请求的超时时间；配置已校验过，解析不会失败
+func (c *ModelConfig) timeout() time.Duration {
+	d, _ := time.ParseDuration(c.Timeout)
+	return d
+}
+
+// redacted 返回隐藏了 API Key 的副本，用于显示
+func (c *Config) redacted() *Config {
+	cp := *c
+	if cp.Model.APIKey != "" {
+		cp.Model.APIKey = "********"
+	}
+	return &cp
+}
+
+// resolveSecret 返回 env:NAME 或 file:/path 引用的值，其他值原样返回
+func resolveSecret(v string) (string, error) {
+	switch {
+	case strings.HasPrefix(v, secretEnvPrefix):
+		name := strings.TrimPrefix(v, secretEnvPrefix)
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return value, nil
+	case strings.HasPrefix(v, secretFilePrefix):
+		data, err := os.ReadFile(strings.TrimPrefix(v, secretFilePrefix))
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+	return v, nil
+}
+
+// configKeys 列出所有配置项的路径，例如 qdrant.port
+func configKeys() []string {
+	var keys []string
+	var walk func(t reflect.Type, prefix string)
+	walk = func(t reflect.Type, prefix string) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name := jsonName(f)
+			if f.Type.Kind() == reflect.Struct {
+				walk(f.Type, prefix+name+".")
+				continue
+			}
+			keys = append(keys, prefix+name)
+		}
+	}
+	walk(reflect.TypeOf(Config{}), "")
+	return keys
+}
+
+func jsonName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	return name
+}
+
+// configField 返回 path 指定的字段，path 必须来自 configKeys
+func configField(v reflect.Value, path []string) reflect.Value {
+	for _, name := range path {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			if jsonName(t.Field(i)) == name {
+				v = v.Field(i)
+				break
+			}
+		}
+	}
+	return v
+}
+
+// envName 返回配置项对应的环境变量，例如 qdrant.port 对应 RAG_QDRANT_PORT
+func envName(key string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// envOverrides 收集通过 RAG_* 环境变量设置的配置项
+func envOverrides() []configOverride {
+	var out []configOverride
+	for _, key := range configKeys() {
+		name := envName(key)
+		if value, ok := os.LookupEnv(name); ok {
+			out = append(out, configOverride{Key: key, Value: value, Source: "environment variable " + name})
+		}
+	}
+	return out
+}
+
+// setFlag 收集可重复的 -set key=value 参数
+type setFlag []configOverride
+
+func (s *setFlag) String() string { return "" }
+
+func (s *setFlag) Set(arg string) error {
+	key, value, ok := strings.Cut(arg, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("want key=value, e.g. query.top_k=8")
+	}
+	*s = append(*s, configOverride{Key: strings.TrimSpace(key), Value: value, Source: "flag -set " + key})
+	return nil
+}
+
+// setConfigField 把 value 解析后写入 path 指定的字段。
+// 字符串列表可以写成 JSON 数组，也可以用逗号分隔。
+func setConfigField(v reflect.Value, path []string, value string) error {
+	if len(path) == 0 {
+		switch v.Kind() {
+		case reflect.String:
+			v.SetString(value)
+		case reflect.Int:
+			n, err := strconv.Atoi(strings.TrimSpace(value))
+			if err != nil {
+				return fmt.Errorf("invalid integer %q", value)
+			}
+			v.SetInt(int64(n))
+		case reflect.Slice:
+			var list []string
+			if strings.HasPrefix(strings.TrimSpace(value), "[") {
+				if err := json.Unmarshal([]byte(value), &list); err != nil {
+					return fmt.Errorf("invalid list %q: %v", value, err)
+				}
+			} else {
+				list = strings.Split(value, ",")
+			}
+			v.Set(reflect.ValueOf(list))
+		default:
+			return fmt.Errorf("unsupported field type %s", v.Type())
+		}
+		return nil
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if jsonName(t.Field(i)) != path[0] {
+			continue
+		}
+		if len(path) > 1 && t.Field(i).Type.Kind() != reflect.Struct {
+			return fmt.Errorf("%s is not an object", path[0])
+		}
+		return setConfigField(v.Field(i), path[1:], value)
+	}
+	return fmt.Errorf("unknown config field %q", path[0])
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+		errs   []string
+	}{
+		{"defaults", func(c *Config) {}, nil},
+		{"overlap equal to size", func(c *Config) { c.Ingest.ChunkOverlap = c.Ingest.ChunkSize }, []string{"ingest.chunk_overlap"}},
+		{"overlap larger than size", func(c *Config) { c.Ingest.ChunkSize, c.Ingest.ChunkOverlap = 50, 80 }, []string{"ingest.chunk_overlap"}},
+		{"negative overlap", func(c *Config) { c.Ingest.ChunkOverlap = -1 }, []string{"ingest.chunk_overlap"}},
+		{"no scheme", func(c *Config) { c.Model.BaseURL = "api.example.com/v1" }, []string{"model.base_url"}},
+		{"unsupported scheme", func(c *Config) { c.Model.BaseURL = "ftp://api.example.com" }, []string{"model.base_url"}},
+		{"no host", func(c *Config) { c.Model.BaseURL = "http:///v1" }, []string{"model.base_url"}},
+		{"unparsable URL", func(c *Config) { c.Model.BaseURL = "http://[::1" }, []string{"model.base_url"}},
+		{"bad timeout", func(c *Config) { c.Model.Timeout = "60" }, []string{"model.timeout"}},
+		{"port out of range", func(c *Config) { c.Qdrant.Port = 70000 }, []string{"qdrant.port"}},
+		{"all errors at once", func(c *Config) {
+			c.Qdrant.Host = ""
+			c.Query.TopK = 0
+			c.Ingest.ChunkSeparators = nil
+		}, []string{"qdrant.host", "query.top_k", "ingest.chunk_separators"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := defaultConfig()
+			tc.modify(cfg)
+			err := cfg.validate()
+			if len(tc.errs) == 0 {
+				if err != nil {
+					t.Errorf("validate() = %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("validate() succeeded, want errors about %v", tc.errs)
+			}
+			for _, want := range tc.errs {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("validate() = %v, want an error about %s", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSetConfigField(t *testing.T) {
+	tests := []struct {
+		key   string
+		value string
+		check func(c *Config) bool
+		err   string
+	}{
+		{key: "qdrant.port", value: " 6400 ", check: func(c *Config) bool { return c.Qdrant.Port == 6400 }},
+		{key: "model.llm_model", value: "Qwen/Qwen3-32B", check: func(c *Config) bool { return c.Model.LLMModel == "Qwen/Qwen3-32B" }},
+		{key: "ingest.chunk_separators", value: `["\n\n", "。"]`, check: func(c *Config) bool {
+			return reflect.DeepEqual(c.Ingest.ChunkSeparators, []string{"\n\n", "。"})
+		}},
+		{key: "ingest.chunk_separators", value: "a,b,c", check: func(c *Config) bool {
+			return reflect.DeepEqual(c.Ingest.ChunkSeparators, []string{"a", "b", "c"})
+		}},
+		{key: "ingest.chunk_separators", value: `["a",`, err: "invalid list"},
+		{key: "query.top_k", value: "many", err: `invalid integer "many"`},
+		{key: "query.top", value: "8", err: `unknown config field "top"`},
+		{key: "search.top_k", value: "8", err: `unknown config field "search"`},
+		{key: "qdrant", value: "x", err: "unsupported field type"},
+		{key: "qdrant.port.number", value: "1", err: "port is not an object"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.key+"="+tc.value, func(t *testing.T) {
+			cfg := defaultConfig()
+			err := setConfigField(reflect.ValueOf(cfg).Elem(), strings.Split(tc.key, "."), tc.value)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("error = %v, want it to contain %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tc.check(cfg) {
+				t.Errorf("field not set: %+v", cfg)
+			}
+		})
+	}
+}
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigLayering(t *testing.T) {
+	path := writeConfigFile(t, "rag.yaml", `
+qdrant:
+  port: 6400
+  collection: from_file
+model:
+  api_key: env:RAG_TEST_KEY
+query:
+  top_k: 3
+`)
+	t.Setenv("RAG_TEST_KEY", "sk-file")
+	t.Setenv(envName("qdrant.collection"), "from_env")
+	t.Setenv(envName("query.top_k"), "6")
+
+	cfg, err := loadConfig(path, []configOverride{{Key: "query.top_k", Value: "9", Source: "flag -set query.top_k"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Qdrant.Host != "localhost" {
+		t.Errorf("host = %q, want the default", cfg.Qdrant.Host)
+	}
+	if cfg.Qdrant.Port != 6400 {
+		t.Errorf("port = %d, want 6400 from the file", cfg.Qdrant.Port)
+	}
+	if cfg.Qdrant.Collection != "from_env" {
+		t.Errorf("collection = %q, want the environment over the file", cfg.Qdrant.Collection)
+	}
+	if cfg.Query.TopK != 9 {
+		t.Errorf("top_k = %d, want the flag over the environment", cfg.Query.TopK)
+	}
+	if cfg.Model.APIKey != "sk-file" {
+		t.Errorf("api key = %q, want the resolved reference", cfg.Model.APIKey)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		file      string
+		content   string
+		overrides []configOverride
+		err       string
+	}{
+		{"unknown YAML field", "rag.yaml", "query:\n  topk: 3\n", nil, "field topk not found"},
+		{"unknown JSON field", "rag.json", `{"query": {"topk": 3}}`, nil, `unknown field "topk"`},
+		{"unknown -set key", "rag.yaml", "", []configOverride{{Key: "query.topk", Value: "3", Source: "flag -set query.topk"}}, `flag -set query.topk: unknown config field "topk"`},
+		{"invalid after overrides", "rag.yaml", "ingest:\n  chunk_size: 100\n", []configOverride{{Key: "ingest.chunk_overlap", Value: "100", Source: "flag"}}, "invalid config: ingest.chunk_overlap"},
+		{"missing secret", "rag.yaml", "model:\n  api_key: env:RAG_TEST_MISSING\n", nil, "model.api_key: environment variable RAG_TEST_MISSING is not set"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := loadConfig(writeConfigFile(t, tc.file, tc.content), tc.overrides)
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("error = %v, want it to contain %q", err, tc.err)
+			}
+		})
+	}
+
+	if _, err := loadConfig(filepath.Join(t.TempDir(), "missing.yaml"), nil); err == nil {
+		t.Error("an explicit config file that does not exist was ignored")
+	}
+}
@@ -28,30 +28,13 @@ import (
 )
 
 // ================== 1. 配置中心 ==================
+// 可调整的参数见 config.go，这里只保留程序内部约定的常量
 const (
-	QdrantHost = "localhost"
-	QdrantPort = 6334
-
-	CollectionName    = "eino_best_practice_kb"
-	VectorDim         = 1024
 	DocMetaDataVector = "embedding_vector" // 用于在 Document.MetaData 中存储向量的键
 	QdrantPayloadKey  = "content"          // 用于在 Qdrant Payload 中存储文档内容的键
+	QdrantSourceKey   = "source"           // 用于在 Qdrant Payload 中存储文档来源的键
 
-	BaseURL        = "https://api.siliconflow.cn/v1" // OpenAI API 基础 URL
-	OpenAIAPIKey   = ""                              // 务必替换为你的 OpenAI API Key
-	EmbeddingModel = "BAAI/bge-m3"
-	LLMModel       = "Qwen/Qwen3-8B"
-	Timeout        = 60 * time.Second
-
-	KnowledgeFilePath  = "knowledge.txt"
-	ChunkSize          = 500
-	ChunkOverlap       = 100
-	TopK               = 5  // 检索时返回的文档数量
-	EmbeddingBatchSize = 32 // Embedding API允许的最大批处理大小
-)
-
-var (
-	ChunkSeparators = []string{"\n\n", "\n", "。", "！", "？", " "}
+	KnowledgeFilePath = "knowledge.txt"
 )
 
 // ================== 2. 自定义组件 ==================
@@ -92,9 +75,11 @@ func (q *QdrantIndexer) Store(ctx context.Context, docs []*schema.Document, opts
 			return nil, fmt.Errorf("embedding vector for doc ID %s is not of type []float64", doc.ID)
 		}
 
-		payloadMap := make(map[string]interface{})
-		payloadMap[QdrantPayloadKey] = doc.Content
-		payload := qdrant.NewValueMap(payloadMap)
+		// 内容和除向量外的全部 MetaData 都写入 Payload，来源写入 source 字段以便按来源列出和删除
+		payload, err := chunkPayload(doc)
+		if err != nil {
+			return nil, fmt.Errorf("building payload for doc %s: %w", doc.ID, err)
+		}
 
 		// 类型转换
 		vector32 := make([]float32, len(vector64))
@@ -185,15 +170,15 @@ func (q *QdrantRetriever) Retrieve(ctx context.Context, query string, opts ...re
 			continue
 		}
 		content := contentValue.GetStringValue()
-		metaData := map[string]interface{}{
-			DocMetaDataVector: float64(hit.Score),
-		}
+		// 还原注入时写入的 MetaData；检索结果中 DocMetaDataVector 存放的是相似度
+		metaData := payloadMetaData(hit.Payload)
+		metaData[DocMetaDataVector] = float64(hit.Score)
 		docs = append(docs, &schema.Document{
 			ID:       hit.GetId().GetUuid(),
 			Content:  content,
 			MetaData: metaData,
 		})
-		fmt.Println("Retrieved document:", content)
+		log.Printf("检索到文档 (相似度: %.4f, 来源: %v): %s", hit.Score, metaData[file.MetaKeySource], content)
 	}
 	return docs, nil
 }
@@ -201,12 +186,14 @@ func (q *QdrantRetriever) Retrieve(ctx context.Context, query string, opts ...re
 // --- 2.3 Embedding Transformer (新增) ---
 // EmbeddingTransformer 是一个文档转换器，用于为文档生成向量并存入MetaData
 type EmbeddingTransformer struct {
-	embedder embedding.Embedder
+	embedder  embedding.Embedder
+	batchSize int
 }
 
-func NewEmbeddingTransformer(embedder embedding.Embedder) *EmbeddingTransformer {
+func NewEmbeddingTransformer(embedder embedding.Embedder, batchSize int) *EmbeddingTransformer {
 	return &EmbeddingTransformer{
-		embedder: embedder,
+		embedder:  embedder,
+		batchSize: batchSize,
 	}
 }
 
@@ -219,11 +206,11 @@ func (t *EmbeddingTransformer) Transform(ctx context.Context, src []*schema.Docu
 	numDocs := len(src)
 	allVectors := make([][]float64, 0, numDocs)
 
-	log.Printf("准备为 %d 个文档块进行向量化（批处理大小：%d）...", numDocs, EmbeddingBatchSize)
+	log.Printf("准备为 %d 个文档块进行向量化（批处理大小：%d）...", numDocs, t.batchSize)
 
 	// 使用循环按批次处理文档
-	for i := 0; i < numDocs; i += EmbeddingBatchSize {
-		end := i + EmbeddingBatchSize
+	for i := 0; i < numDocs; i += t.batchSize {
+		end := i + t.batchSize
 		if end > numDocs {
 			end = numDocs
 		}
@@ -290,63 +277,156 @@ func (l *loggerCallbacks) OnEndWithStreamOutput(ctx context.Context, info *callb
 
 // ================== 4. 核心业务逻辑 (已重构) ==================
 
-// ingestKnowledge 负责将指定文件注入知识库
-func ingestKnowledge(ctx context.Context, qdrantClient *qdrant.Client, embedder embedding.Embedder, filePath string) error {
+// ingestResult 汇总一次注入的结果
+type ingestResult struct {
+	Source    string `json:"source"`
+	Chunks    int    `json:"chunks"`    // 来源当前的文档块数量
+	Embedded  int    `json:"embedded"`  // 新增或需要重新向量化的文档块
+	Unchanged int    `json:"unchanged"` // 内容未变、跳过向量化的文档块
+	Deleted   int    `json:"deleted"`   // 来源中已不存在而被删除的文档块
+	Error     string `json:"error,omitempty"`
+}
+
+// ingestKnowledge 负责将指定文件注入知识库。注入是增量的：文档块 ID 由来源和内容决定，
+// 已存在且内容未变的文档块不会重新向量化，来源中已删除的文档块会从集合中移除。
+// force 为 true 时重新向量化所有文档块。
+func ingestKnowledge(ctx context.Context, cfg *Config, qdrantClient *qdrant.Client, embedder embedding.Embedder, filePath string, force bool) (*ingestResult, error) {
 	log.Println("\n--- 知识注入流程开始 ---")
+	res := &ingestResult{Source: filePath}
+	ingestedAt := time.Now()
+
+	// 1. 读取上次注入的清单和集合中已有的文档块
+	manifest, err := loadManifest(ctx, qdrantClient, cfg, filePath)
+	if err != nil {
+		return nil, err
+	}
+	stored, err := storedChunkIDs(ctx, qdrantClient, cfg, filePath)
+	if err != nil {
+		return nil, err
+	}
+	// Embedding 模型变化后旧向量不可再用
+	reuse := !force && manifest != nil && manifest.EmbeddingModel == cfg.Model.EmbeddingModel
 
-	// 1. 初始化所有需要的组件
+	// 2. 初始化所有需要的组件
 	loader, err := file.NewFileLoader(ctx, &file.FileLoaderConfig{UseNameAsID: false})
 	if err != nil {
-		return fmt.Errorf("创建 FileLoader 失败: %v", err)
+		return nil, fmt.Errorf("创建 FileLoader 失败: %v", err)
 	}
 
 	splitter, err := recursive.NewSplitter(ctx, &recursive.Config{
-		ChunkSize:   ChunkSize,
-		OverlapSize: ChunkOverlap,
-		Separators:  ChunkSeparators,
-		IDGenerator: func(ctx context.Context, originalID string, splitIndex int) string {
-			return uuid.NewString()
-		},
+		ChunkSize:   cfg.Ingest.ChunkSize,
+		OverlapSize: cfg.Ingest.ChunkOverlap,
+		Separators:  cfg.Ingest.ChunkSeparators,
 	})
 	if err != nil {
-		return fmt.Errorf("创建 RecursiveSplitter 失败: %v", err)
-	}
+		return nil, fmt.Errorf("创建 RecursiveSplitter 失败: %v", err)
+	}
+
+	// 为文档块生成确定的 ID，并过滤掉无需重新向量化的文档块
+	var chunkIDs []string
+	var unchanged []*schema.Document
+	diffLambda := compose.InvokableLambda(func(ctx context.Context, docs []*schema.Document) ([]*schema.Document, error) {
+		seen := make(map[string]bool, len(docs))
+		var changed []*schema.Document
+		for _, doc := range docs {
+			hash := contentHash(doc.Content)
+			doc.ID = chunkID(filePath, doc.Content)
+			if seen[doc.ID] {
+				continue // 同一来源中内容完全相同的文档块只保留一份
+			}
+			seen[doc.ID] = true
+			chunkIDs = append(chunkIDs, doc.ID)
+			if doc.MetaData == nil {
+				doc.MetaData = make(map[string]interface{})
+			}
+			doc.MetaData[QdrantContentHashKey] = hash
+			doc.MetaData[MetaKeyIngestedAt] = ingestedAt
+			if reuse && stored[doc.ID] {
+				unchanged = append(unchanged, doc)
+				continue
+			}
+			changed = append(changed, doc)
+		}
+		res.Embedded = len(changed)
+		res.Unchanged = len(unchanged)
+		log.Printf("🧮 共 %d 个文档块，其中 %d 个需要向量化，%d 个未变化", len(chunkIDs), len(changed), res.Unchanged)
+		return changed, nil
+	})
 
 	// 新增的 EmbeddingTransformer
-	embeddingTransformer := NewEmbeddingTransformer(embedder)
+	embeddingTransformer := NewEmbeddingTransformer(embedder, cfg.Ingest.EmbeddingBatchSize)
 
 	// 重构后的 QdrantIndexer
-	indexerComponent := NewQdrantIndexer(qdrantClient, CollectionName)
+	indexerComponent := NewQdrantIndexer(qdrantClient, cfg.Qdrant.Collection)
 
-	// 2. 构建并编排注入链
+	// 3. 构建并编排注入链
 	ingestionChain := compose.NewChain[document.Source, []string]()
 	ingestionChain.AppendLoader(loader)
-	ingestionChain.AppendDocumentTransformer(splitter)
+	ingestionChain.AppendDocumentTransformer(NewChunkMetadataTransformer(splitter)) // 分割并补充序号、字符位置等元数据
+	ingestionChain.AppendLambda(diffLambda)
 	ingestionChain.AppendDocumentTransformer(embeddingTransformer) // 在 Indexer 之前进行 embedding
 	ingestionChain.AppendIndexer(indexerComponent)
 
 	runnable, err := ingestionChain.Compile(ctx)
 	if err != nil {
-		return fmt.Errorf("编译 Ingestion Chain 失败: %v", err)
+		return nil, fmt.Errorf("编译 Ingestion Chain 失败: %v", err)
 	}
 
-	// 3. 执行链
+	// 4. 执行链
 	log.Printf("📚 正在从 %s 加载、分割、向量化和索引知识...", filePath)
-	_, err = runnable.Invoke(ctx, document.Source{URI: filePath})
+	if _, err := runnable.Invoke(ctx, document.Source{URI: filePath}); err != nil {
+		return nil, fmt.Errorf("执行 Ingestion Chain 失败: %v", err)
+	}
+	res.Chunks = len(chunkIDs)
+
+	// 未重新向量化的文档块只更新 Payload，使序号、字符位置等与本次注入一致
+	if err := refreshPayloads(ctx, qdrantClient, cfg.Qdrant.Collection, unchanged); err != nil {
+		return nil, fmt.Errorf("更新文档块元数据失败: %v", err)
+	}
+
+	// 5. 删除来源中已不存在的文档块，再更新清单
+	current := make(map[string]bool, len(chunkIDs))
+	for _, id := range chunkIDs {
+		current[id] = true
+	}
+	var removed []string
+	for id := range stored {
+		if !current[id] {
+			removed = append(removed, id)
+		}
+	}
+	// 清单中的文档块若未写入来源字段，也一并清理
+	if manifest != nil {
+		for _, id := range manifest.ChunkIDs {
+			if !current[id] && !stored[id] {
+				removed = append(removed, id)
+			}
+		}
+	}
+	if err := deleteChunks(ctx, qdrantClient, cfg, removed); err != nil {
+		return nil, fmt.Errorf("删除过期文档块失败: %v", err)
+	}
+	res.Deleted = len(removed)
+	err = saveManifest(ctx, qdrantClient, cfg, &sourceManifest{
+		Source:         filePath,
+		ChunkIDs:       chunkIDs,
+		EmbeddingModel: cfg.Model.EmbeddingModel,
+		IngestedAt:     ingestedAt,
+	})
 	if err != nil {
-		return fmt.Errorf("执行 Ingestion Chain 失败: %v", err)
+		return nil, err
 	}
 
-	log.Println("--- ✅ 知识注入流程成功 ---")
-	return nil
+	log.Printf("--- ✅ 知识注入流程成功：%d 个文档块，向量化 %d 个，跳过 %d 个，删除 %d 个 ---", res.Chunks, res.Embedded, res.Unchanged, res.Deleted)
+	return res, nil
 }
 
-// answerQuery 负责根据用户问题，从知识库检索并生成答案
-func answerQuery(ctx context.Context, llm model.ToolCallingChatModel, qdrantClient *qdrant.Client, embedder embedding.Embedder, userQuery string) (string, error) {
+// answerQuery 负责根据用户问题，从知识库检索并生成答案，返回的答案附带引用的文档块
+func answerQuery(ctx context.Context, cfg *Config, llm model.ToolCallingChatModel, qdrantClient *qdrant.Client, embedder embedding.Embedder, userQuery string) (*ragAnswer, error) {
 	log.Println("\n--- RAG 问答流程开始 ---")
 
 	// 1. 初始化 Retriever
-	ragRetriever := NewQdrantRetriever(qdrantClient, CollectionName, embedder, uint64(TopK))
+	ragRetriever := NewQdrantRetriever(qdrantClient, cfg.Qdrant.Collection, embedder, uint64(cfg.Query.TopK))
 
 	// 2. 构建 RAG 图
 	ragGraph := compose.NewGraph[map[string]interface{}, *schema.Message]()
@@ -358,6 +438,8 @@ func answerQuery(ctx context.Context, llm model.ToolCallingChatModel, qdrantClie
 	)
 
 	// 2.2 准备提示词输入节点 (Lambda): 执行自定义的数据格式化逻辑，仍然是必需的
+	// 上下文按顺序编号，回答中的 [n] 引用对应 passages[n-1]
+	var passages []*schema.Document
 	preparePromptInputLambda := compose.InvokableLambda(
 		func(ctx context.Context, input map[string]interface{}) (map[string]interface{}, error) {
 			docsVal, ok := input["documents"]
@@ -378,11 +460,11 @@ func answerQuery(ctx context.Context, llm model.ToolCallingChatModel, qdrantClie
 			} else {
 				b.WriteString("请参考以下上下文信息：\n\n")
 				for i, doc := range docs {
-					scoreVal, _ := doc.MetaData[DocMetaDataVector]
-					score, _ := scoreVal.(float64)
-					b.WriteString(fmt.Sprintf("--- 上下文 %d (相似度: %.4f) ---\n%s\n\n", i+1, score, doc.Content))
+					chunk := newCitedChunk(i+1, doc)
+					b.WriteString(fmt.Sprintf("--- [%d] 来源: %s (相似度: %.4f) ---\n%s\n\n", chunk.Ref, chunk.sourceRef(), chunk.Score, doc.Content))
 				}
 			}
+			passages = docs
 			return map[string]interface{}{
 				"context_str": b.String(),
 				"query":       queryVal,
@@ -393,7 +475,9 @@ func answerQuery(ctx context.Context, llm model.ToolCallingChatModel, qdrantClie
 
 	// 2.3 提示词模板节点
 	template := prompt.FromMessages(schema.FString,
-		schema.SystemMessage("你是一个智能问答助手。请根据下面提供的上下文来回答问题。如果上下文中没有相关信息，就明确说你不知道，不要编造答案。"),
+		schema.SystemMessage("你是一个智能问答助手。请根据下面提供的上下文来回答问题。如果上下文中没有相关信息，就明确说你不知道，不要编造答案。"+
+			"上下文按 [1]、[2] 这样编号，回答中每个来自上下文的说法都要在句末用对应的编号标注来源，例如“Eino 是一个开源框架 [1]。”；"+
+			"一句话有多个来源时写成 [1][3]，只能使用给出的编号。"),
 		schema.UserMessage("上下文：\n{context_str}\n---\n问题：{query}"),
 	)
 	ragGraph.AddChatTemplateNode("prompt_template", template)
@@ -412,31 +496,35 @@ func answerQuery(ctx context.Context, llm model.ToolCallingChatModel, qdrantClie
 	// 3. 编译图
 	runnable, err := ragGraph.Compile(ctx, compose.WithNodeTriggerMode(compose.AllPredecessor))
 	if err != nil {
-		return "", fmt.Errorf("编译 RAG Graph 失败: %v", err)
+		return nil, fmt.Errorf("编译 RAG Graph 失败: %v", err)
 	}
 
 	log.Printf("🔍 正在查询: %s", userQuery)
 	input := map[string]interface{}{"query": userQuery}
 	response, err := runnable.Invoke(ctx, input)
 	if err != nil {
-		return "", fmt.Errorf("执行 RAG Graph 失败: %v", err)
+		return nil, fmt.Errorf("执行 RAG Graph 失败: %v", err)
 	}
 
 	log.Printf("✅ RAG 回答: %s", response.Content)
+	answer := parseCitations(response.Content, passages)
+	for _, w := range answer.Warnings {
+		log.Printf("⚠️ 引用检查: %s", w)
+	}
 	log.Println("--- RAG 问答流程结束 ---")
-	return response.Content, nil
+	return answer, nil
 }
 
 // ================== 5. 设置与主函数 (已重构) ==================
 
 // setupComponents 负责初始化所有外部依赖的客户端和组件
-func setupComponents(ctx context.Context) (model.ToolCallingChatModel, embedding.Embedder, *qdrant.Client, error) {
+func setupComponents(ctx context.Context, cfg *Config) (model.ToolCallingChatModel, embedding.Embedder, *qdrant.Client, error) {
 	// 初始化 LLM
 	llm, err := eino_openai.NewChatModel(ctx, &eino_openai.ChatModelConfig{
-		BaseURL: BaseURL,
-		APIKey:  OpenAIAPIKey,
-		Model:   LLMModel,
-		Timeout: Timeout,
+		BaseURL: cfg.Model.BaseURL,
+		APIKey:  cfg.Model.APIKey,
+		Model:   cfg.Model.LLMModel,
+		Timeout: cfg.Model.timeout(),
 	})
 	if err != nil {
 		return nil, nil, nil, fmt.Errorf("❌ 初始化 LLM 失败: %v", err)
@@ -444,10 +532,10 @@ func setupComponents(ctx context.Context) (model.ToolCallingChatModel, embedding
 
 	// 初始化 Embedder
 	embedder, err := openai.NewEmbedder(ctx, &openai.EmbeddingConfig{
-		BaseURL: BaseURL,
-		APIKey:  OpenAIAPIKey,
-		Model:   EmbeddingModel,
-		Timeout: Timeout,
+		BaseURL: cfg.Model.BaseURL,
+		APIKey:  cfg.Model.APIKey,
+		Model:   cfg.Model.EmbeddingModel,
+		Timeout: cfg.Model.timeout(),
 	})
 	if err != nil {
 		return nil, nil, nil, fmt.Errorf("❌ 初始化 Embedder 失败: %v", err)
@@ -455,26 +543,26 @@ func setupComponents(ctx context.Context) (model.ToolCallingChatModel, embedding
 
 	// 初始化 Qdrant 客户端
 	qdrantClient, err := qdrant.NewClient(&qdrant.Config{
-		Host: QdrantHost,
-		Port: QdrantPort,
+		Host: cfg.Qdrant.Host,
+		Port: cfg.Qdrant.Port,
 	})
 	if err != nil {
 		return nil, nil, nil, fmt.Errorf("❌ 连接 Qdrant 失败: %v", err)
 	}
 
 	// 检查并创建 Qdrant 集合
-	exists, err := qdrantClient.CollectionExists(ctx, CollectionName)
+	exists, err := qdrantClient.CollectionExists(ctx, cfg.Qdrant.Collection)
 	if err != nil {
 		qdrantClient.Close()
 		return nil, nil, nil, fmt.Errorf("❌ 检查集合是否存在时出错: %v", err)
 	}
 
 	if !exists {
-		log.Printf("📁 集合 '%s' 不存在，正在创建...", CollectionName)
+		log.Printf("📁 集合 '%s' 不存在，正在创建...", cfg.Qdrant.Collection)
 		err = qdrantClient.CreateCollection(ctx, &qdrant.CreateCollection{
-			CollectionName: CollectionName,
+			CollectionName: cfg.Qdrant.Collection,
 			VectorsConfig: qdrant.NewVectorsConfig(&qdrant.VectorParams{
-				Size:     uint64(VectorDim),
+				Size:     uint64(cfg.Qdrant.VectorDim),
 				Distance: qdrant.Distance_Cosine,
 			}),
 		})
@@ -482,9 +570,24 @@ func setupComponents(ctx context.Context) (model.ToolCallingChatModel, embedding
 			qdrantClient.Close()
 			return nil, nil, nil, fmt.Errorf("❌ 创建集合失败: %v", err)
 		}
-		log.Printf("✅ 集合 '%s' 创建成功", CollectionName)
+		log.Printf("✅ 集合 '%s' 创建成功", cfg.Qdrant.Collection)
 	} else {
-		log.Printf("🔁 集合 '%s' 已存在", CollectionName)
+		// 已有集合的向量维度必须与配置一致，否则写入和检索都会失败
+		info, err := qdrantClient.GetCollectionInfo(ctx, cfg.Qdrant.Collection)
+		if err != nil {
+			qdrantClient.Close()
+			return nil, nil, nil, fmt.Errorf("❌ 获取集合信息失败: %v", err)
+		}
+		if size := info.GetConfig().GetParams().GetVectorsConfig().GetParams().GetSize(); size != 0 && size != uint64(cfg.Qdrant.VectorDim) {
+			qdrantClient.Close()
+			return nil, nil, nil, fmt.Errorf("❌ 集合 '%s' 的向量维度为 %d，与配置的 qdrant.vector_dim (%d) 不一致", cfg.Qdrant.Collection, size, cfg.Qdrant.VectorDim)
+		}
+		log.Printf("🔁 集合 '%s' 已存在", cfg.Qdrant.Collection)
+	}
+
+	if err := ensureManifestCollection(ctx, qdrantClient, cfg); err != nil {
+		qdrantClient.Close()
+		return nil, nil, nil, fmt.Errorf("❌ 创建清单集合失败: %v", err)
 	}
 
 	return llm, embedder, qdrantClient, nil
@@ -500,27 +603,5 @@ func prepareKnowledgeFile() {
 }
 
 func main() {
-	ctx := context.Background()
-	callbacks.AppendGlobalHandlers(&loggerCallbacks{})
-	prepareKnowledgeFile()
-
-	// 1. 初始化所有组件
-	llm, embedder, qdrantClient, err := setupComponents(ctx)
-	if err != nil {
-		log.Fatalf("%s", err.Error())
-	}
-	defer qdrantClient.Close()
-
-	// 2. 执行知识注入流程
-	err = ingestKnowledge(ctx, qdrantClient, embedder, KnowledgeFilePath)
-	if err != nil {
-		log.Fatalf("知识注入失败: %v", err)
-	}
-
-	// 3. 执行问答流程
-	userQuestion := "Eino 框架是什么？它有什么特点？"
-	_, err = answerQuery(ctx, llm, qdrantClient, embedder, userQuestion)
-	if err != nil {
-		log.Fatalf("问答查询失败: %v", err)
-	}
+	os.Exit(runCLI(context.Background(), os.Args[1:]))
 }
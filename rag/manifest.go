@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/qdrant/go-client/qdrant"
+)
+
+// ================== 增量注入 ==================
+
+// 文档块的 ID 由来源和内容哈希生成（UUIDv5），同一来源中内容不变的文档块
+// 每次注入都得到相同的 ID，重复注入不会产生重复的点。
+var chunkIDNamespace = uuid.MustParse("6f1d3b8e-5c2a-4e07-9a41-2b7d9c0e8f53")
+
+const (
+	QdrantContentHashKey = "content_hash" // 用于在 Qdrant Payload 中存储文档块内容哈希的键
+
+	// 清单集合只保存 Payload，向量只有一维且固定为 1
+	manifestCollectionSuffix = "_manifests"
+)
+
+// chunkID 返回来源 source 中内容为 content 的文档块的 ID
+func chunkID(source, content string) string {
+	return uuid.NewSHA1(chunkIDNamespace, []byte(source+"\x00"+contentHash(content))).String()
+}
+
+func contentHash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// manifestID 返回来源清单的点 ID
+func manifestID(source string) string {
+	return uuid.NewSHA1(chunkIDNamespace, []byte("manifest\x00"+source)).String()
+}
+
+// manifestCollection 返回保存来源清单的集合名
+func (c *QdrantConfig) manifestCollection() string {
+	return c.Collection + manifestCollectionSuffix
+}
+
+// sourceManifest 记录一个来源最近一次注入的结果
+type sourceManifest struct {
+	Source         string
+	ChunkIDs       []string
+	EmbeddingModel string // 模型改变后所有文档块都要重新向量化
+	IngestedAt     time.Time
+}
+
+// ensureManifestCollection 在清单集合不存在时创建它
+func ensureManifestCollection(ctx context.Context, client *qdrant.Client, cfg *Config) error {
+	name := cfg.Qdrant.manifestCollection()
+	exists, err := client.CollectionExists(ctx, name)
+	if err != nil || exists {
+		return err
+	}
+	log.Printf("📁 清单集合 '%s' 不存在，正在创建...", name)
+	return client.CreateCollection(ctx, &qdrant.CreateCollection{
+		CollectionName: name,
+		VectorsConfig: qdrant.NewVectorsConfig(&qdrant.VectorParams{
+			Size:     1,
+			Distance: qdrant.Distance_Dot,
+		}),
+	})
+}
+
+// loadManifest 读取来源的清单，来源从未注入过时返回 nil
+func loadManifest(ctx context.Context, client *qdrant.Client, cfg *Config, source string) (*sourceManifest, error) {
+	points, err := client.Get(ctx, &qdrant.GetPoints{
+		CollectionName: cfg.Qdrant.manifestCollection(),
+		Ids:            []*qdrant.PointId{qdrant.NewIDUUID(manifestID(source))},
+		WithPayload:    qdrant.NewWithPayload(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading manifest of %s: %w", source, err)
+	}
+	if len(points) == 0 {
+		return nil, nil
+	}
+	payload := points[0].Payload
+	m := &sourceManifest{
+		Source:         source,
+		EmbeddingModel: payload["embedding_model"].GetStringValue(),
+	}
+	for _, v := range payload["chunk_ids"].GetListValue().GetValues() {
+		m.ChunkIDs = append(m.ChunkIDs, v.GetStringValue())
+	}
+	m.IngestedAt, _ = time.Parse(time.RFC3339, payload["ingested_at"].GetStringValue())
+	return m, nil
+}
+
+// saveManifest 写入来源的清单
+func saveManifest(ctx context.Context, client *qdrant.Client, cfg *Config, m *sourceManifest) error {
+	ids := make([]any, len(m.ChunkIDs))
+	for i, id := range m.ChunkIDs {
+		ids[i] = id
+	}
+	_, err := client.Upsert(ctx, &qdrant.UpsertPoints{
+		CollectionName: cfg.Qdrant.manifestCollection(),
+		Wait:           qdrant.PtrOf(true),
+		Points: []*qdrant.PointStruct{{
+			Id:      qdrant.NewIDUUID(manifestID(m.Source)),
+			Vectors: qdrant.NewVectors(1),
+			Payload: qdrant.NewValueMap(map[string]any{
+				QdrantSourceKey:   m.Source,
+				"chunk_ids":       ids,
+				"chunk_count":     len(m.ChunkIDs),
+				"embedding_model": m.EmbeddingModel,
+				"ingested_at":     m.IngestedAt.UTC().Format(time.RFC3339),
+			}),
+		}},
+	})
+	if err != nil {
+		return fmt.Errorf("writing manifest of %s: %w", m.Source, err)
+	}
+	return nil
+}
+
+// deleteManifest 删除来源的清单
+func deleteManifest(ctx context.Context, client *qdrant.Client, cfg *Config, source string) error {
+	_, err := client.Delete(ctx, &qdrant.DeletePoints{
+		CollectionName: cfg.Qdrant.manifestCollection(),
+		Points:         qdrant.NewPointsSelector(qdrant.NewIDUUID(manifestID(source))),
+		Wait:           qdrant.PtrOf(true),
+	})
+	if err != nil {
+		return fmt.Errorf("deleting manifest of %s: %w", source, err)
+	}
+	return nil
+}
+
+// manifestChunkCounts 读取所有来源清单，返回每个来源的文档块数量
+func manifestChunkCounts(ctx context.Context, client *qdrant.Client, cfg *Config) (map[string]int, error) {
+	counts := make(map[string]int)
+	var offset *qdrant.PointId
+	for {
+		points, next, err := client.ScrollAndOffset(ctx, &qdrant.ScrollPoints{
+			CollectionName: cfg.Qdrant.manifestCollection(),
+			Offset:         offset,
+			Limit:          qdrant.PtrOf(uint32(scrollPageSize)),
+			WithPayload:    qdrant.NewWithPayloadInclude(QdrantSourceKey, "chunk_count"),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("reading manifests: %w", err)
+		}
+		for _, p := range points {
+			counts[p.Payload[QdrantSourceKey].GetStringValue()] = int(p.Payload["chunk_count"].GetIntegerValue())
+		}
+		if next == nil {
+			return counts, nil
+		}
+		offset = next
+	}
+}
+
+// storedChunkIDs 返回集合中属于来源 source 的所有点的 ID
+func storedChunkIDs(ctx context.Context, client *qdrant.Client, cfg *Config, source string) (map[string]bool, error) {
+	ids := make(map[string]bool)
+	var offset *qdrant.PointId
+	for {
+		points, next, err := client.ScrollAndOffset(ctx, &qdrant.ScrollPoints{
+			CollectionName: cfg.Qdrant.Collection,
+			Filter:         &qdrant.Filter{Must: []*qdrant.Condition{qdrant.NewMatchKeyword(QdrantSourceKey, source)}},
+			Offset:         offset,
+			Limit:          qdrant.PtrOf(uint32(scrollPageSize)),
+			WithPayload:    qdrant.NewWithPayload(false),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("listing chunks of %s: %w", source, err)
+		}
+		for _, p := range points {
+			ids[p.GetId().GetUuid()] = true
+		}
+		if next == nil {
+			return ids, nil
+		}
+		offset = next
+	}
+}
+
+// deleteChunks 按 ID 删除文档块
+func deleteChunks(ctx context.Context, client *qdrant.Client, cfg *Config, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	pointIDs := make([]*qdrant.PointId, len(ids))
+	for i, id := range ids {
+		pointIDs[i] = qdrant.NewIDUUID(id)
+	}
+	_, err := client.Delete(ctx, &qdrant.DeletePoints{
+		CollectionName: cfg.Qdrant.Collection,
+		Points:         qdrant.NewPointsSelector(pointIDs...),
+		Wait:           qdrant.PtrOf(true),
+	})
+	return err
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/cloudwego/eino-ext/components/document/loader/file"
+	"github.com/cloudwego/eino/components/document"
+	"github.com/cloudwego/eino/schema"
+	"github.com/qdrant/go-client/qdrant"
+)
+
+// ================== 文档块元数据 ==================
+
+// 除向量外，文档块 MetaData 中的字段都会写入 Qdrant Payload，检索时再映射回 MetaData。
+// 以下是注入时为每个文档块补充的标准字段，在 MetaData 和 Payload 中使用相同的键。
+const (
+	MetaKeyChunkIndex = "chunk_index" // 文档块在来源中的序号，从 0 开始
+	MetaKeyCharStart  = "char_start"  // 文档块在来源中的起始位置，按字符（而非字节）计
+	MetaKeyCharEnd    = "char_end"    // 文档块在来源中的结束位置（不含）
+	MetaKeyHeadings   = "headings"    // Markdown 文档中文档块所在的各级标题
+	MetaKeyIngestedAt = "ingested_at" // 文档块最近一次写入的时间
+
+	// 一次批量更新 Payload 的文档块数量
+	payloadBatchSize = 256
+)
+
+// ChunkMetadataTransformer 包装文档分割器，为分割出的每个文档块补充序号、字符位置和所在标题
+type ChunkMetadataTransformer struct {
+	splitter document.Transformer
+}
+
+func NewChunkMetadataTransformer(splitter document.Transformer) *ChunkMetadataTransformer {
+	return &ChunkMetadataTransformer{splitter: splitter}
+}
+
+// Transform 实现了 document.Transformer 接口
+func (t *ChunkMetadataTransformer) Transform(ctx context.Context, src []*schema.Document, opts ...document.TransformerOption) ([]*schema.Document, error) {
+	var out []*schema.Document
+	for _, doc := range src {
+		// 逐个文档分割，才能在原文中定位每个文档块
+		chunks, err := t.splitter.Transform(ctx, []*schema.Document{doc}, opts...)
+		if err != nil {
+			return nil, err
+		}
+		var headings []markdownHeading
+		if isMarkdown(doc) {
+			headings = parseHeadings(doc.Content)
+		}
+		runes := newRuneCounter(doc.Content)
+		from := 0 // 相邻文档块可能重叠，从上一个文档块的起点之后继续查找
+		for i, chunk := range chunks {
+			if chunk.MetaData == nil {
+				chunk.MetaData = make(map[string]interface{})
+			}
+			chunk.MetaData[MetaKeyChunkIndex] = i
+			start := strings.Index(doc.Content[from:], chunk.Content)
+			if start >= 0 {
+				start += from
+			} else if start = strings.Index(doc.Content, chunk.Content); start < 0 {
+				continue // 分割器改写了内容，无法定位
+			}
+			// 内容重复的相邻文档块不能定位到同一位置
+			from = min(start+1, len(doc.Content))
+			chunk.MetaData[MetaKeyCharStart] = runes.at(start)
+			chunk.MetaData[MetaKeyCharEnd] = runes.at(start + len(chunk.Content))
+			if h := headingsAt(headings, start); len(h) > 0 {
+				chunk.MetaData[MetaKeyHeadings] = h
+			}
+		}
+		out = append(out, chunks...)
+	}
+	return out, nil
+}
+
+// runeCounter 把字节位置换算为字符位置。查询的位置大致递增，从上次的结果继续计数。
+type runeCounter struct {
+	text       string
+	byteOffset int
+	runeOffset int
+}
+
+func newRuneCounter(text string) *runeCounter {
+	return &runeCounter{text: text}
+}
+
+func (c *runeCounter) at(byteOffset int) int {
+	if byteOffset < c.byteOffset {
+		c.byteOffset, c.runeOffset = 0, 0
+	}
+	c.runeOffset += utf8.RuneCountInString(c.text[c.byteOffset:byteOffset])
+	c.byteOffset = byteOffset
+	return c.runeOffset
+}
+
+func isMarkdown(doc *schema.Document) bool {
+	ext, _ := doc.MetaData[file.MetaKeyExtension].(string)
+	switch strings.ToLower(ext) {
+	case ".md", ".markdown":
+		return true
+	}
+	return false
+}
+
+type markdownHeading struct {
+	offset int // 标题行在文档中的字节位置
+	level  int
+	text   string
+}
+
+// parseHeadings 找出 Markdown 文档中的 ATX 标题（# 开头的行），跳过代码块
+func parseHeadings(content string) []markdownHeading {
+	var headings []markdownHeading
+	inFence := false
+	offset := 0
+	for _, line := range strings.SplitAfter(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "```"), strings.HasPrefix(trimmed, "~~~"):
+			inFence = !inFence
+		case !inFence:
+			level := 0
+			for level < len(trimmed) && trimmed[level] == '#' {
+				level++
+			}
+			if level == 0 || level > 6 || (level < len(trimmed) && trimmed[level] != ' ' && trimmed[level] != '\t') {
+				break
+			}
+			text := strings.TrimSpace(trimmed[level:])
+			// 去掉可选的结尾 #，例如 "## 标题 ##"
+			if s := strings.TrimRight(text, "#"); s != text && (s == "" || strings.HasSuffix(s, " ")) {
+				text = strings.TrimSpace(s)
+			}
+			if text != "" {
+				headings = append(headings, markdownHeading{offset: offset, level: level, text: text})
+			}
+		}
+		offset += len(line)
+	}
+	return headings
+}
+
+// headingsAt 返回字节位置 pos 所在的各级标题，从最高级到最低级
+func headingsAt(headings []markdownHeading, pos int) []string {
+	var stack []markdownHeading
+	for _, h := range headings {
+		if h.offset > pos {
+			break
+		}
+		for len(stack) > 0 && stack[len(stack)-1].level >= h.level {
+			stack = stack[:len(stack)-1]
+		}
+		stack = append(stack, h)
+	}
+	texts := make([]string, len(stack))
+	for i, h := range stack {
+		texts[i] = h.text
+	}
+	return texts
+}
+
+// chunkPayload 把文档块的内容和 MetaData 转换为 Qdrant Payload。
+// 来源写入 source 字段；向量以及与 content、source 重名的字段不写入。
+func chunkPayload(doc *schema.Document) (map[string]*qdrant.Value, error) {
+	payload := map[string]*qdrant.Value{
+		QdrantPayloadKey: qdrant.NewValueString(doc.Content),
+	}
+	for k, v := range doc.MetaData {
+		key := k
+		switch k {
+		case DocMetaDataVector, QdrantPayloadKey, QdrantSourceKey:
+			continue
+		case file.MetaKeySource:
+			key = QdrantSourceKey
+		}
+		value, err := payloadValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("metadata %q: %w", k, err)
+		}
+		payload[key] = value
+	}
+	return payload, nil
+}
+
+// payloadMetaData 是 chunkPayload 的逆过程，返回 Payload 中除内容以外的字段。
+// 整数读回为 int64，列表为 []any，对象为 map[string]any，时间为 RFC 3339 字符串。
+func payloadMetaData(payload map[string]*qdrant.Value) map[string]interface{} {
+	metaData := make(map[string]interface{}, len(payload))
+	for k, v := range payload {
+		switch k {
+		case QdrantPayloadKey:
+			continue
+		case QdrantSourceKey:
+			k = file.MetaKeySource
+		}
+		metaData[k] = valueOf(v)
+	}
+	return metaData
+}
+
+// payloadValue 把 MetaData 中的值转换为 Qdrant 的值。除 qdrant.NewValue 支持的类型外，
+// 还支持所有整数类型、time.Time、指针、任意元素类型的切片和以字符串为键的 map；
+// 其他类型（例如结构体）先按 JSON 编码再转换，无法编码时返回错误。
+func payloadValue(v any) (*qdrant.Value, error) {
+	switch v := v.(type) {
+	case nil:
+		return qdrant.NewValueNull(), nil
+	case *qdrant.Value:
+		return v, nil
+	case time.Time:
+		return qdrant.NewValueString(v.UTC().Format(time.RFC3339)), nil
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return qdrant.NewValueInt(n), nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return nil, err
+		}
+		return qdrant.NewValueDouble(f), nil
+	case []byte:
+		return qdrant.NewValue(v) // Base64 编码为字符串
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Bool:
+		return qdrant.NewValueBool(rv.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return qdrant.NewValueInt(rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u := rv.Uint()
+		if u > math.MaxInt64 {
+			return nil, fmt.Errorf("integer %d overflows int64", u)
+		}
+		return qdrant.NewValueInt(int64(u)), nil
+	case reflect.Float32, reflect.Float64:
+		return qdrant.NewValueDouble(rv.Float()), nil
+	case reflect.String:
+		if !utf8.ValidString(rv.String()) {
+			return nil, fmt.Errorf("invalid UTF-8 in string %q", rv.String())
+		}
+		return qdrant.NewValueString(rv.String()), nil
+	case reflect.Pointer, reflect.Interface:
+		if rv.IsNil() {
+			return qdrant.NewValueNull(), nil
+		}
+		return payloadValue(rv.Elem().Interface())
+	case reflect.Slice, reflect.Array:
+		if rv.Kind() == reflect.Slice && rv.IsNil() {
+			return qdrant.NewValueNull(), nil
+		}
+		list := &qdrant.ListValue{Values: make([]*qdrant.Value, rv.Len())}
+		for i := range list.Values {
+			value, err := payloadValue(rv.Index(i).Interface())
+			if err != nil {
+				return nil, fmt.Errorf("[%d]: %w", i, err)
+			}
+			list.Values[i] = value
+		}
+		return qdrant.NewValueList(list), nil
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			break
+		}
+		if rv.IsNil() {
+			return qdrant.NewValueNull(), nil
+		}
+		fields := make(map[string]*qdrant.Value, rv.Len())
+		for it := rv.MapRange(); it.Next(); {
+			key := it.Key().String()
+			if !utf8.ValidString(key) {
+				return nil, fmt.Errorf("invalid UTF-8 in key %q", key)
+			}
+			value, err := payloadValue(it.Value().Interface())
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", key, err)
+			}
+			fields[key] = value
+		}
+		return qdrant.NewValueStruct(&qdrant.Struct{Fields: fields}), nil
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported type %T: %w", v, err)
+	}
+	var decoded any
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&decoded); err != nil {
+		return nil, fmt.Errorf("unsupported type %T: %w", v, err)
+	}
+	return payloadValue(decoded)
+}
+
+// valueOf 把 Qdrant 的值转换为 Go 的值
+func valueOf(v *qdrant.Value) any {
+	switch kind := v.GetKind().(type) {
+	case *qdrant.Value_BoolValue:
+		return kind.BoolValue
+	case *qdrant.Value_IntegerValue:
+		return kind.IntegerValue
+	case *qdrant.Value_DoubleValue:
+		return kind.DoubleValue
+	case *qdrant.Value_StringValue:
+		return kind.StringValue
+	case *qdrant.Value_ListValue:
+		list := make([]any, len(kind.ListValue.GetValues()))
+		for i, item := range kind.ListValue.GetValues() {
+			list[i] = valueOf(item)
+		}
+		return list
+	case *qdrant.Value_StructValue:
+		fields := make(map[string]any, len(kind.StructValue.GetFields()))
+		for k, item := range kind.StructValue.GetFields() {
+			fields[k] = valueOf(item)
+		}
+		return fields
+	}
+	return nil
+}
+
+// refreshPayloads 用文档块当前的 MetaData 覆盖已存储的 Payload，不改动向量。
+// 内容未变的文档块在来源中的位置可能变化，需要更新序号和字符位置。
+func refreshPayloads(ctx context.Context, client *qdrant.Client, collection string, docs []*schema.Document) error {
+	for i := 0; i < len(docs); i += payloadBatchSize {
+		end := min(i+payloadBatchSize, len(docs))
+		ops := make([]*qdrant.PointsUpdateOperation, 0, end-i)
+		for _, doc := range docs[i:end] {
+			payload, err := chunkPayload(doc)
+			if err != nil {
+				return fmt.Errorf("building payload for doc %s: %w", doc.ID, err)
+			}
+			ops = append(ops, qdrant.NewPointsUpdateOverwritePayload(&qdrant.PointsUpdateOperation_OverwritePayload{
+				Payload:        payload,
+				PointsSelector: qdrant.NewPointsSelector(qdrant.NewIDUUID(doc.ID)),
+			}))
+		}
+		_, err := client.UpdateBatch(ctx, &qdrant.UpdateBatchPoints{
+			CollectionName: collection,
+			Wait:           qdrant.PtrOf(true),
+			Operations:     ops,
+		})
+		if err != nil {
+			return fmt.Errorf("updating payloads in Qdrant: %w", err)
+		}
+	}
+	return nil
+}
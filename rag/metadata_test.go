@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/eino-ext/components/document/loader/file"
+	"github.com/cloudwego/eino-ext/components/document/transformer/splitter/recursive"
+	"github.com/cloudwego/eino/components/document"
+	"github.com/cloudwego/eino/schema"
+)
+
+func TestPayloadValueRoundTrip(t *testing.T) {
+	type item struct {
+		Name  string    `json:"name"`
+		Count uint8     `json:"count"`
+		Tags  []string  `json:"tags"`
+		When  time.Time `json:"when"`
+		skip  int
+	}
+	n := 42
+	var nilPtr *int
+	when := time.Date(2024, 5, 6, 15, 4, 5, 0, time.FixedZone("CST", 8*3600))
+
+	tests := []struct {
+		name string
+		in   any
+		want any
+	}{
+		{"nil", nil, nil},
+		{"bool", true, true},
+		{"int", 7, int64(7)},
+		{"int8", int8(-8), int64(-8)},
+		{"int16", int16(-16), int64(-16)},
+		{"int32", int32(32), int64(32)},
+		{"int64", int64(math.MinInt64), int64(math.MinInt64)},
+		{"uint8", uint8(255), int64(255)},
+		{"uint64 max int64", uint64(math.MaxInt64), int64(math.MaxInt64)},
+		{"float32", float32(1.5), 1.5},
+		{"json integer", json.Number("12"), int64(12)},
+		{"json float", json.Number("1.25"), 1.25},
+		{"string", "中文 text", "中文 text"},
+		{"bytes", []byte("hi"), "aGk="},
+		{"time", when, "2024-05-06T07:04:05Z"},
+		{"pointer", &n, int64(42)},
+		{"nil pointer", nilPtr, nil},
+		{"nil slice", []string(nil), nil},
+		{"int slice", []int{1, 2}, []any{int64(1), int64(2)}},
+		{"array", [2]uint16{3, 4}, []any{int64(3), int64(4)}},
+		{"nested slices", [][]string{{"a"}, {"b", "c"}, {}}, []any{[]any{"a"}, []any{"b", "c"}, []any{}}},
+		{"nested maps", map[string]any{
+			"list": []any{1, map[string]int{"b": 2}},
+			"map":  map[string][]float64{"x": {0.5}},
+		}, map[string]any{
+			"list": []any{int64(1), map[string]any{"b": int64(2)}},
+			"map":  map[string]any{"x": []any{0.5}},
+		}},
+		{"non-string keys via JSON", map[int]string{1: "one"}, map[string]any{"1": "one"}},
+		{"struct via JSON", item{Name: "标题", Count: 3, Tags: []string{"a"}, When: when.UTC(), skip: 1}, map[string]any{
+			"name":  "标题",
+			"count": int64(3),
+			"tags":  []any{"a"},
+			"when":  "2024-05-06T07:04:05Z",
+		}},
+		{"struct pointer in slice", []*item{{Name: "x"}}, []any{map[string]any{
+			"name":  "x",
+			"count": int64(0),
+			"tags":  nil,
+			"when":  "0001-01-01T00:00:00Z",
+		}}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			v, err := payloadValue(tc.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := valueOf(v); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("round trip = %#v, want %#v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestPayloadValueErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		in   any
+		want string
+	}{
+		{"uint64 overflow", uint64(math.MaxUint64), "overflows int64"},
+		{"uint overflow", uint(math.MaxInt64) + 1, "overflows int64"},
+		{"overflow in slice", []uint64{1, math.MaxUint64}, "[1]: integer"},
+		{"invalid UTF-8", "ok\xff", "invalid UTF-8"},
+		{"invalid UTF-8 in slice", []string{"ok", "\xfe"}, "[1]: invalid UTF-8"},
+		{"invalid UTF-8 in map value", map[string]string{"k": "\xff"}, "k: invalid UTF-8"},
+		{"invalid UTF-8 in map key", map[string]int{"\xff": 1}, "invalid UTF-8 in key"},
+		{"unsupported type", make(chan int), "unsupported type chan int"},
+		{"function", func() {}, "unsupported type"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := payloadValue(tc.in)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("error = %v, want it to contain %q", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestChunkPayloadRoundTrip(t *testing.T) {
+	doc := &schema.Document{
+		ID:      "chunk",
+		Content: "内容",
+		MetaData: map[string]any{
+			file.MetaKeySource: "docs/a.md",
+			MetaKeyChunkIndex:  2,
+			MetaKeyHeadings:    []string{"介绍", "安装"},
+			DocMetaDataVector:  []float64{0.1},
+			QdrantPayloadKey:   "overwritten content",
+		},
+	}
+	payload, err := chunkPayload(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := payload[QdrantPayloadKey].GetStringValue(); got != "内容" {
+		t.Errorf("content = %q", got)
+	}
+	if got := payload[QdrantSourceKey].GetStringValue(); got != "docs/a.md" {
+		t.Errorf("source = %q", got)
+	}
+	want := map[string]any{
+		file.MetaKeySource: "docs/a.md",
+		MetaKeyChunkIndex:  int64(2),
+		MetaKeyHeadings:    []any{"介绍", "安装"},
+	}
+	if got := payloadMetaData(payload); !reflect.DeepEqual(got, want) {
+		t.Errorf("metadata = %#v, want %#v", got, want)
+	}
+}
+
+// fixedSplitter returns the given chunks for any document
+type fixedSplitter []string
+
+func (f fixedSplitter) Transform(_ context.Context, src []*schema.Document, _ ...document.TransformerOption) ([]*schema.Document, error) {
+	chunks := make([]*schema.Document, len(f))
+	for i, content := range f {
+		chunks[i] = &schema.Document{ID: src[0].ID, Content: content, MetaData: map[string]any{file.MetaKeyExtension: ".md"}}
+	}
+	return chunks, nil
+}
+
+func TestChunkMetadataTransformer(t *testing.T) {
+	content := "# 介绍\nEino 是框架。\n## 安装\n运行 go get。\n# 附录\n重复。重复。\n"
+	type chunkMeta struct {
+		start, end int
+		headings   []string
+	}
+	tests := []struct {
+		name   string
+		ext    string
+		chunks []string
+		want   []chunkMeta
+	}{
+		{
+			name:   "overlapping chunks",
+			ext:    ".md",
+			chunks: []string{"# 介绍\nEino 是框架。\n", "是框架。\n## 安装\n运行", "运行 go get。\n# 附录\n"},
+			want: []chunkMeta{
+				{0, 15, []string{"介绍"}},
+				{10, 23, []string{"介绍"}},
+				{21, 37, []string{"介绍", "安装"}},
+			},
+		},
+		{
+			name:   "repeated content",
+			ext:    ".md",
+			chunks: []string{"重复。", "重复。"},
+			want: []chunkMeta{
+				{37, 40, []string{"附录"}},
+				{40, 43, []string{"附录"}},
+			},
+		},
+		{
+			name:   "plain text has no headings",
+			ext:    ".txt",
+			chunks: []string{"## 安装\n运行 go get。"},
+			want:   []chunkMeta{{15, 31, nil}},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			doc := &schema.Document{ID: "doc", Content: content, MetaData: map[string]any{file.MetaKeyExtension: tc.ext}}
+			chunks, err := NewChunkMetadataTransformer(fixedSplitter(tc.chunks)).Transform(context.Background(), []*schema.Document{doc})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(chunks) != len(tc.want) {
+				t.Fatalf("got %d chunks, want %d", len(chunks), len(tc.want))
+			}
+			runes := []rune(content)
+			for i, chunk := range chunks {
+				got := chunkMeta{
+					start:    chunk.MetaData[MetaKeyCharStart].(int),
+					end:      chunk.MetaData[MetaKeyCharEnd].(int),
+					headings: metaStrings(chunk.MetaData, MetaKeyHeadings),
+				}
+				if !reflect.DeepEqual(got, tc.want[i]) {
+					t.Errorf("chunk %d = %+v, want %+v", i, got, tc.want[i])
+				}
+				if idx := chunk.MetaData[MetaKeyChunkIndex]; idx != i {
+					t.Errorf("chunk %d has index %v", i, idx)
+				}
+				if s := string(runes[got.start:got.end]); s != chunk.Content {
+					t.Errorf("chunk %d offsets point at %q, want %q", i, s, chunk.Content)
+				}
+			}
+		})
+	}
+}
+
+func TestChunkMetadataTransformerWithSplitter(t *testing.T) {
+	content := strings.Repeat("# 第一章\n大模型应用开发框架，支持组件编排。\n\n## 第一节\n检索增强生成把文档切分成块。\n\n", 3)
+	splitter, err := recursive.NewSplitter(context.Background(), &recursive.Config{ChunkSize: 40, OverlapSize: 15})
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := &schema.Document{ID: "doc", Content: content, MetaData: map[string]any{file.MetaKeyExtension: ".md"}}
+	chunks, err := NewChunkMetadataTransformer(splitter).Transform(context.Background(), []*schema.Document{doc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) < 3 {
+		t.Fatalf("got %d chunks, want the text split into several", len(chunks))
+	}
+	runes := []rune(content)
+	prev := -1
+	for i, chunk := range chunks {
+		start, ok1 := chunk.MetaData[MetaKeyCharStart].(int)
+		end, ok2 := chunk.MetaData[MetaKeyCharEnd].(int)
+		if !ok1 || !ok2 {
+			t.Fatalf("chunk %d %q was not located", i, chunk.Content)
+		}
+		if s := string(runes[start:end]); s != chunk.Content {
+			t.Errorf("chunk %d offsets %d-%d point at %q, want %q", i, start, end, s, chunk.Content)
+		}
+		if start <= prev {
+			t.Errorf("chunk %d starts at %d, not after the previous chunk at %d", i, start, prev)
+		}
+		prev = start
+		if h := metaStrings(chunk.MetaData, MetaKeyHeadings); len(h) == 0 || h[0] != "第一章" {
+			t.Errorf("chunk %d headings = %v", i, h)
+		}
+	}
+}
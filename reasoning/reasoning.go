@@ -0,0 +1,128 @@
+// Package reasoning separates a model's thinking from its answer. Thinking
+// models report it either in Message.ReasoningContent or inline as a
+// <think>...</think> block at the start of the content; Splitter handles
+// both, including tags split across stream chunks.
+package reasoning
+
+import (
+	"strings"
+
+	"github.com/cloudwego/eino/schema"
+)
+
+const (
+	openTag  = "<think>"
+	closeTag = "</think>"
+)
+
+// Delta is the part of a chunk that belongs to the reasoning and to the answer
+type Delta struct {
+	Reasoning string
+	Content   string
+}
+
+// Splitter splits a stream of message chunks into reasoning and answer.
+// A Splitter must not be reused across streams.
+type Splitter struct {
+	inThink       bool
+	pending       string
+	answerStarted bool
+}
+
+// Push consumes one chunk of the stream
+func (s *Splitter) Push(msg *schema.Message) Delta {
+	d := s.split(msg.Content)
+	d.Reasoning = msg.ReasoningContent + d.Reasoning
+	return d
+}
+
+// Flush returns text held back while waiting for a possible tag; call it
+// once the stream has ended
+func (s *Splitter) Flush() Delta {
+	rest := s.pending
+	s.pending = ""
+	if s.inThink {
+		return Delta{Reasoning: rest}
+	}
+	return Delta{Content: s.answer(rest)}
+}
+
+func (s *Splitter) split(text string) Delta {
+	var d Delta
+	buf := s.pending + text
+	s.pending = ""
+	for buf != "" {
+		// Only a block at the very start of the answer is treated as thinking
+		if !s.inThink && s.answerStarted {
+			s.emit(&d, buf)
+			break
+		}
+		tag := openTag
+		if s.inThink {
+			tag = closeTag
+		}
+		i := strings.Index(buf, tag)
+		if i >= 0 && !s.inThink && strings.TrimSpace(buf[:i]) != "" {
+			// Text before the tag means the answer has already begun
+			s.emit(&d, buf)
+			break
+		}
+		if i >= 0 {
+			// Whitespace before an opening tag is not part of the answer
+			if s.inThink {
+				s.emit(&d, buf[:i])
+			}
+			buf = buf[i+len(tag):]
+			s.inThink = !s.inThink
+			continue
+		}
+		// Hold back a suffix that may be the beginning of a split tag, and
+		// leading whitespace that may come before one
+		keep := partialSuffix(buf, tag)
+		if !s.inThink && strings.TrimSpace(buf[:len(buf)-keep]) == "" {
+			keep = len(buf)
+		}
+		s.emit(&d, buf[:len(buf)-keep])
+		s.pending = buf[len(buf)-keep:]
+		break
+	}
+	return d
+}
+
+func (s *Splitter) emit(d *Delta, text string) {
+	if s.inThink {
+		d.Reasoning += text
+		return
+	}
+	d.Content += s.answer(text)
+}
+
+// answer drops the newlines models put between the thinking and the answer
+func (s *Splitter) answer(text string) string {
+	if !s.answerStarted {
+		text = strings.TrimLeft(text, "\r\n")
+		s.answerStarted = text != ""
+	}
+	return text
+}
+
+// partialSuffix returns the length of the longest suffix of text that is a
+// proper prefix of tag
+func partialSuffix(text, tag string) int {
+	for n := len(tag) - 1; n > 0; n-- {
+		if strings.HasSuffix(text, tag[:n]) {
+			return n
+		}
+	}
+	return 0
+}
+
+// Split separates reasoning and answer in a complete message
+func Split(msg *schema.Message) Delta {
+	var s Splitter
+	d := s.Push(msg)
+	rest := s.Flush()
+	d.Reasoning += rest.Reasoning
+	d.Content += rest.Content
+	return d
+}
@@ -0,0 +1,57 @@
+package reasoning
+
+import (
+	"testing"
+
+	"github.com/cloudwego/eino/schema"
+)
+
+// splitChunks feeds the chunks to a Splitter and joins what it returns
+func splitChunks(chunks ...string) Delta {
+	var s Splitter
+	var out Delta
+	for _, c := range chunks {
+		d := s.Push(&schema.Message{Role: schema.Assistant, Content: c})
+		out.Reasoning += d.Reasoning
+		out.Content += d.Content
+	}
+	d := s.Flush()
+	out.Reasoning += d.Reasoning
+	out.Content += d.Content
+	return out
+}
+
+func TestSplitter(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks []string
+		want   Delta
+	}{
+		{"no think block", []string{"The ", "answer"}, Delta{Content: "The answer"}},
+		{"indented answer", []string{"  code"}, Delta{Content: "  code"}},
+		{"think block", []string{"<think>why</think>\n\nAnswer"}, Delta{Reasoning: "why", Content: "Answer"}},
+		{"tags split across chunks", []string{"<th", "ink>wh", "y</th", "ink>", "\n\nAns", "wer"}, Delta{Reasoning: "why", Content: "Answer"}},
+		{"one byte at a time", []string{"<", "t", "h", "i", "n", "k", ">", "a", "<", "/", "t", "h", "i", "n", "k", ">", "\n", "b"}, Delta{Reasoning: "a", Content: "b"}},
+		{"leading whitespace", []string{" <think>x</think>\n\nAns"}, Delta{Reasoning: "x", Content: "Ans"}},
+		{"leading whitespace in its own chunk", []string{"\n ", " <thi", "nk>x</think>", "\n", "\nAns"}, Delta{Reasoning: "x", Content: "Ans"}},
+		{"tag after the answer began", []string{"Use <think> tags"}, Delta{Content: "Use <think> tags"}},
+		{"tag in a later chunk", []string{"Use ", "<think>", " tags"}, Delta{Content: "Use <think> tags"}},
+		{"unterminated think block", []string{"<think>still ", "thinking</thi"}, Delta{Reasoning: "still thinking</thi"}},
+		{"partial tag at the end", []string{"a <thi"}, Delta{Content: "a <thi"}},
+		{"whitespace only", []string{"\n\n"}, Delta{}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := splitChunks(tc.chunks...); got != tc.want {
+				t.Errorf("got %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSplitReasoningContent(t *testing.T) {
+	got := Split(&schema.Message{Role: schema.Assistant, ReasoningContent: "why", Content: "Answer"})
+	if want := (Delta{Reasoning: "why", Content: "Answer"}); got != want {
+		t.Errorf("Split() = %+v, want %+v", got, want)
+	}
+}
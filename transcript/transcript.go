@@ -0,0 +1,295 @@
+// Package transcript exports conversations for sharing and imports them back.
+// A conversation can be written as a Markdown transcript, as raw JSON of
+// schema.Message (reasoning and usage included), or as an OpenAI "messages"
+// array. Both JSON formats can be read back to seed a new conversation.
+package transcript
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cloudwego/eino/schema"
+)
+
+// Format is an export format
+type Format string
+
+const (
+	Markdown Format = "markdown"
+	JSON     Format = "json"
+	OpenAI   Format = "openai"
+)
+
+// ParseFormat validates a format name
+func ParseFormat(name string) (Format, error) {
+	switch f := Format(strings.ToLower(name)); f {
+	case Markdown, JSON, OpenAI:
+		return f, nil
+	case "md":
+		return Markdown, nil
+	}
+	return "", fmt.Errorf("unknown transcript format %q (want markdown, json or openai)", name)
+}
+
+// FormatForPath guesses the format from a file name: Markdown for .md files,
+// raw JSON otherwise
+func FormatForPath(path string) Format {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".md", ".markdown":
+		return Markdown
+	}
+	return JSON
+}
+
+// Ext returns the file extension for a format
+func (f Format) Ext() string {
+	if f == Markdown {
+		return ".md"
+	}
+	return ".json"
+}
+
+// ContentType returns the MIME type for a format
+func (f Format) ContentType() string {
+	if f == Markdown {
+		return "text/markdown; charset=utf-8"
+	}
+	return "application/json"
+}
+
+// Transcript is a conversation together with its title
+type Transcript struct {
+	Title      string            `json:"title,omitempty"`
+	ExportedAt time.Time         `json:"exported_at"`
+	Messages   []*schema.Message `json:"messages"`
+}
+
+// OpenAIMessage is one entry of an OpenAI chat completions "messages" array
+type OpenAIMessage struct {
+	Role       string            `json:"role"`
+	Content    string            `json:"content"`
+	Name       string            `json:"name,omitempty"`
+	ToolCallID string            `json:"tool_call_id,omitempty"`
+	ToolCalls  []schema.ToolCall `json:"tool_calls,omitempty"`
+}
+
+// Write exports a conversation in the given format
+func Write(w io.Writer, f Format, t *Transcript) error {
+	switch f {
+	case Markdown:
+		return writeMarkdown(w, t)
+	case JSON:
+		return encode(w, t)
+	case OpenAI:
+		return encode(w, ToOpenAI(t.Messages))
+	}
+	return fmt.Errorf("unknown transcript format %q", f)
+}
+
+func encode(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(v)
+}
+
+// ToOpenAI converts messages to the OpenAI wire format. Reasoning and usage
+// have no place there and are dropped.
+func ToOpenAI(msgs []*schema.Message) []OpenAIMessage {
+	out := make([]OpenAIMessage, 0, len(msgs))
+	for _, m := range msgs {
+		out = append(out, OpenAIMessage{
+			Role:       string(m.Role),
+			Content:    text(m),
+			Name:       m.Name,
+			ToolCallID: m.ToolCallID,
+			ToolCalls:  m.ToolCalls,
+		})
+	}
+	return out
+}
+
+// text returns the content without the trailing newline the chat histories
+// append to every message
+func text(m *schema.Message) string {
+	return strings.TrimSuffix(m.Content, "\n")
+}
+
+func writeMarkdown(w io.Writer, t *Transcript) error {
+	var b strings.Builder
+	title := t.Title
+	if title == "" {
+		title = "Conversation"
+	}
+	fmt.Fprintf(&b, "# %s\n\n", title)
+	if !t.ExportedAt.IsZero() {
+		fmt.Fprintf(&b, "_Exported %s_\n\n", t.ExportedAt.Format(time.RFC3339))
+	}
+	for _, m := range t.Messages {
+		fmt.Fprintf(&b, "## %s\n\n", roleHeading(m.Role))
+		if m.ReasoningContent != "" {
+			b.WriteString("<details>\n<summary>Thinking</summary>\n\n")
+			b.WriteString(strings.TrimSpace(m.ReasoningContent))
+			b.WriteString("\n\n</details>\n\n")
+		}
+		if body := strings.TrimSpace(text(m)); body != "" {
+			b.WriteString(body)
+			b.WriteString("\n\n")
+		}
+		for _, tc := range m.ToolCalls {
+			fmt.Fprintf(&b, "Tool call `%s`:\n\n```json\n%s\n```\n\n", tc.Function.Name, tc.Function.Arguments)
+		}
+		// go-chat-server flags answers whose generation was stopped
+		if truncated, _ := m.Extra["truncated"].(bool); truncated {
+			b.WriteString("_(generation stopped)_\n\n")
+		}
+		if m.ResponseMeta != nil && m.ResponseMeta.Usage != nil {
+			u := m.ResponseMeta.Usage
+			fmt.Fprintf(&b, "_tokens: %d prompt / %d completion_\n\n", u.PromptTokens, u.CompletionTokens)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func roleHeading(role schema.RoleType) string {
+	switch role {
+	case schema.User:
+		return "User"
+	case schema.Assistant:
+		return "Assistant"
+	case schema.System:
+		return "System"
+	case schema.Tool:
+		return "Tool"
+	}
+	return string(role)
+}
+
+// Read parses a conversation exported as JSON or as OpenAI messages. It
+// accepts a Transcript, an object with a "messages" field (such as a chat
+// completions request body) or a bare array of messages. Content given as an
+// array of parts keeps its text parts only. Message IDs in Extra must be
+// unique and parent IDs must refer to an earlier message, so that the
+// messages always form a tree.
+func Read(r io.Reader) (*Transcript, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	data = []byte(strings.TrimSpace(string(data)))
+	if len(data) == 0 {
+		return nil, fmt.Errorf("empty transcript")
+	}
+
+	var doc struct {
+		Title    string            `json:"title"`
+		Messages []json.RawMessage `json:"messages"`
+	}
+	if data[0] == '[' {
+		err = json.Unmarshal(data, &doc.Messages)
+	} else {
+		err = json.Unmarshal(data, &doc)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse transcript: %w", err)
+	}
+
+	t := &Transcript{Title: doc.Title, Messages: make([]*schema.Message, 0, len(doc.Messages))}
+	for i, raw := range doc.Messages {
+		msg, err := readMessage(raw)
+		if err != nil {
+			return nil, fmt.Errorf("messages[%d]: %w", i, err)
+		}
+		t.Messages = append(t.Messages, msg)
+	}
+	if err := checkTree(t.Messages); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Keys go-chat-server links messages into a tree with, in Message.Extra
+const (
+	idKey       = "id"
+	parentIDKey = "parent_id"
+)
+
+// checkTree rejects IDs that would link messages into a broken or cyclic tree
+func checkTree(msgs []*schema.Message) error {
+	seen := make(map[string]bool, len(msgs))
+	for i, m := range msgs {
+		// A missing or empty parent makes a root
+		if v := m.Extra[parentIDKey]; v != nil && v != "" {
+			if parent, _ := v.(string); !seen[parent] {
+				return fmt.Errorf("messages[%d]: %s %v does not refer to an earlier message", i, parentIDKey, v)
+			}
+		}
+		v, ok := m.Extra[idKey]
+		if !ok {
+			continue
+		}
+		id, _ := v.(string)
+		if id == "" || seen[id] {
+			return fmt.Errorf("messages[%d]: %s %v is empty or not unique", i, idKey, v)
+		}
+		seen[id] = true
+	}
+	return nil
+}
+
+func readMessage(raw json.RawMessage) (*schema.Message, error) {
+	// The content is decoded separately as it may be a string or a list of parts
+	var msg schema.Message
+	fields := struct {
+		*schema.Message
+		Content json.RawMessage `json:"content"`
+	}{Message: &msg}
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, err
+	}
+	content, err := readContent(fields.Content)
+	if err != nil {
+		return nil, err
+	}
+	msg.Content = content
+
+	switch msg.Role {
+	case schema.System, schema.User, schema.Assistant, schema.Tool:
+	case "developer":
+		msg.Role = schema.System
+	case "":
+		return nil, fmt.Errorf("missing role")
+	default:
+		return nil, fmt.Errorf("unsupported role %q", msg.Role)
+	}
+	return &msg, nil
+}
+
+func readContent(raw json.RawMessage) (string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return "", nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s, nil
+	}
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(raw, &parts); err != nil {
+		return "", fmt.Errorf("content must be a string or an array of parts")
+	}
+	var b strings.Builder
+	for _, p := range parts {
+		if p.Type == "text" {
+			b.WriteString(p.Text)
+		}
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,152 @@
+package transcript
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cloudwego/eino/schema"
+)
+
+func testMessages() []*schema.Message {
+	return []*schema.Message{
+		{Role: schema.System, Content: "You are helpful.\n"},
+		{Role: schema.User, Content: "你好\n", Extra: map[string]any{"id": "u1", "parent_id": ""}},
+		{
+			Role:             schema.Assistant,
+			Content:          "你好！\n",
+			ReasoningContent: "greet back",
+			ResponseMeta:     &schema.ResponseMeta{FinishReason: "stop", Usage: &schema.TokenUsage{PromptTokens: 12, CompletionTokens: 3, TotalTokens: 15}},
+			Extra:            map[string]any{"id": "a1", "parent_id": "u1", "provider": "main", "truncated": true},
+		},
+		{Role: schema.User, Content: "What time is it?\n", Extra: map[string]any{"id": "u2", "parent_id": "a1"}},
+		{Role: schema.Assistant, ToolCalls: []schema.ToolCall{{ID: "call-1", Type: "function", Function: schema.FunctionCall{Name: "current_time", Arguments: "{}"}}}},
+		{Role: schema.Tool, Content: "12:00", ToolCallID: "call-1"},
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	in := &Transcript{Title: "问候", ExportedAt: time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC), Messages: testMessages()}
+	var buf bytes.Buffer
+	if err := Write(&buf, JSON, in); err != nil {
+		t.Fatal(err)
+	}
+	out, err := Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Title != in.Title {
+		t.Errorf("title = %q, want %q", out.Title, in.Title)
+	}
+	if !reflect.DeepEqual(out.Messages, in.Messages) {
+		for i := range in.Messages {
+			t.Errorf("message %d:\n got %+v\nwant %+v", i, out.Messages[i], in.Messages[i])
+		}
+	}
+}
+
+func TestOpenAIRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, OpenAI, &Transcript{Messages: testMessages()}); err != nil {
+		t.Fatal(err)
+	}
+	out, err := Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := testMessages()
+	if len(out.Messages) != len(want) {
+		t.Fatalf("got %d messages, want %d", len(out.Messages), len(want))
+	}
+	for i, m := range out.Messages {
+		w := want[i]
+		if m.Role != w.Role || m.Content != strings.TrimSuffix(w.Content, "\n") || m.ToolCallID != w.ToolCallID || !reflect.DeepEqual(m.ToolCalls, w.ToolCalls) {
+			t.Errorf("message %d = %+v, want %+v", i, m, w)
+		}
+		if m.ReasoningContent != "" || m.Extra != nil {
+			t.Errorf("message %d kept fields the OpenAI format has no place for: %+v", i, m)
+		}
+	}
+}
+
+func TestRead(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []*schema.Message
+		err   string
+	}{
+		{
+			name:  "bare array",
+			input: `[{"role": "user", "content": "hi"}]`,
+			want:  []*schema.Message{{Role: schema.User, Content: "hi"}},
+		},
+		{
+			name:  "request body with content parts",
+			input: `{"model": "m", "messages": [{"role": "developer", "content": "be brief"}, {"role": "user", "content": [{"type": "text", "text": "a"}, {"type": "image_url"}, {"type": "text", "text": "b"}]}]}`,
+			want:  []*schema.Message{{Role: schema.System, Content: "be brief"}, {Role: schema.User, Content: "ab"}},
+		},
+		{
+			name:  "null parent is a root",
+			input: `[{"role": "user", "content": "hi", "extra": {"id": "u1", "parent_id": null}}]`,
+			want:  []*schema.Message{{Role: schema.User, Content: "hi", Extra: map[string]any{"id": "u1", "parent_id": nil}}},
+		},
+		{name: "empty", input: "  ", err: "empty transcript"},
+		{name: "missing role", input: `[{"content": "hi"}]`, err: "messages[0]: missing role"},
+		{name: "unsupported role", input: `[{"role": "function", "content": "hi"}]`, err: `unsupported role "function"`},
+		{name: "bad content", input: `[{"role": "user", "content": 5}]`, err: "content must be a string"},
+		{name: "unknown parent", input: `[{"role": "user", "content": "a", "extra": {"id": "u1", "parent_id": "x"}}]`, err: "messages[0]: parent_id x does not refer to an earlier message"},
+		{name: "own parent", input: `[{"role": "user", "content": "a", "extra": {"id": "u1", "parent_id": "u1"}}]`, err: "parent_id u1 does not refer"},
+		{
+			name:  "cycle",
+			input: `[{"role": "user", "content": "a", "extra": {"id": "u1", "parent_id": "a1"}}, {"role": "assistant", "content": "b", "extra": {"id": "a1", "parent_id": "u1"}}]`,
+			err:   "messages[0]: parent_id a1 does not refer",
+		},
+		{name: "parent not a string", input: `[{"role": "user", "content": "a", "extra": {"parent_id": 1}}]`, err: "parent_id 1 does not refer"},
+		{
+			name:  "duplicate ID",
+			input: `[{"role": "user", "content": "a", "extra": {"id": "u1"}}, {"role": "user", "content": "b", "extra": {"id": "u1"}}]`,
+			err:   "messages[1]: id u1 is empty or not unique",
+		},
+		{name: "empty ID", input: `[{"role": "user", "content": "a", "extra": {"id": ""}}]`, err: "id  is empty"},
+		{name: "ID not a string", input: `[{"role": "user", "content": "a", "extra": {"id": 7}}]`, err: "id 7 is empty or not unique"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Read(strings.NewReader(tc.input))
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("error = %v, want it to contain %q", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got.Messages, tc.want) {
+				t.Errorf("messages = %+v, want %+v", got.Messages, tc.want)
+			}
+		})
+	}
+}
+
+func TestWriteMarkdown(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, Markdown, &Transcript{Title: "问候", Messages: testMessages()}); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"# 问候\n",
+		"## User\n\n你好\n\n",
+		"<summary>Thinking</summary>\n\ngreet back\n\n</details>",
+		"_(generation stopped)_",
+		"_tokens: 12 prompt / 3 completion_",
+		"Tool call `current_time`:",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("markdown lacks %q:\n%s", want, buf.String())
+		}
+	}
+}